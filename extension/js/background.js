@@ -28,10 +28,23 @@ function onNativeMessage(msg) {
     return;
   }
 
+  // Developer mode: a local folder changed, reload the tabs showing it
+  if (msg.event === "reload" && msg.url && RT.tabs) {
+    RT.tabs.query({}).then(tabs => {
+      for (const tab of tabs) {
+        if (tab.url && tab.url.startsWith(msg.url)) RT.tabs.reload(tab.id);
+      }
+    }).catch(() => {});
+  }
+
   // Forward native events (sync_progress, sync_complete, etc.) to dashboard
   if (msg.event) {
     RT.runtime.sendMessage(msg).catch(() => {
-      // Dashboard may not be open — ignore
+      // Dashboard may not be open — wallet prompts need an answer, so open
+      // it; it picks up pending prompts on load
+      if (msg.event === "wallet_prompt" && RT.tabs) {
+        RT.tabs.create({ url: RT.runtime.getURL("dashboard/dashboard.html") }).catch(() => {});
+      }
     });
   }
 }
@@ -196,7 +196,7 @@ connectNodeBtn.onclick = async () => {
 loadBtn.onclick = async () => {
   const scid = scidInput.value.trim();
   if (!scid) return alert("Enter SCID first");
-  if (!nodeInput.value.trim()) return alert("Set node first");
+  // Without a node the host can still serve previously loaded SCIDs from its cache
 
   setDotText(statusEl, "pending", "Loading SCID...");
 
@@ -214,8 +214,18 @@ loadBtn.onclick = async () => {
       return;
     }
 
-    setDotText(statusEl, "connected", "SCID loaded");
-    window.open(url, "_blank");
+    const warnings = r.result.author?.warnings || [];
+    if (r.result.stale) {
+      setDotText(statusEl, "warning", "SCID loaded from offline cache (may be outdated)");
+    } else if (warnings.length) {
+      setDotText(statusEl, "warning", "SCID loaded: " + warnings.join("; "));
+    } else {
+      const libs = r.result.libraries || [];
+      setDotText(statusEl, "connected", libs.length
+        ? `SCID loaded with ${libs.length} librar${libs.length === 1 ? "y" : "ies"}`
+        : "SCID loaded");
+    }
+    window.open(url + chooseEntry(r.result), "_blank");
 
     const listResp = await send("list_scids");
     if (listResp.ok) updateSCIDList(listResp.result.scids);
@@ -226,6 +236,18 @@ loadBtn.onclick = async () => {
   }
 };
 
+// When the host could not tell which page opens the app it returns every
+// candidate; let the user pick one (empty = the host's default entry)
+function chooseEntry(result) {
+  const candidates = result?.candidates || [];
+  if (candidates.length < 2) return "";
+  const list = candidates.map((c, i) => `${i + 1}. ${c}`).join("\n");
+  const pick = prompt(`This app has several entry pages:\n${list}\n\nOpen which one?`, "1");
+  const idx  = Number(pick) - 1;
+  if (!Number.isInteger(idx) || !candidates[idx]) return "";
+  return candidates[idx].split("/").map(encodeURIComponent).join("/");
+}
+
 // ================= STATUS =================
 async function updateStatusIndicators() {
   try {
@@ -282,6 +304,8 @@ async function autoConnect() {
 document.addEventListener("DOMContentLoaded", () => {
   initBookmarks();
   autoConnect();
+  loadPendingPrompts();
+  subscribeLogs();
 });
 
 // ================= DEFAULT BOOKMARKS =================
@@ -618,6 +642,21 @@ RT.runtime.onMessage.addListener((msg) => {
     if (pageGnomonStatus) setStatus(pageGnomonStatus, false);
     resetSyncProgress();
 
+  } else if (msg.event === "node_changed") {
+    // The node is shared by every tab and browser using the host, so
+    // follow changes made elsewhere
+    const node = typeof msg.node === "string" ? msg.node.replace("http://", "") : "";
+    const connected = connectNodeBtn.textContent === "Disconnect";
+    if (node && (!connected || nodeInput.value.trim() !== node)) {
+      nodeInput.value = node;
+      setNodeConnected(true, node);
+      document.dispatchEvent(new CustomEvent("nodeConnected", { detail: { node } }));
+    } else if (!node && connected) {
+      setNodeConnected(false);
+      document.dispatchEvent(new CustomEvent("nodeDisconnected"));
+    }
+    updateStatusIndicators();
+
   } else if (msg.cmd === "native_disconnect") {
     if (sidebarTelaStatus) setStatus(sidebarTelaStatus, false);
     if (sidebarGnomonStatus) setStatus(sidebarGnomonStatus, false);
@@ -626,8 +665,117 @@ RT.runtime.onMessage.addListener((msg) => {
     if (statusEl) setDotText(statusEl, "error", "Disconnected");
     resetSyncProgress();
 
+  } else if (msg.event === "wallet_prompt") {
+    answerWalletPrompt(msg);
+
+  } else if (msg.event === "log") {
+    if (!msg.tab || msg.tab === logTab) appendLogLine(msg);
+
   } else if (msg.cmd === "native_connect") {
     autoConnect();
     startSyncPolling();
+    subscribeLogs();
+  }
+});
+
+// ================= WALLET PROMPTS =================
+// A page served by the host asked to use the wallet through its bridge.
+// Name the on-chain site asking so the user knows who they are trusting.
+const answeredPrompts = new Set();
+
+async function answerWalletPrompt(p) {
+  if (answeredPrompts.has(p.prompt)) return;
+  answeredPrompts.add(p.prompt);
+
+  const site = p.dURL && p.dURL !== p.scid ? `${p.dURL} (${p.scid.slice(0, 12)}…)` : p.scid;
+  const action = p.method === "connect"
+    ? `connect "${p.app || "unnamed app"}" to your wallet`
+    : p.method === "fetch"
+      ? `fetch ${p.params}`
+      : `call ${p.method} on your wallet`;
+  const details = p.params && p.method !== "fetch" ? "\n\n" + JSON.stringify(p.params, null, 2).slice(0, 600) : "";
+  const allow = confirm(`${site} wants to ${action}.${details}\n\nAllow?`);
+  const what = p.capability === "walletSign" ? "signing and transfers"
+    : p.capability === "externalFetch" ? "external requests"
+    : "wallet reads";
+  const remember = confirm(`Always ${allow ? "allow" : "deny"} ${what} for ${site}?`);
+  await send("wallet_prompt_reply", { prompt: p.prompt, allow, remember });
+}
+
+// Prompts raised while the dashboard was closed
+async function loadPendingPrompts() {
+  try {
+    const r = await send("list_wallet_prompts");
+    for (const p of r.result?.prompts || []) await answerWalletPrompt(p);
+  } catch {
+    // Native host unavailable
+  }
+}
+// ================= NATIVE LOGS =================
+// Recent history first, then live log events from the native host. Each
+// dashboard subscribes under its own tab id, so open dashboards can follow
+// different filters.
+const logView = document.getElementById("log-view");
+const logLevelSelect = document.getElementById("log-level");
+const logSubsystemSelect = document.getElementById("log-subsystem");
+const MAX_LOG_LINES = 500;
+const logTab = crypto.randomUUID();
+
+function appendLogLine(e) {
+  if (!logView) return;
+  const line = document.createElement("div");
+  line.className = "log-" + e.level;
+  const attrs = Object.entries(e.attrs || {}).map(([k, v]) => `${k}=${v}`).join(" ");
+  const time = new Date(e.time).toLocaleTimeString();
+  line.textContent = `${time} ${e.level.toUpperCase()} [${e.subsystem}] ${e.msg}${attrs ? " " + attrs : ""}`;
+
+  const atBottom = logView.scrollTop + logView.clientHeight >= logView.scrollHeight - 4;
+  logView.appendChild(line);
+  while (logView.childElementCount > MAX_LOG_LINES) logView.firstChild.remove();
+  if (atBottom) logView.scrollTop = logView.scrollHeight;
+}
+
+async function subscribeLogs() {
+  if (!logView) return;
+  const subsystem = logSubsystemSelect.value;
+  try {
+    const r = await send("subscribe_logs", {
+      tab: logTab,
+      level: logLevelSelect.value,
+      subsystems: subsystem ? [subsystem] : []
+    });
+    if (!r?.ok) return;
+
+    if (logSubsystemSelect.options.length === 1) {
+      for (const name of r.result.subsystems || []) {
+        logSubsystemSelect.appendChild(new Option(name, name));
+      }
+    }
+    logView.replaceChildren();
+    for (const e of r.result.history || []) appendLogLine(e);
+    logView.scrollTop = logView.scrollHeight;
+  } catch {
+    // Native host unavailable
+  }
+}
+
+logLevelSelect?.addEventListener("change", subscribeLogs);
+logSubsystemSelect?.addEventListener("change", subscribeLogs);
+window.addEventListener("pagehide", () => send("unsubscribe_logs", { tab: logTab }));
+
+// A redacted bundle of host, node, sync and log state to attach to bug reports
+const diagnosticsBtn = document.getElementById("diagnostics-btn");
+const diagnosticsPath = document.getElementById("diagnostics-path");
+
+diagnosticsBtn?.addEventListener("click", async () => {
+  diagnosticsBtn.disabled = true;
+  diagnosticsPath.textContent = "Collecting…";
+  try {
+    const r = await send("diagnostics");
+    diagnosticsPath.textContent = r?.ok ? "Saved to " + r.result.path : "Failed: " + (r?.error || "no response");
+  } catch (e) {
+    diagnosticsPath.textContent = "Failed: " + e.message;
+  } finally {
+    diagnosticsBtn.disabled = false;
   }
-});
\ No newline at end of file
+});
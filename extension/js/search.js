@@ -19,10 +19,12 @@
   if (!searchBox || !resultsEl) return;
 
   // -------------------- Config --------------------
-  const apiBase  = "http://127.0.0.1:8099/api";
+  let apiBase    = "http://127.0.0.1:8099/api"; // replaced by the host's bound address
+  let apiError   = ""; // why the host has no address for the API
   let allResults = [];
   let fuse       = null;
   let minRating  = 30;
+  let authorList = { trusted: {}, blocked: {} }; // from the native host's trusted/blocked list
   let loadToken  = 0; // incremented on each load — stale loads self-cancel
 
   // -------------------- Set default minRating --------------------
@@ -33,13 +35,14 @@
 
   // -------------------- SCID Fetchers --------------------
   async function fetchSCIDData(scid) {
+    if (!apiBase) return null;
     try {
       const resp = await fetch(`${apiBase}/scvarsbyheight?scid=${scid}`);
       if (!resp.ok) return null;
       const data = await resp.json();
       if (!data.variables) return null;
 
-      let dURL = scid, nameHdr = scid, descrHdr = "", iconURL = "", createdHeight = Infinity;
+      let dURL = scid, nameHdr = scid, descrHdr = "", iconURL = "", author = "", createdHeight = Infinity;
       const ratings = [];
 
       data.variables.forEach(v => {
@@ -48,6 +51,7 @@
         else if (key === "nameHdr"    && val) nameHdr  = val;
         else if (key === "descrHdr"   && val) descrHdr = val;
         else if (key === "iconURLHdr" && val) iconURL  = val;
+        else if (key === "owner"      && val) author   = val;
         else if (typeof key === "string" && key.startsWith("dero1")) {
           const [rating, height] = String(val).split("_");
           const h = Number(height);
@@ -63,7 +67,7 @@
         : 0;
 
       return {
-        scid, dURL, nameHdr, descrHdr, iconURL,
+        scid, dURL, nameHdr, descrHdr, iconURL, author,
         likes, dislikes, average,
         createdHeight: createdHeight === Infinity ? 0 : createdHeight
       };
@@ -73,7 +77,44 @@
     }
   }
 
+  // The Gnomon API moves to a free port when 8099 is taken; ask the host.
+  // No URL means the API is not answering, and whatever holds the port is
+  // not to be queried.
+  async function resolveApiBase() {
+    if (typeof send !== "function") return;
+    try {
+      const r = await send("hello");
+      if (r?.ok && r.result?.gnomon) {
+        apiBase  = r.result.gnomon.url || "";
+        apiError = r.result.gnomon.error || "";
+      }
+    } catch {
+      // Native host unavailable — keep the default port
+    }
+  }
+
+  async function fetchAuthorList() {
+    if (typeof send !== "function") return;
+    try {
+      const r = await send("get_authors");
+      if (r?.ok && r.result) authorList = r.result;
+    } catch {
+      // Native host unavailable — show authors without trust marks
+    }
+  }
+
+  function authorTrust(address) {
+    if (authorList.blocked?.[address] !== undefined) return "blocked";
+    if (authorList.trusted?.[address] !== undefined) return "trusted";
+    return "";
+  }
+
+  function shortAddr(address) {
+    return address.length > 16 ? `${address.slice(0, 10)}…${address.slice(-6)}` : address;
+  }
+
   async function fetchAllSCIDs() {
+    if (!apiBase) throw new Error(apiError || "Gnomon API is not available");
     const resp = await fetch(`${apiBase}/indexedscs`);
     if (!resp.ok) throw new Error("Indexed SCID fetch failed");
     const data = await resp.json();
@@ -90,7 +131,8 @@
       statusEl.textContent = "⏳ Fetching indexed SCIDs...";
       resultsEl.replaceChildren();
 
-      const scids = await fetchAllSCIDs();
+      await resolveApiBase();
+      const [scids] = await Promise.all([fetchAllSCIDs(), fetchAuthorList()]);
       if (token !== loadToken) return; // superseded by a newer load
 
       const localResults = [];
@@ -114,7 +156,7 @@
 
       allResults = localResults;
       fuse = new Fuse(allResults, {
-        keys: ["scid", "dURL", "nameHdr", "descrHdr"],
+        keys: ["scid", "dURL", "nameHdr", "descrHdr", "author"],
         threshold: 0.25,
         ignoreLocation: true
       });
@@ -125,7 +167,9 @@
     } catch (err) {
       if (token !== loadToken) return; // suppress errors from stale loads
       console.error("Error loading SCIDs:", err);
-      statusEl.textContent = "❌ Failed loading SCIDs – is Gnomon indexer running?";
+      statusEl.textContent = apiBase
+        ? "❌ Failed loading SCIDs – is Gnomon indexer running?"
+        : `❌ ${apiError || "Gnomon API is not available"}`;
     }
   }
 
@@ -205,7 +249,24 @@
         el.onclick = () => handleSCIDClick(r.scid);
       });
 
-      content.append(urlEl, nameEl, scidEl, descrEl, ratingEl);
+      content.append(urlEl, nameEl, scidEl, descrEl);
+
+      if (r.author) {
+        const trust    = authorTrust(r.author);
+        const authorEl = document.createElement("div");
+        authorEl.className   = trust ? `author ${trust}` : "author";
+        authorEl.textContent = `by ${shortAddr(r.author)}${trust === "trusted" ? " ✔ trusted" : trust === "blocked" ? " ⛔ blocked" : ""}`;
+        authorEl.title       = r.author;
+        content.appendChild(authorEl);
+      }
+
+      const rateEl = document.createElement("span");
+      rateEl.className   = "rate";
+      rateEl.textContent = "Rate";
+      rateEl.onclick     = () => rateSCID(r);
+      ratingEl.append(" ", rateEl);
+
+      content.appendChild(ratingEl);
       div.append(iconSlot, content);
       resultsEl.appendChild(div);
     });
@@ -225,6 +286,41 @@
     if (directLoad && loadBtn) loadBtn.click();
   }
 
+  // -------------------- Rating --------------------
+  // TELA ratings are category * 10 + detail, both 0-9. The wallet
+  // connected to the native host signs and sends the transaction.
+  async function rateSCID(r) {
+    const category = Number(prompt(
+      `Rate ${r.nameHdr}\n0 Do not use, 1 Broken, 2 Major issues, 3 Minor issues, 4 Should be improved,\n` +
+      "5 Could be improved, 6 Average, 7 Good, 8 Very good, 9 Exceptional", "7"));
+    if (!Number.isInteger(category) || category < 0 || category > 9) return;
+    const detail = Number(prompt("Detail (0-9)", "0"));
+    if (!Number.isInteger(detail) || detail < 0 || detail > 9) return;
+
+    const resp = await send("rate_scid", { scid: r.scid, category, detail });
+    statusEl.textContent = resp.ok
+      ? `⏳ Rating sent (${resp.result.txid.slice(0, 12)}…), waiting for confirmation`
+      : `❌ Rating failed: ${resp.error}`;
+  }
+
+  // Refresh a SCID's aggregate once the host reports its rating indexed
+  RT.runtime.onMessage.addListener(async (msg) => {
+    if (msg.event === "rating_confirmed") {
+      statusEl.textContent = `⏳ Rating confirmed at height ${msg.height}, waiting for Gnomon`;
+    } else if (msg.event === "rating_failed") {
+      statusEl.textContent = `❌ Rating failed: ${msg.error}`;
+    } else if (msg.event === "rating_indexed") {
+      const fresh = await fetchSCIDData(msg.scid);
+      const i = allResults.findIndex(r => r.scid === msg.scid);
+      if (fresh && i >= 0) {
+        allResults[i] = fresh;
+        fuse?.setCollection(allResults);
+        renderResults(allResults);
+      }
+      statusEl.textContent = "✅ Rating recorded";
+    }
+  });
+
   // -------------------- Filter --------------------
   function filterResults(query) {
     if (!query.trim()) { renderResults(allResults); return; }
@@ -0,0 +1,573 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/civilware/tela"
+)
+
+// An exported archive holds manifest.json at its root, the app files under
+// app/ and, from format 2, the DOC contracts they were built from under
+// docs/. Archives are zip, tar or gzipped tar, picked by extension.
+const (
+	manifestName     = "manifest.json"
+	archiveAppDir    = "app/"
+	archiveDOCDir    = "docs/"
+	manifestFormat   = 2
+	maxImportBytes   = 512 << 20
+	defaultExtension = ".zip"
+)
+
+// archiveManifest describes an exported SCID well enough to verify and serve
+// it without a node.
+type archiveManifest struct {
+	Format     int                 `json:"format"`
+	SCID       string              `json:"scid"`
+	DURL       string              `json:"dURL"`
+	Height     int64               `json:"height,omitempty"` // chain height of the exported version
+	ExportedAt time.Time           `json:"exportedAt"`
+	Entry      string              `json:"entry"`
+	Author     string              `json:"author"`
+	DOCAuthors []string            `json:"docAuthors"`
+	Verified   bool                `json:"verified"`
+	DOCs       []string            `json:"docs"`
+	Libraries  []library           `json:"libraries,omitempty"`
+	Files      map[string]fileMeta `json:"files"`
+}
+
+// archiveFormat picks the archive format from a file name.
+func archiveFormat(name string) (string, error) {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return "zip", nil
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return "tgz", nil
+	case strings.HasSuffix(lower, ".tar"):
+		return "tar", nil
+	}
+	return "", fmt.Errorf("unsupported archive %q, use .zip, .tar or .tar.gz", filepath.Base(name))
+}
+
+// -------------------- EXPORT --------------------
+
+// exportSCID writes the cached copy of scid and its manifest to dest. An
+// empty dest writes exports/<scid>.zip in the data directory.
+func exportSCID(scid, dest string) (map[string]any, error) {
+	e, dir, ok := cache.lastApp(scid)
+	if !ok {
+		return nil, fmt.Errorf("%s is not cached, load it first", scid)
+	}
+	if bad := checkAppDir(dir, e.Files); len(bad) > 0 {
+		return nil, fmt.Errorf("%w: cached files changed on disk (%s), load the SCID again",
+			errIntegrity, strings.Join(bad, ", "))
+	}
+
+	if dest == "" {
+		exports, err := dataPath("exports")
+		if err != nil {
+			return nil, err
+		}
+		dest = filepath.Join(exports, scid+defaultExtension)
+	}
+	format, err := archiveFormat(dest)
+	if err != nil {
+		return nil, err
+	}
+
+	m := archiveManifest{
+		Format:     manifestFormat,
+		SCID:       scid,
+		DURL:       e.DURL,
+		ExportedAt: time.Now().UTC(),
+		Entry:      e.Entry,
+		Author:     e.Author,
+		DOCAuthors: e.DOCAuthors,
+		Verified:   e.Verified,
+		DOCs:       e.DOCs,
+		Libraries:  e.Libraries,
+		Files:      e.Files,
+	}
+	if p, ok := getPin(scid); ok {
+		m.Height = p.Height
+	}
+	manifest, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	// Written next to dest and renamed, so an interrupted export never
+	// leaves a truncated archive behind
+	tmp := dest + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp)
+
+	paths := make([]string, 0, len(e.Files))
+	for p := range e.Files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	// The DOCs let an import check who published the files. Without all of
+	// them the archive still imports, with an unknown author.
+	docs := map[string][]byte{}
+	for _, d := range e.DOCs {
+		doc, ok := cache.getDOC(d)
+		if !ok {
+			logTELA.Warn("exporting without DOCs, one is not cached", "scid", scid, "doc", d)
+			docs = nil
+			break
+		}
+		if docs[d], err = json.Marshal(doc); err != nil {
+			return nil, err
+		}
+	}
+
+	var size int64
+	w := newArchiveWriter(f, format)
+	err = w.add(manifestName, manifest, m.ExportedAt)
+	for _, d := range e.DOCs {
+		if err != nil || docs == nil {
+			break
+		}
+		err = w.add(archiveDOCDir+d+".json", docs[d], m.ExportedAt)
+	}
+	for _, p := range paths {
+		if err != nil {
+			break
+		}
+		var data []byte
+		if data, err = os.ReadFile(filepath.Join(dir, filepath.FromSlash(p))); err == nil {
+			err = w.add(archiveAppDir+p, data, m.ExportedAt)
+			size += int64(len(data))
+		}
+	}
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		return nil, err
+	}
+
+	logTELA.Info("exported", "scid", scid, "dest", dest, "files", len(paths), "bytes", size)
+	return map[string]any{
+		"scid":   scid,
+		"path":   dest,
+		"format": format,
+		"files":  len(paths),
+		"bytes":  size,
+	}, nil
+}
+
+// archiveWriter adds files to a zip or tar stream.
+type archiveWriter struct {
+	zw *zip.Writer
+	tw *tar.Writer
+	gz *gzip.Writer
+}
+
+func newArchiveWriter(w io.Writer, format string) *archiveWriter {
+	switch format {
+	case "zip":
+		return &archiveWriter{zw: zip.NewWriter(w)}
+	case "tgz":
+		gz := gzip.NewWriter(w)
+		return &archiveWriter{tw: tar.NewWriter(gz), gz: gz}
+	}
+	return &archiveWriter{tw: tar.NewWriter(w)}
+}
+
+func (a *archiveWriter) add(name string, data []byte, mod time.Time) error {
+	if a.zw != nil {
+		fw, err := a.zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: mod})
+		if err != nil {
+			return err
+		}
+		_, err = fw.Write(data)
+		return err
+	}
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: mod, Typeflag: tar.TypeReg}
+	if err := a.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := a.tw.Write(data)
+	return err
+}
+
+func (a *archiveWriter) Close() error {
+	if a.zw != nil {
+		return a.zw.Close()
+	}
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	if a.gz != nil {
+		return a.gz.Close()
+	}
+	return nil
+}
+
+// -------------------- IMPORT --------------------
+
+// readArchive returns the regular files of an archive keyed by name. At most
+// maxImportBytes are read in total.
+func readArchive(src string) (map[string][]byte, error) {
+	format, err := archiveFormat(src)
+	if err != nil {
+		return nil, err
+	}
+	files := map[string][]byte{}
+	budget := int64(maxImportBytes)
+
+	read := func(name string, r io.Reader) error {
+		data, err := io.ReadAll(io.LimitReader(r, budget+1))
+		if err != nil {
+			return err
+		}
+		budget -= int64(len(data))
+		if budget < 0 {
+			return fmt.Errorf("archive larger than %d MB", maxImportBytes>>20)
+		}
+		files[name] = data
+		return nil
+	}
+
+	if format == "zip" {
+		zr, err := zip.OpenReader(src)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		for _, zf := range zr.File {
+			if zf.FileInfo().IsDir() {
+				continue
+			}
+			rc, err := zf.Open()
+			if err != nil {
+				return nil, err
+			}
+			err = read(zf.Name, rc)
+			rc.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+		return files, nil
+	}
+
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if format == "tgz" {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := read(hdr.Name, tr); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// importPrefix starts the key an archive that cannot be tied to its SCID is
+// served under.
+const importPrefix = "import-"
+
+// isImportKey reports whether key is an import served apart from any SCID.
+func isImportKey(key string) bool {
+	return strings.HasPrefix(key, importPrefix)
+}
+
+// importArchive unpacks an exported archive into imports/ in the data
+// directory and serves it like a loaded SCID. Every file must match the hash
+// in the manifest. Whoever made the archive wrote its manifest, so it is only
+// served as the SCID it names when its DOCs check out against that SCID's
+// INDEX on chain. Otherwise it gets a key and origin of its own, named after
+// the manifest, without the SCID's permissions, storage or publisher.
+// Imports are never revalidated against a node.
+func importArchive(src string) (map[string]any, error) {
+	entries, err := readArchive(src)
+	if err != nil {
+		return nil, err
+	}
+
+	var m archiveManifest
+	raw, ok := entries[manifestName]
+	if !ok {
+		return nil, fmt.Errorf("%s has no %s", filepath.Base(src), manifestName)
+	}
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", manifestName, err)
+	}
+	if m.Format < 1 || m.Format > manifestFormat {
+		return nil, fmt.Errorf("unsupported manifest format %d", m.Format)
+	}
+	if b, err := hex.DecodeString(m.SCID); err != nil || len(b) != 32 {
+		return nil, fmt.Errorf("manifest has an invalid SCID")
+	}
+	if _, ok := m.Files[m.Entry]; !ok {
+		return nil, fmt.Errorf("manifest entry %q is not among its files", m.Entry)
+	}
+
+	listedDOCs := map[string]bool{}
+	for _, d := range m.DOCs {
+		listedDOCs[d] = true
+	}
+	for name := range entries {
+		if name == manifestName {
+			continue
+		}
+		if d, ok := strings.CutPrefix(name, archiveDOCDir); ok && listedDOCs[strings.TrimSuffix(d, ".json")] {
+			continue
+		}
+		if _, ok := m.Files[strings.TrimPrefix(name, archiveAppDir)]; !ok {
+			return nil, fmt.Errorf("%w: %s is not listed in the manifest", errIntegrity, name)
+		}
+	}
+
+	for p, meta := range m.Files {
+		if !fs.ValidPath(p) {
+			return nil, fmt.Errorf("%w: invalid path %q in manifest", errIntegrity, p)
+		}
+		data, ok := entries[archiveAppDir+p]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s is missing from the archive", errIntegrity, p)
+		}
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != meta.Hash {
+			return nil, fmt.Errorf("%w: %s does not match its manifest hash", errIntegrity, p)
+		}
+
+		// Compressed copies are not part of an export
+		meta.Encoded, meta.Brotli = false, false
+		m.Files[p] = meta
+	}
+
+	docs, docAuthors, verified, err := archiveDOCs(m, entries)
+	if err != nil {
+		return nil, err
+	}
+	key, indexAuthor, node := m.SCID, "", currentNode()
+	if err := checkArchiveOnChain(node, m, docs); err != nil {
+		sum := sha256.Sum256(raw)
+		key = importPrefix + hex.EncodeToString(sum[:16])
+		logTELA.Info("serving import apart from its SCID", "scid", m.SCID, "key", key, "reason", err)
+	} else if indexAuthor, err = indexOwner(node, m.SCID, m.Height); err != nil {
+		logTELA.Warn("INDEX owner unavailable", "scid", m.SCID, "err", err)
+	}
+	if isLoaded(key) {
+		return nil, fmt.Errorf("%s is already loaded", key)
+	}
+
+	author := describeAuthors(indexAuthor, docAuthors, verified)
+	if !verified {
+		author.Warnings = append(author.Warnings, "publisher unknown: the archive's author details could not be verified")
+	}
+	if key != m.SCID {
+		author.Warnings = append(author.Warnings, "served apart from "+m.SCID+": the archive could not be checked against its INDEX on chain")
+	}
+	if err := applyAuthorPolicy(author); err != nil {
+		return nil, err
+	}
+
+	dir, err := dataPath("imports", key)
+	if err != nil {
+		return nil, err
+	}
+	os.RemoveAll(dir)
+	for p := range m.Files {
+		dst := filepath.Join(dir, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(dst, entries[archiveAppDir+p], 0644); err != nil {
+			return nil, err
+		}
+	}
+
+	extra := map[string]any{
+		"stale":      false,
+		"imported":   true,
+		"author":     author,
+		"entry":      m.Entry,
+		"height":     m.Height,
+		"exportedAt": m.ExportedAt,
+		"libraries":  m.Libraries,
+	}
+	if m.Libraries == nil {
+		extra["libraries"] = []library{}
+	}
+	if key != m.SCID {
+		extra["claimedSCID"] = m.SCID
+	}
+	mu.Lock()
+	apps[key] = &telaApp{
+		dir:     dir,
+		entry:   m.Entry,
+		files:   m.Files,
+		details: extra,
+
+		author:     indexAuthor,
+		docAuthors: docAuthors,
+		verified:   verified,
+	}
+	mu.Unlock()
+
+	startTELA()
+	logTELA.Info("imported", "scid", key, "src", src, "dir", dir)
+
+	result := map[string]any{"scid": key, "url": appURL(key)}
+	for k, v := range extra {
+		result[k] = v
+	}
+	return result, nil
+}
+
+// checkArchiveOnChain ties an archive to the SCID its manifest names: the
+// INDEX must list the archive's DOCs at the height it was exported at, and
+// each bundled DOC must be the one on chain.
+func checkArchiveOnChain(node string, m archiveManifest, docs []tela.DOC) error {
+	switch {
+	case node == "":
+		return fmt.Errorf("node not set")
+	case m.Height <= 0:
+		return fmt.Errorf("the manifest has no height")
+	case len(docs) == 0:
+		return fmt.Errorf("the archive bundles no DOCs")
+	}
+	telaNode := strings.TrimPrefix(node, "http://")
+
+	_, listed, err := indexDOCsAtHeight(node, m.SCID, m.Height)
+	if err != nil {
+		return err
+	}
+	expanded, _, err := expandLibraries(listed, telaNode)
+	if err != nil {
+		return err
+	}
+	if !slices.Equal(expanded, m.DOCs) {
+		return fmt.Errorf("the INDEX lists other DOCs at height %d", m.Height)
+	}
+
+	for i, doc := range docs {
+		onChain, ok := cache.getDOC(m.DOCs[i])
+		if !ok {
+			if onChain, err = fetchDOC(m.DOCs[i], telaNode); err != nil {
+				return fmt.Errorf("%s: %w", m.DOCs[i], err)
+			}
+		}
+		if docHash(onChain) != docHash(doc) || onChain.Author != doc.Author || onChain.Signature != doc.Signature {
+			return fmt.Errorf("DOC %s differs from the one on chain", m.DOCs[i])
+		}
+	}
+	return nil
+}
+
+// indexOwner returns the address that installed an INDEX.
+func indexOwner(node, scid string, height int64) (string, error) {
+	vars, err := getSCVariables(node, scid, height)
+	if err != nil {
+		return "", err
+	}
+	if vars["owner"] == "" {
+		return "", fmt.Errorf("%s has no owner", scid)
+	}
+	return vars["owner"], nil
+}
+
+// archiveDOCs returns the DOCs an archive bundles and the authors they were
+// signed by. The DOC authors are only believed, and the archive reported as
+// verified, when it bundles every DOC, each carries a valid author signature
+// and together they assemble into exactly the archived files. Otherwise the
+// authors are returned empty, which counts as unknown. DOCs that are bundled
+// but do not match the files are an error.
+func archiveDOCs(m archiveManifest, entries map[string][]byte) ([]tela.DOC, []string, bool, error) {
+	docs := make([]tela.DOC, 0, len(m.DOCs))
+	for _, d := range m.DOCs {
+		raw, ok := entries[archiveDOCDir+d+".json"]
+		if !ok {
+			if len(docs) > 0 {
+				return nil, nil, false, fmt.Errorf("%w: DOC %s is missing from the archive", errIntegrity, d)
+			}
+			continue
+		}
+		var doc tela.DOC
+		if err := json.Unmarshal(raw, &doc); err != nil {
+			return nil, nil, false, fmt.Errorf("%w: DOC %s: %v", errIntegrity, d, err)
+		}
+		docs = append(docs, doc)
+	}
+	if len(docs) == 0 {
+		return nil, nil, false, nil
+	}
+	if len(docs) != len(m.DOCs) {
+		return nil, nil, false, fmt.Errorf("%w: the archive bundles only some of its DOCs", errIntegrity)
+	}
+
+	files, err := assembleFiles(docs, strings.HasSuffix(m.DURL, tela.TAG_DOC_SHARDS))
+	if err != nil {
+		return nil, nil, false, err
+	}
+	checks, failed := verifyDOCs(m.DOCs, docs, files)
+	if failed {
+		return nil, nil, false, fmt.Errorf("%w: the archive's DOCs do not check out", errIntegrity)
+	}
+	built := fileMetas(files)
+	if len(built) != len(m.Files) {
+		return nil, nil, false, fmt.Errorf("%w: the archive's DOCs do not build its files", errIntegrity)
+	}
+	for p, meta := range built {
+		if m.Files[p].Hash != meta.Hash {
+			return nil, nil, false, fmt.Errorf("%w: %s does not match its DOCs", errIntegrity, p)
+		}
+	}
+
+	for _, fc := range checks {
+		if fc.Status != verifyOK {
+			return docs, nil, false, nil
+		}
+	}
+	return docs, docAuthors(docs), true, nil
+}
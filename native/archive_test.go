@@ -0,0 +1,333 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/civilware/tela"
+)
+
+func withDataDirs(t *testing.T) {
+	t.Helper()
+	prev := dataDirs
+	root := t.TempDir()
+	dataDirs.data = filepath.Join(root, "data")
+	dataDirs.state = filepath.Join(root, "state")
+	dataDirs.cache = filepath.Join(root, "cache")
+	t.Cleanup(func() { dataDirs = prev })
+	// initDataDirs creates them at startup
+	for _, dir := range []string{dataDirs.data, dataDirs.state, dataDirs.cache} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// cacheTestApp puts docs into the cache as the app scid, as a load would.
+func cacheTestApp(t *testing.T, scid, author string, docs []tela.DOC) {
+	t.Helper()
+	files, err := assembleFiles(docs, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var docSCIDs []string
+	for _, d := range docs {
+		if err := cache.putDOC(d); err != nil {
+			t.Fatal(err)
+		}
+		docSCIDs = append(docSCIDs, d.SCID)
+	}
+	version := appVersion(docs)
+	dir := cache.appDir(scid, version)
+	for p, f := range files {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, p)), 0755)
+		if err := os.WriteFile(filepath.Join(dir, p), f.data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cache.putApp(scid, &cacheEntry{
+		Hash: version, DOCs: docSCIDs, Entry: "index.html", Files: fileMetas(files),
+		Author: author, DOCAuthors: docAuthors(docs), Verified: true,
+	})
+}
+
+// rewriteArchive copies the archive at src to dst, passing each entry
+// through edit; returning nil drops the entry.
+func rewriteArchive(t *testing.T, src, dst string, edit func(name string, data []byte) []byte) {
+	t.Helper()
+	entries, err := readArchive(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := newArchiveWriter(f, "zip")
+	for name, data := range entries {
+		if data = edit(name, data); data != nil {
+			w.add(name, data, time.Now())
+		}
+	}
+	w.Close()
+	f.Close()
+}
+
+func unloadAfter(t *testing.T, scid string) {
+	t.Cleanup(func() {
+		mu.Lock()
+		delete(apps, scid)
+		mu.Unlock()
+	})
+}
+
+func TestArchiveRoundTripTrust(t *testing.T) {
+	withDataDirs(t)
+	withTestCache(t)
+	withPins(t)
+	trusted, stranger := newTestSigner(), newTestSigner()
+	withAuthors(t, &authorList{
+		Trusted:   map[string]string{trusted.address: "friend"},
+		Blocked:   map[string]string{},
+		OnBlocked: actionRefuse,
+		OnUnknown: actionAllow,
+	})
+
+	scid := strings.Repeat("a1", 32)
+	d1, d2 := strings.Repeat("d1", 32), strings.Repeat("d2", 32)
+	cacheTestApp(t, scid, trusted.address, []tela.DOC{
+		trusted.doc(d1, "index.html", "<html>hi</html>\n"),
+		trusted.doc(d2, "app.js", "console.log(1)\n"),
+	})
+	setPin(scid, pin{Height: 100, DOCs: []string{d1, d2}})
+	node := fakeDaemon(t, map[string]map[string]string{
+		scid: {fakeCode: indexCode("app.tela", d1, d2), "owner": trusted.address},
+	})
+	dir := t.TempDir()
+	src := filepath.Join(dir, "app.zip")
+	if _, err := exportSCID(scid, src); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("checked on chain", func(t *testing.T) {
+		withNode(t, node)
+		res, err := importArchive(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		unloadAfter(t, scid)
+		if res["scid"] != scid {
+			t.Errorf("served as %v, want %s", res["scid"], scid)
+		}
+		author := res["author"].(*authorInfo)
+		if author.Trust != trustTrusted || !author.Verified || author.Address != trusted.address {
+			t.Errorf("author = %+v, want trusted and verified", author)
+		}
+	})
+
+	t.Run("no node to check against", func(t *testing.T) {
+		withNode(t, "")
+		res, err := importArchive(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key := res["scid"].(string)
+		unloadAfter(t, key)
+		if !isImportKey(key) || res["claimedSCID"] != scid {
+			t.Errorf("served as %s claiming %v, want an import key claiming %s", key, res["claimedSCID"], scid)
+		}
+		if author := res["author"].(*authorInfo); author.Trust != trustUnknown || author.Address != "" {
+			t.Errorf("author = %+v, want unknown", author)
+		}
+	})
+
+	t.Run("manifest only", func(t *testing.T) {
+		// An archive without DOCs that claims a trusted, verified author
+		withNode(t, node)
+		forged := filepath.Join(dir, "forged.zip")
+		rewriteArchive(t, src, forged, func(name string, data []byte) []byte {
+			if strings.HasPrefix(name, archiveDOCDir) {
+				return nil
+			}
+			return data
+		})
+		res, err := importArchive(forged)
+		if err != nil {
+			t.Fatal(err)
+		}
+		unloadAfter(t, res["scid"].(string))
+		if !isImportKey(res["scid"].(string)) {
+			t.Errorf("served as %v", res["scid"])
+		}
+		author := res["author"].(*authorInfo)
+		if author.Trust != trustUnknown || author.Verified {
+			t.Errorf("author = %+v, want unknown and unverified", author)
+		}
+	})
+
+	t.Run("DOCs by someone else", func(t *testing.T) {
+		// The stranger re-signs the same files and keeps the trusted
+		// author in the manifest
+		withNode(t, node)
+		forged := filepath.Join(dir, "resigned.zip")
+		rewriteArchive(t, src, forged, func(name string, data []byte) []byte {
+			if !strings.HasPrefix(name, archiveDOCDir) {
+				return data
+			}
+			var doc tela.DOC
+			json.Unmarshal(data, &doc)
+			b, _ := json.Marshal(stranger.doc(doc.SCID, doc.NameHdr, strings.TrimSuffix(strings.TrimPrefix(doc.Code, "/*\n"), "*/")))
+			return b
+		})
+		res, err := importArchive(forged)
+		if err != nil {
+			t.Fatal(err)
+		}
+		unloadAfter(t, res["scid"].(string))
+		if !isImportKey(res["scid"].(string)) {
+			t.Errorf("served as %v", res["scid"])
+		}
+		if author := res["author"].(*authorInfo); author.Trust != trustUnknown {
+			t.Errorf("trust = %s, want unknown", author.Trust)
+		}
+	})
+
+	t.Run("files differ from DOCs", func(t *testing.T) {
+		withNode(t, node)
+		forged := filepath.Join(dir, "tampered.zip")
+		evil := []byte("<html>evil</html>\n")
+		rewriteArchive(t, src, forged, func(name string, data []byte) []byte {
+			switch name {
+			case archiveAppDir + "index.html":
+				return evil
+			case manifestName:
+				var m archiveManifest
+				json.Unmarshal(data, &m)
+				meta := m.Files["index.html"]
+				meta.Hash = fileMetas(map[string]*appFile{"x": {data: evil}})["x"].Hash
+				m.Files["index.html"] = meta
+				b, _ := json.Marshal(m)
+				return b
+			}
+			return data
+		})
+		if _, err := importArchive(forged); !errors.Is(err, errIntegrity) {
+			t.Errorf("err = %v, want errIntegrity", err)
+		}
+		if isLoaded(scid) {
+			t.Error("tampered archive was served")
+		}
+	})
+}
+
+func TestImportClaimingAnotherSCID(t *testing.T) {
+	withPermissions(t)
+	withTestCache(t)
+	withPins(t)
+	withAuthors(t, &authorList{
+		Trusted:   map[string]string{},
+		Blocked:   map[string]string{},
+		OnBlocked: actionRefuse,
+		OnUnknown: actionAllow,
+	})
+
+	// The real app has been granted everything and keeps a secret
+	victim := strings.Repeat("a1", 32)
+	if _, err := setPermissions(victim, map[string]any{
+		capWalletRead: permAllow, capWalletSign: permAllow, capExternalFetch: permAllow,
+	}, false); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := dataPath("storage", victim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(secret, 0700)
+	os.WriteFile(filepath.Join(secret, "seed"), []byte("secret"), 0600)
+
+	// Someone exports their own app and names the victim in its manifest
+	attacker := newTestSigner()
+	own := strings.Repeat("e1", 32)
+	cacheTestApp(t, own, attacker.address, []tela.DOC{
+		attacker.doc(strings.Repeat("f1", 32), "index.html", "<html>mine</html>\n"),
+	})
+	setPin(own, pin{Height: 100})
+	dir := t.TempDir()
+	src, forged := filepath.Join(dir, "own.zip"), filepath.Join(dir, "forged.zip")
+	if _, err := exportSCID(own, src); err != nil {
+		t.Fatal(err)
+	}
+	rewriteArchive(t, src, forged, func(name string, data []byte) []byte {
+		if name != manifestName {
+			return data
+		}
+		var m archiveManifest
+		json.Unmarshal(data, &m)
+		m.SCID = victim
+		b, _ := json.Marshal(m)
+		return b
+	})
+	node := fakeDaemon(t, map[string]map[string]string{
+		victim: {fakeCode: indexCode("victim.tela", strings.Repeat("d1", 32))},
+	})
+
+	tests := []struct {
+		name string
+		node string
+	}{
+		{"offline", ""},
+		{"INDEX lists other DOCs", node},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withNode(t, tt.node)
+			res, err := importArchive(forged)
+			if err != nil {
+				t.Fatal(err)
+			}
+			key := res["scid"].(string)
+			unloadAfter(t, key)
+			if key == victim || !isImportKey(key) || isLoaded(victim) {
+				t.Fatalf("forged archive served as %s", key)
+			}
+
+			for _, capability := range []string{capWalletRead, capWalletSign, capExternalFetch} {
+				if p := permission(key, capability); p != permDeny {
+					t.Errorf("%s = %s, want deny", capability, p)
+				}
+			}
+			if _, err := setPermissions(key, map[string]any{capWalletSign: permAllow}, false); err == nil {
+				t.Error("granted a permission to an unchecked import")
+			}
+			if csp := contentSecurityPolicy(key); !strings.Contains(csp, "connect-src") {
+				t.Errorf("policy %q lets the import reach out", csp)
+			}
+
+			h := serveTELA()
+			get := func(scid, host string) *httptest.ResponseRecorder {
+				r := httptest.NewRequest(http.MethodGet, "/tela/"+scid+"/"+bridgePrefix+"storage/seed", nil)
+				r.Host = host
+				r.Header.Set("Sec-Fetch-Site", "same-origin")
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, r)
+				return w
+			}
+			if w := get(key, appHost(key)); w.Code != http.StatusNotFound {
+				t.Errorf("own storage read = %d %q, want not found", w.Code, w.Body.String())
+			}
+			if w := get(victim, appHost(victim)); w.Code != http.StatusNotFound {
+				t.Errorf("victim storage read = %d %q, want not found", w.Code, w.Body.String())
+			}
+			if w := get(victim, appHost(key)); w.Code == http.StatusOK {
+				t.Errorf("victim storage read from the import's origin = %q", w.Body.String())
+			}
+		})
+	}
+}
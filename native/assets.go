@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// docContentTypes maps the type a DOC declares to the Content-Type it is
+// served with. Static DOCs fall back to the file extension.
+var docContentTypes = map[string]string{
+	"TELA-HTML-1": "text/html; charset=utf-8",
+	"TELA-JS-1":   "text/javascript; charset=utf-8",
+	"TELA-CSS-1":  "text/css; charset=utf-8",
+	"TELA-JSON-1": "application/json",
+	"TELA-MD-1":   "text/markdown; charset=utf-8",
+	"TELA-GO-1":   "text/plain; charset=utf-8",
+}
+
+// contentEncodings maps TELA compression extensions to the HTTP
+// Content-Encoding of their stored form. TELA only defines gzip today.
+var contentEncodings = map[string]string{
+	".gz": "gzip",
+}
+
+// encodedForm returns the compressed bytes behind a DOC payload, or nil if
+// the payload is not in a form that can be sent to a browser as-is. TELA
+// stores compressed code base64 encoded inside the DOC comment block.
+func encodedForm(stored []byte, compression string) []byte {
+	if contentEncodings[compression] == "" {
+		return nil
+	}
+	b, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(stored)))
+	if err != nil {
+		return nil
+	}
+	// gzip magic
+	if compression == ".gz" && !bytes.HasPrefix(b, []byte{0x1f, 0x8b}) {
+		return nil
+	}
+	return b
+}
+
+// minBrotliSize is the smallest file given a brotli copy; below it the
+// saving does not pay for the extra file.
+const minBrotliSize = 1 << 10
+
+// brotliForm compresses a text file with brotli, or returns nil if it is
+// not text, too small or does not shrink. TELA stores at most gzip, so the
+// brotli copy is made once when the app is reconstructed.
+func brotliForm(name, docType string, data []byte) []byte {
+	if len(data) < minBrotliSize || !compressible(contentType(name, fileMeta{DocType: docType})) {
+		return nil
+	}
+	var buf bytes.Buffer
+	bw := brotli.NewWriterLevel(&buf, brotli.BestCompression)
+	if _, err := bw.Write(data); err != nil || bw.Close() != nil {
+		return nil
+	}
+	if buf.Len() >= len(data) {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// compressible reports whether a Content-Type is worth compressing.
+func compressible(ct string) bool {
+	ct, _, _ = strings.Cut(ct, ";")
+	return strings.HasPrefix(ct, "text/") || strings.HasSuffix(ct, "json") ||
+		strings.HasSuffix(ct, "javascript") || strings.HasSuffix(ct, "xml") || ct == "image/svg+xml"
+}
+
+// contentType returns the Content-Type for a file, preferring the DOC's
+// declared type over the extension. Empty lets the file server decide.
+func contentType(name string, meta fileMeta) string {
+	if ct, ok := docContentTypes[meta.DocType]; ok {
+		return ct
+	}
+	return mime.TypeByExtension(path.Ext(name))
+}
+
+// assetETag derives a strong ETag from the file's content hash, so it stays
+// the same across reloads, cache rebuilds and restarts for unchanged DOCs.
+// Encoded representations get their own tag.
+func assetETag(meta fileMeta, encoding string) string {
+	tag := meta.Hash
+	if len(tag) > 32 {
+		tag = tag[:32]
+	}
+	if encoding != "" {
+		tag += "-" + encoding
+	}
+	return strconv.Quote(tag)
+}
+
+// setAssetHeaders sets the headers shared by every response for a TELA file.
+// ETag must be set before the file server runs so that it can answer
+// If-None-Match and If-Range from it.
+func setAssetHeaders(w http.ResponseWriter, name string, meta fileMeta) {
+	h := w.Header()
+	if ct := contentType(name, meta); ct != "" {
+		h.Set("Content-Type", ct)
+	}
+	h.Set("ETag", assetETag(meta, ""))
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Content-Type-Options", "nosniff")
+	if meta.Brotli || contentEncodings[meta.Compression] != "" {
+		h.Add("Vary", "Accept-Encoding")
+	}
+}
+
+// serveEncoded answers with a compressed copy of a file when the client
+// accepts one: the brotli copy made at reconstruction, or the form the file
+// was stored in on chain, which skips the decompression. Range requests are
+// left to the decompressed copy so that byte offsets refer to the file
+// itself.
+func serveEncoded(w http.ResponseWriter, r *http.Request, app *telaApp, name string, meta fileMeta) bool {
+	if r.Header.Get("Range") != "" {
+		return false
+	}
+	enc, ext := negotiateEncoding(r, meta)
+	if enc == "" {
+		return false
+	}
+
+	f, err := os.Open(filepath.Join(encodedDir(app.dir), filepath.FromSlash(name)+ext))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	w.Header().Set("Content-Encoding", enc)
+	w.Header().Set("ETag", assetETag(meta, enc))
+	http.ServeContent(w, r, name, fi.ModTime(), f)
+	return true
+}
+
+// negotiateEncoding picks the encoding to answer with and the extension of
+// its copy in the .enc folder, or "" for the plain file. Brotli wins ties.
+func negotiateEncoding(r *http.Request, meta fileMeta) (string, string) {
+	var brQ, storedQ float64
+	if meta.Brotli {
+		brQ = encodingQ(r, "br")
+	}
+	stored := contentEncodings[meta.Compression]
+	if meta.Encoded && stored != "" {
+		storedQ = encodingQ(r, stored)
+	}
+	switch {
+	case brQ > 0 && brQ >= storedQ:
+		return "br", ".br"
+	case storedQ > 0:
+		return stored, meta.Compression
+	}
+	return "", ""
+}
+
+// encodingQ returns the quality the request's Accept-Encoding gives enc, 0
+// when it is not acceptable. An exact token overrides "*".
+func encodingQ(r *http.Request, enc string) float64 {
+	q, found := 0.0, false
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		token, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		token = strings.TrimSpace(token)
+		exact := strings.EqualFold(token, enc)
+		if !exact && (token != "*" || found) {
+			continue
+		}
+		v := 1.0
+		if s, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(s, 64); err == nil {
+				v = f
+			}
+		}
+		q = v
+		if exact {
+			return q
+		}
+		found = true
+	}
+	return q
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestEncodingQ(t *testing.T) {
+	tests := []struct {
+		header string
+		enc    string
+		want   float64
+	}{
+		{"", "gzip", 0},
+		{"gzip, deflate, br", "br", 1},
+		{"gzip;q=0.5", "gzip", 0.5},
+		{"gzip;q=0", "gzip", 0},
+		{"*", "br", 1},
+		{"*;q=0.3, br", "br", 1},
+		{"br;q=0, *", "br", 0},
+		{"deflate", "gzip", 0},
+		{"GZIP", "gzip", 1},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Encoding", tt.header)
+		if got := encodingQ(r, tt.enc); got != tt.want {
+			t.Errorf("encodingQ(%q, %s) = %v, want %v", tt.header, tt.enc, got, tt.want)
+		}
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	both := fileMeta{Compression: ".gz", Encoded: true, Brotli: true}
+	tests := []struct {
+		header string
+		meta   fileMeta
+		want   string
+	}{
+		{"gzip, br", both, "br"},
+		{"gzip", both, "gzip"},
+		{"gzip;q=1, br;q=0.5", both, "gzip"},
+		{"br", fileMeta{Compression: ".gz", Encoded: true}, ""},
+		{"gzip, br", fileMeta{Compression: ".gz"}, ""},
+		{"identity", both, ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Encoding", tt.header)
+		if got, _ := negotiateEncoding(r, tt.meta); got != tt.want {
+			t.Errorf("negotiateEncoding(%q, %+v) = %q, want %q", tt.header, tt.meta, got, tt.want)
+		}
+	}
+}
+
+func TestAssetETag(t *testing.T) {
+	meta := fileMeta{Hash: strings.Repeat("ab", 32)}
+	plain, br := assetETag(meta, ""), assetETag(meta, "br")
+	if plain != `"`+strings.Repeat("ab", 16)+`"` {
+		t.Errorf("plain ETag = %s", plain)
+	}
+	if br == plain || !strings.HasSuffix(br, `-br"`) {
+		t.Errorf("encoded ETag %s must differ from %s", br, plain)
+	}
+}
+
+func TestContentType(t *testing.T) {
+	if ct := contentType("x.bin", fileMeta{DocType: "TELA-JS-1"}); !strings.HasPrefix(ct, "text/javascript") {
+		t.Errorf("declared type ignored: %s", ct)
+	}
+	if ct := contentType("style.css", fileMeta{DocType: "TELA-STATIC-1"}); !strings.HasPrefix(ct, "text/css") {
+		t.Errorf("extension fallback: %s", ct)
+	}
+}
+
+func TestBrotliForm(t *testing.T) {
+	text := []byte(strings.Repeat("function hello() { return 42; }\n", 100))
+	b := brotliForm("app.js", "TELA-JS-1", text)
+	if b == nil {
+		t.Fatal("no brotli copy for a large script")
+	}
+	out, err := io.ReadAll(brotli.NewReader(bytes.NewReader(b)))
+	if err != nil || !bytes.Equal(out, text) {
+		t.Fatalf("brotli copy does not round-trip: %v", err)
+	}
+	if brotliForm("small.js", "TELA-JS-1", []byte("x")) != nil {
+		t.Error("brotli copy made for a tiny file")
+	}
+	if brotliForm("image.png", "TELA-STATIC-1", bytes.Repeat([]byte{0}, 4096)) != nil {
+		t.Error("brotli copy made for a binary file")
+	}
+}
+
+func TestServeEncoded(t *testing.T) {
+	dir := t.TempDir()
+	enc := encodedDir(dir)
+	os.MkdirAll(enc, 0755)
+	os.WriteFile(filepath.Join(enc, "app.js.br"), []byte("brotli bytes"), 0644)
+	os.WriteFile(filepath.Join(enc, "app.js.gz"), []byte("gzip bytes"), 0644)
+
+	app := &telaApp{dir: dir}
+	meta := fileMeta{Hash: strings.Repeat("cd", 32), DocType: "TELA-JS-1", Compression: ".gz", Encoded: true, Brotli: true}
+
+	serve := func(h map[string]string) (*httptest.ResponseRecorder, bool) {
+		r := httptest.NewRequest("GET", "/tela/x/app.js", nil)
+		for k, v := range h {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		setAssetHeaders(w, "app.js", meta)
+		return w, serveEncoded(w, r, app, "app.js", meta)
+	}
+
+	w, ok := serve(map[string]string{"Accept-Encoding": "gzip, br"})
+	if !ok || w.Header().Get("Content-Encoding") != "br" || w.Body.String() != "brotli bytes" {
+		t.Fatalf("br: served=%v encoding=%q body=%q", ok, w.Header().Get("Content-Encoding"), w.Body.String())
+	}
+	if w.Header().Get("Vary") != "Accept-Encoding" {
+		t.Error("missing Vary: Accept-Encoding")
+	}
+	etag := w.Header().Get("ETag")
+
+	w, _ = serve(map[string]string{"Accept-Encoding": "gzip"})
+	if w.Header().Get("Content-Encoding") != "gzip" || w.Body.String() != "gzip bytes" {
+		t.Errorf("gzip: encoding=%q body=%q", w.Header().Get("Content-Encoding"), w.Body.String())
+	}
+
+	w, _ = serve(map[string]string{"Accept-Encoding": "br", "If-None-Match": etag})
+	if w.Code != http.StatusNotModified {
+		t.Errorf("conditional request: status %d, want 304", w.Code)
+	}
+
+	if _, ok := serve(map[string]string{"Accept-Encoding": "br", "Range": "bytes=0-3"}); ok {
+		t.Error("range request answered with an encoded copy")
+	}
+}
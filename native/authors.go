@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/deroproject/derohe/rpc"
+)
+
+// errAuthorRefused marks loads refused by the trusted/blocked author policy.
+var errAuthorRefused = errors.New("refused by author policy")
+
+const (
+	trustTrusted = "trusted"
+	trustBlocked = "blocked"
+	trustUnknown = "unknown"
+)
+
+// Policy actions for blocked and unknown authors.
+const (
+	actionAllow  = "allow"
+	actionWarn   = "warn"
+	actionRefuse = "refuse"
+)
+
+// authorList is the user-managed list of trusted and blocked publishers,
+// persisted in authors.json in the data directory.
+type authorList struct {
+	Trusted   map[string]string `json:"trusted"` // address -> label
+	Blocked   map[string]string `json:"blocked"` // address -> label
+	OnBlocked string            `json:"onBlocked"`
+	OnUnknown string            `json:"onUnknown"`
+}
+
+// authorInfo describes who published a SCID, as returned with load_scid.
+type authorInfo struct {
+	Address    string   `json:"address"`
+	Trust      string   `json:"trust"`
+	Verified   bool     `json:"verified"`
+	DOCAuthors []string `json:"docAuthors"`
+	Warnings   []string `json:"warnings,omitempty"`
+}
+
+var (
+	authorsMu sync.Mutex
+	authors   *authorList
+)
+
+// loadAuthors reads authors.json on first use. Callers must hold authorsMu.
+func loadAuthors() *authorList {
+	if authors != nil {
+		return authors
+	}
+	authors = &authorList{
+		Trusted:   map[string]string{},
+		Blocked:   map[string]string{},
+		OnBlocked: actionRefuse,
+		OnUnknown: actionAllow,
+	}
+
+	path, err := dataPath("authors.json")
+	if err != nil {
+		return authors
+	}
+	if b, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(b, authors); err != nil {
+			logNative.Warn("ignoring unreadable authors file", "path", path, "err", err)
+		}
+	}
+	return authors
+}
+
+// saveAuthors writes the list back. Callers must hold authorsMu.
+func saveAuthors() error {
+	path, err := dataPath("authors.json")
+	if err != nil {
+		return err
+	}
+	b, _ := json.MarshalIndent(authors, "", "  ")
+	return os.WriteFile(path, b, 0600)
+}
+
+func getAuthors() map[string]any {
+	authorsMu.Lock()
+	defer authorsMu.Unlock()
+	a := loadAuthors()
+	return map[string]any{
+		"trusted":   a.Trusted,
+		"blocked":   a.Blocked,
+		"onBlocked": a.OnBlocked,
+		"onUnknown": a.OnUnknown,
+	}
+}
+
+// setAuthor moves address onto the trusted or blocked list, or off both
+// when trust is "none".
+func setAuthor(address, trust, label string) error {
+	if _, err := rpc.NewAddress(address); err != nil {
+		return fmt.Errorf("invalid address: %w", err)
+	}
+
+	authorsMu.Lock()
+	defer authorsMu.Unlock()
+	a := loadAuthors()
+
+	delete(a.Trusted, address)
+	delete(a.Blocked, address)
+	switch trust {
+	case trustTrusted:
+		a.Trusted[address] = label
+	case trustBlocked:
+		a.Blocked[address] = label
+	case "none":
+	default:
+		return fmt.Errorf("trust must be trusted, blocked or none")
+	}
+	return saveAuthors()
+}
+
+func setAuthorPolicy(onBlocked, onUnknown string) error {
+	authorsMu.Lock()
+	defer authorsMu.Unlock()
+	a := loadAuthors()
+
+	if onBlocked != "" {
+		if onBlocked != actionWarn && onBlocked != actionRefuse {
+			return fmt.Errorf("onBlocked must be warn or refuse")
+		}
+		a.OnBlocked = onBlocked
+	}
+	if onUnknown != "" {
+		if onUnknown != actionAllow && onUnknown != actionWarn && onUnknown != actionRefuse {
+			return fmt.Errorf("onUnknown must be allow, warn or refuse")
+		}
+		a.OnUnknown = onUnknown
+	}
+	return saveAuthors()
+}
+
+// trustOf returns the trust level of a single address.
+func trustOf(address string) string {
+	authorsMu.Lock()
+	defer authorsMu.Unlock()
+	a := loadAuthors()
+	if _, ok := a.Blocked[address]; ok {
+		return trustBlocked
+	}
+	if _, ok := a.Trusted[address]; ok {
+		return trustTrusted
+	}
+	return trustUnknown
+}
+
+// describeAuthors summarises the INDEX author and the authors of its DOCs.
+// A SCID is only trusted when every author involved is, and blocked as soon
+// as any of them is.
+func describeAuthors(indexAuthor string, docAuthors []string, verified bool) *authorInfo {
+	info := &authorInfo{
+		Address:    indexAuthor,
+		Verified:   verified,
+		DOCAuthors: []string{},
+	}
+
+	seen := map[string]bool{}
+	for _, a := range docAuthors {
+		if !seen[a] {
+			seen[a] = true
+			info.DOCAuthors = append(info.DOCAuthors, a)
+		}
+	}
+	sort.Strings(info.DOCAuthors)
+
+	info.Trust = trustOf(indexAuthor)
+	for _, a := range info.DOCAuthors {
+		if a != indexAuthor {
+			info.Warnings = append(info.Warnings, fmt.Sprintf("DOC published by %s, not the INDEX author", a))
+		}
+		switch trustOf(a) {
+		case trustBlocked:
+			info.Trust = trustBlocked
+		case trustUnknown:
+			if info.Trust == trustTrusted {
+				info.Trust = trustUnknown
+			}
+		}
+	}
+	if !verified {
+		info.Warnings = append(info.Warnings, "not every DOC carries a verifiable author signature")
+	}
+	return info
+}
+
+// applyAuthorPolicy refuses or annotates a load according to the user's
+// policy for blocked and unknown authors.
+func applyAuthorPolicy(info *authorInfo) error {
+	authorsMu.Lock()
+	a := loadAuthors()
+	onBlocked, onUnknown := a.OnBlocked, a.OnUnknown
+	authorsMu.Unlock()
+
+	action := actionAllow
+	switch info.Trust {
+	case trustBlocked:
+		action = onBlocked
+	case trustUnknown:
+		action = onUnknown
+	}
+
+	switch action {
+	case actionRefuse:
+		return fmt.Errorf("%w: author %s is %s", errAuthorRefused, shortAddr(info.Address), info.Trust)
+	case actionWarn:
+		info.Warnings = append(info.Warnings, fmt.Sprintf("author %s is %s", shortAddr(info.Address), info.Trust))
+	}
+	return nil
+}
+
+// enforceAuthorPolicy unloads the SCIDs the current list and policy refuse,
+// so blocking an author also stops the apps of theirs already being served.
+func enforceAuthorPolicy() {
+	mu.RLock()
+	loaded := make(map[string]*telaApp, len(apps))
+	for scid, app := range apps {
+		loaded[scid] = app
+	}
+	mu.RUnlock()
+
+	for scid, app := range loaded {
+		recheckAuthor(scid, app)
+	}
+}
+
+func shortAddr(addr string) string {
+	if len(addr) <= 16 {
+		return addr
+	}
+	return addr[:10] + "…" + addr[len(addr)-6:]
+}
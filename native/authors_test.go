@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// withAuthors installs an in-memory author list for one test.
+func withAuthors(t *testing.T, a *authorList) {
+	t.Helper()
+	authorsMu.Lock()
+	prev := authors
+	authors = a
+	authorsMu.Unlock()
+	t.Cleanup(func() {
+		authorsMu.Lock()
+		authors = prev
+		authorsMu.Unlock()
+	})
+}
+
+func TestDescribeAuthors(t *testing.T) {
+	withAuthors(t, &authorList{
+		Trusted:   map[string]string{"good": "", "good2": ""},
+		Blocked:   map[string]string{"bad": ""},
+		OnBlocked: actionRefuse,
+		OnUnknown: actionAllow,
+	})
+
+	tests := []struct {
+		name       string
+		index      string
+		docAuthors []string
+		want       string
+	}{
+		{"all trusted", "good", []string{"good", "good2"}, trustTrusted},
+		{"one unknown DOC author", "good", []string{"good", "someone"}, trustUnknown},
+		{"one blocked DOC author", "good", []string{"good", "bad"}, trustBlocked},
+		{"blocked INDEX author", "bad", []string{"good"}, trustBlocked},
+		{"unknown INDEX author", "someone", nil, trustUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := describeAuthors(tt.index, tt.docAuthors, true).Trust; got != tt.want {
+				t.Errorf("trust = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBlockingUnloadsLoadedApps(t *testing.T) {
+	list := &authorList{
+		Trusted:   map[string]string{},
+		Blocked:   map[string]string{},
+		OnBlocked: actionRefuse,
+		OnUnknown: actionAllow,
+	}
+	withAuthors(t, list)
+
+	mu.Lock()
+	apps["kept"] = &telaApp{author: "good", docAuthors: []string{"good"}, details: map[string]any{}}
+	apps["blocked"] = &telaApp{author: "bad", docAuthors: []string{"bad"}, details: map[string]any{}}
+	apps["dev"] = &telaApp{dev: true}
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		delete(apps, "kept")
+		delete(apps, "blocked")
+		delete(apps, "dev")
+		mu.Unlock()
+	})
+
+	authorsMu.Lock()
+	list.Blocked["bad"] = ""
+	authorsMu.Unlock()
+	enforceAuthorPolicy()
+
+	if isLoaded("blocked") {
+		t.Error("app of a blocked author is still served")
+	}
+	if !isLoaded("kept") || !isLoaded("dev") {
+		t.Error("unrelated apps were unloaded")
+	}
+
+	// Adding it again is refused too
+	mu.Lock()
+	app := &telaApp{author: "bad", docAuthors: []string{"bad"}, details: map[string]any{}}
+	apps["blocked"] = app
+	mu.Unlock()
+	if _, err := recheckAuthor("blocked", app); !errors.Is(err, errAuthorRefused) {
+		t.Errorf("recheckAuthor = %v, want errAuthorRefused", err)
+	}
+	if isLoaded("blocked") {
+		t.Error("refused app left loaded")
+	}
+}
@@ -0,0 +1,484 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Pages served from /tela/<scid>/ reach the user's wallet through an XSWD
+// relay mounted inside each SCID's path at .purewolf/xswd. HTML responses get
+// a small script that points WebSocket connections for the XSWD port at that
+// relay, so dApps written against XSWD work unchanged. The host forwards
+// requests through the connected wallet and asks the user first, through
+// the extension, naming the SCID and dURL asking.
+//
+// Every SCID is served from its own origin (see appOrigin) and only there,
+// so a relay connection belongs to the SCID whose origin opened it. The same
+// prefix also carries a fetch relay for external resources and a small
+// key/value store, both subject to the SCID's permissions.
+
+const (
+	bridgePrefix  = ".purewolf/"
+	xswdPort      = "44326"
+	promptTimeout = 2 * time.Minute
+)
+
+// JSON-RPC error codes sent back to pages.
+const (
+	bridgeErrDenied   = -32043
+	bridgeErrNoWallet = -32044
+	bridgeErrWallet   = -32045
+)
+
+// bridgeShim replaces window.WebSocket so that connections to a local XSWD
+// port go to the SCID's relay instead.
+const bridgeShim = `(function () {
+  var relay = (location.protocol === "https:" ? "wss://" : "ws://") + location.host + %q;
+  var Native = window.WebSocket;
+  function isXSWD(url) {
+    try {
+      var u = new URL(url, location.href);
+      return (u.hostname === "localhost" || u.hostname === "127.0.0.1") && u.port === %q;
+    } catch (e) {
+      return false;
+    }
+  }
+  function Bridged(url, protocols) {
+    return protocols === undefined ? new Native(isXSWD(url) ? relay : url) : new Native(isXSWD(url) ? relay : url, protocols);
+  }
+  Bridged.prototype = Native.prototype;
+  Bridged.CONNECTING = 0; Bridged.OPEN = 1; Bridged.CLOSING = 2; Bridged.CLOSED = 3;
+  window.WebSocket = Bridged;
+})();
+`
+
+var bridgeUpgrader = websocket.Upgrader{
+	// Only the SCID's own pages may open its relay
+	CheckOrigin: func(r *http.Request) bool {
+		scid, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/tela/"), "/")
+		return scid != "" && r.Header.Get("Origin") == appOrigin(scid)
+	},
+}
+
+// -------------------- PROMPTS --------------------
+
+type promptAnswer struct {
+	allow    bool
+	remember bool
+}
+
+var (
+	promptsMu    sync.Mutex
+	prompts      = map[string]chan promptAnswer{}
+	promptEvents = map[string]map[string]any{} // prompt id -> event, for a dashboard opened late
+	promptSeq    int
+)
+
+// askUser sends a wallet_prompt event to the extension and waits for the
+// user's answer, and whether to remember it for the capability. Unanswered
+// prompts are denied after promptTimeout.
+func askUser(scid, app, capability, method string, params json.RawMessage) (bool, bool) {
+	promptsMu.Lock()
+	promptSeq++
+	id := strconv.Itoa(promptSeq)
+	ch := make(chan promptAnswer, 1)
+	ev := map[string]any{
+		"event":      "wallet_prompt",
+		"prompt":     id,
+		"scid":       scid,
+		"dURL":       scidDURL(scid),
+		"app":        app,
+		"capability": capability,
+		"method":     method,
+		"params":     params,
+	}
+	prompts[id] = ch
+	promptEvents[id] = ev
+	promptsMu.Unlock()
+
+	defer func() {
+		promptsMu.Lock()
+		delete(prompts, id)
+		delete(promptEvents, id)
+		promptsMu.Unlock()
+	}()
+
+	sendMsg(ev)
+	select {
+	case a := <-ch:
+		return a.allow, a.remember
+	case <-time.After(promptTimeout):
+		logProxy.Warn("bridge prompt timed out", "scid", scid, "method", method)
+		return false, false
+	}
+}
+
+// answerPrompt delivers the user's answer to a pending prompt.
+func answerPrompt(id string, allow, remember bool) error {
+	promptsMu.Lock()
+	defer promptsMu.Unlock()
+	ch, ok := prompts[id]
+	if !ok {
+		return fmt.Errorf("no pending prompt %s", id)
+	}
+	ch <- promptAnswer{allow, remember}
+	delete(prompts, id)
+	return nil
+}
+
+// pendingPrompts lists the prompts still waiting for an answer.
+func pendingPrompts() []map[string]any {
+	promptsMu.Lock()
+	defer promptsMu.Unlock()
+	list := make([]map[string]any, 0, len(promptEvents))
+	for _, ev := range promptEvents {
+		list = append(list, ev)
+	}
+	return list
+}
+
+// scidDURL names a loaded SCID for prompts.
+func scidDURL(scid string) string {
+	if e, _, ok := cache.lastApp(scid); ok && e.DURL != "" {
+		return e.DURL
+	}
+	return scid
+}
+
+// -------------------- RELAY --------------------
+
+// bridgeCaller is the SCID a bridge request comes from, derived from the
+// origin it was sent to and, where the browser reports it, the origin it was
+// sent from. Pages may reach other origins when allowed to fetch, so the
+// Host alone does not prove a request came from that app's own pages.
+func bridgeCaller(r *http.Request) (string, error) {
+	scid := ""
+	mu.RLock()
+	for key := range apps {
+		if servesApp(r.Host, key) {
+			scid = key
+			break
+		}
+	}
+	mu.RUnlock()
+	if scid == "" {
+		return "", fmt.Errorf("%s does not serve a loaded SCID", r.Host)
+	}
+	if origin := r.Header.Get("Origin"); origin != "" && origin != appOrigin(scid) {
+		return "", fmt.Errorf("request from %s", origin)
+	}
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" && site != "same-origin" {
+		return "", fmt.Errorf("%s request", site)
+	}
+	return scid, nil
+}
+
+// serveBridge handles the reserved .purewolf/ paths of a SCID. Requests are
+// checked against the calling origin's SCID, so permissions and storage are
+// always those of the page asking.
+func serveBridge(w http.ResponseWriter, r *http.Request, scid, name string) {
+	caller, err := bridgeCaller(r)
+	if err == nil && caller != scid {
+		err = fmt.Errorf("request from %s", appOrigin(caller))
+	}
+	if err != nil {
+		logProxy.Warn("bridge request refused", "scid", scid, "err", err)
+		http.Error(w, "bridge is only available to the SCID's own pages", http.StatusForbidden)
+		return
+	}
+	scid = caller
+
+	switch name {
+	case bridgePrefix + "xswd.js":
+		w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+		fmt.Fprintf(w, bridgeShim, "/tela/"+scid+"/"+bridgePrefix+"xswd", xswdPort)
+	case bridgePrefix + "xswd":
+		relayXSWD(w, r, scid)
+	case bridgePrefix + "fetch":
+		bridgeFetch(w, r, scid)
+	default:
+		if key, ok := strings.CutPrefix(name, bridgePrefix+"storage/"); ok {
+			bridgeStorage(w, r, scid, key)
+			return
+		}
+		http.NotFound(w, r)
+	}
+}
+
+type bridgeRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// relayXSWD speaks the XSWD protocol to a page: the page registers as an
+// application, then sends JSON-RPC requests that are forwarded to the
+// wallet once the user allows them.
+func relayXSWD(w http.ResponseWriter, r *http.Request, scid string) {
+	conn, err := bridgeUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	var app struct {
+		ID          string `json:"id"`
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}
+	if err := conn.ReadJSON(&app); err != nil {
+		return
+	}
+	authorize := func(accepted bool, message string) {
+		conn.WriteJSON(map[string]any{"accepted": accepted, "message": message})
+	}
+	if _, err := currentWallet(); err != nil {
+		authorize(false, "no wallet connected to PureWolf")
+		return
+	}
+	if permission(scid, capWalletRead) == permDeny && permission(scid, capWalletSign) == permDeny {
+		authorize(false, "wallet access is denied for this site")
+		return
+	}
+	if !checkPermission(scid, app.Name, capWalletRead, "connect", nil) {
+		authorize(false, "connection denied by user")
+		return
+	}
+	authorize(true, "connected through PureWolf")
+	logProxy.Info("bridge connected", "scid", scid, "app", app.Name)
+
+	for {
+		var req bridgeRequest
+		if err := conn.ReadJSON(&req); err != nil {
+			return
+		}
+		conn.WriteJSON(forwardToWallet(scid, app.Name, req))
+	}
+}
+
+// forwardToWallet asks the user about a single request and relays it.
+func forwardToWallet(scid, app string, req bridgeRequest) map[string]any {
+	res := map[string]any{"jsonrpc": "2.0", "id": req.ID}
+	fail := func(code int, msg string) map[string]any {
+		res["error"] = map[string]any{"code": code, "message": msg}
+		return res
+	}
+
+	w, err := currentWallet()
+	if err != nil {
+		return fail(bridgeErrNoWallet, err.Error())
+	}
+	if walletForbiddenMethods[strings.TrimPrefix(req.Method, "DERO.")] {
+		return fail(bridgeErrDenied, req.Method+" is never relayed")
+	}
+	if !checkPermission(scid, app, walletCapability(req.Method), req.Method, req.Params) {
+		logProxy.Info("bridge request denied", "scid", scid, "method", req.Method)
+		return fail(bridgeErrDenied, "request denied by user")
+	}
+
+	var params any
+	if len(req.Params) > 0 {
+		params = req.Params
+	}
+	var result json.RawMessage
+	if err := w.call(req.Method, params, &result); err != nil {
+		return fail(bridgeErrWallet, err.Error())
+	}
+	logProxy.Debug("bridge request relayed", "scid", scid, "method", req.Method)
+	res["result"] = result
+	return res
+}
+
+// -------------------- INJECTION --------------------
+
+var headTag = regexp.MustCompile(`(?i)<head(\s[^>]*)?>`)
+
+// serveWithBridge serves an HTML file with the relay shim inserted at the
+// start of <head>, ahead of any script that may open an XSWD connection.
+func serveWithBridge(w http.ResponseWriter, r *http.Request, app *telaApp, scid, name string, meta fileMeta) bool {
+	path := filepath.Join(app.dir, filepath.FromSlash(name))
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+
+	tag := []byte(`<script src="/tela/` + scid + `/` + bridgePrefix + `xswd.js"></script>`)
+	if loc := headTag.FindIndex(b); loc != nil {
+		b = append(b[:loc[1]:loc[1]], append(tag, b[loc[1]:]...)...)
+	} else {
+		b = append(tag, b...)
+	}
+
+	w.Header().Set("ETag", assetETag(meta, "bridge"))
+	w.Header().Del("Vary")
+	http.ServeContent(w, r, name, fi.ModTime(), bytes.NewReader(b))
+	return true
+}
+
+// -------------------- FETCH --------------------
+
+const maxFetchBytes = 10 << 20
+
+// publicClient only connects to public addresses, so pages cannot use the
+// fetch relay to reach the daemon, the wallet or the local network.
+var publicClient = &http.Client{
+	Timeout: 30 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				host, _, _ := net.SplitHostPort(address)
+				ip := net.ParseIP(host)
+				if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+					ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+					return fmt.Errorf("%s is not a public address", host)
+				}
+				return nil
+			},
+		}).DialContext,
+	},
+}
+
+// bridgeFetch relays ?url= to an external http(s) resource for pages allowed
+// to fetch.
+func bridgeFetch(w http.ResponseWriter, r *http.Request, scid string) {
+	target := r.URL.Query().Get("url")
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		http.Error(w, "url must be an absolute http(s) URL", http.StatusBadRequest)
+		return
+	}
+	quoted, _ := json.Marshal(target)
+	if !checkPermission(scid, "", capExternalFetch, "fetch", quoted) {
+		http.Error(w, "external fetch denied for this site", http.StatusForbidden)
+		return
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, target, io.LimitReader(r.Body, maxFetchBytes))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		req.Header.Set("Content-Type", ct)
+	}
+	resp, err := publicClient.Do(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	logProxy.Info("bridge fetch", "scid", scid, "host", u.Host, "status", resp.StatusCode)
+	if ct := resp.Header.Get("Content-Type"); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, io.LimitReader(resp.Body, maxFetchBytes))
+}
+
+// -------------------- STORAGE --------------------
+
+var storageKey = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]{0,127}$`)
+
+// bridgeStorage is a per-SCID key/value store kept in storage/ in the data
+// directory, capped by the SCID's storage quota. GET on the bare prefix lists the keys.
+func bridgeStorage(w http.ResponseWriter, r *http.Request, scid, key string) {
+	quota := permissionsOf(scid).StorageQuota
+	if quota <= 0 {
+		http.Error(w, "storage disabled for this site", http.StatusForbidden)
+		return
+	}
+	dir, err := dataPath("storage", scid)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	used := map[string]int64{}
+	var total int64
+	if entries, err := os.ReadDir(dir); err == nil {
+		for _, e := range entries {
+			if fi, err := e.Info(); err == nil && !e.IsDir() {
+				used[e.Name()] = fi.Size()
+				total += fi.Size()
+			}
+		}
+	}
+
+	if key == "" && r.Method == http.MethodGet {
+		keys := make([]string, 0, len(used))
+		for k := range used {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"keys": keys, "used": total, "quota": quota})
+		return
+	}
+	if !storageKey.MatchString(key) {
+		http.Error(w, "invalid key", http.StatusBadRequest)
+		return
+	}
+	path := filepath.Join(dir, key)
+
+	switch r.Method {
+	case http.MethodGet:
+		b, err := os.ReadFile(path)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(b)
+
+	case http.MethodPut, http.MethodPost:
+		b, err := io.ReadAll(io.LimitReader(r.Body, quota+1))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if total-used[key]+int64(len(b)) > quota {
+			http.Error(w, fmt.Sprintf("storage quota of %d bytes exceeded", quota), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if err := os.WriteFile(path, b, 0600); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case http.MethodDelete:
+		os.Remove(path)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// withApps serves the given SCIDs for one test.
+func withApps(t *testing.T, scids ...string) {
+	t.Helper()
+	mu.Lock()
+	for _, scid := range scids {
+		apps[scid] = &telaApp{dir: t.TempDir(), dev: true, files: map[string]fileMeta{}, details: map[string]any{}}
+	}
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		for _, scid := range scids {
+			delete(apps, scid)
+		}
+		mu.Unlock()
+	})
+}
+
+func TestServesApp(t *testing.T) {
+	a, b := "a1", "b2"
+	tests := []struct {
+		name string
+		host string
+		scid string
+		want bool
+	}{
+		{"own origin", appHost(a), a, true},
+		{"own origin without port", appLabel(a) + ".localhost", a, true},
+		{"upper case", appLabel(a) + ".LOCALHOST:1", a, true},
+		{"other app's origin", appHost(b), a, false},
+		{"shared proxy address", "127.0.0.1:4040", a, false},
+		{"label as a suffix", "x" + appLabel(a) + ".localhost", a, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := servesApp(tt.host, tt.scid); got != tt.want {
+				t.Errorf("servesApp(%q, %q) = %v, want %v", tt.host, tt.scid, got, tt.want)
+			}
+		})
+	}
+	if appLabel(a) == appLabel(b) || len(appLabel(a)) > 63 {
+		t.Errorf("bad labels %q, %q", appLabel(a), appLabel(b))
+	}
+}
+
+func TestServeTELARequiresOwnOrigin(t *testing.T) {
+	a, b := "a1", "b2"
+	withApps(t, a, b)
+	h := serveTELA()
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		host   string
+		want   int
+	}{
+		{"page on own origin", http.MethodGet, "/tela/" + b + "/" + bridgePrefix + "xswd.js", appHost(b), http.StatusOK},
+		{"page on shared address", http.MethodGet, "/tela/" + b + "/", "127.0.0.1:4040", http.StatusFound},
+		{"bridge from other app", http.MethodGet, "/tela/" + b + "/" + bridgePrefix + "xswd.js", appHost(a), http.StatusForbidden},
+		{"storage from other app", http.MethodPost, "/tela/" + b + "/" + bridgePrefix + "storage/k", appHost(a), http.StatusForbidden},
+		{"fetch from other app", http.MethodPost, "/tela/" + b + "/" + bridgePrefix + "fetch", appHost(a), http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			r.Host = tt.host
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d", w.Code, tt.want)
+			}
+			if tt.want == http.StatusFound {
+				if loc := w.Header().Get("Location"); loc != appOrigin(b)+tt.path {
+					t.Errorf("redirected to %q", loc)
+				}
+			}
+		})
+	}
+}
+
+func TestRelayOriginCheck(t *testing.T) {
+	a, b := "a1", "b2"
+	path := "/tela/" + b + "/" + bridgePrefix + "xswd"
+	for origin, want := range map[string]bool{
+		appOrigin(b): true,
+		appOrigin(a): false,
+		telaOrigin(): false,
+		"":           false,
+	} {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		if got := bridgeUpgrader.CheckOrigin(r); got != want {
+			t.Errorf("CheckOrigin(%q) = %v, want %v", origin, got, want)
+		}
+	}
+}
+
+func TestBridgeRejectsOtherSCIDs(t *testing.T) {
+	withDataDirs(t)
+	a, b := "a1", "b2"
+	withApps(t, a, b)
+	h := serveTELA()
+
+	send := func(method, scid, key, host string, headers map[string]string, body string) int {
+		r := httptest.NewRequest(method, "/tela/"+scid+"/"+bridgePrefix+"storage/"+key, strings.NewReader(body))
+		r.Host = host
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+	own := map[string]string{"Origin": appOrigin(b), "Sec-Fetch-Site": "same-origin"}
+	if code := send(http.MethodPut, b, "k", appHost(b), own, "b's"); code != http.StatusNoContent {
+		t.Fatalf("own write = %d", code)
+	}
+
+	tests := []struct {
+		name    string
+		host    string
+		headers map[string]string
+	}{
+		{"path of another SCID", appHost(a), map[string]string{"Origin": appOrigin(a)}},
+		{"sent from another SCID's page", appHost(b), map[string]string{"Origin": appOrigin(a)}},
+		{"cross-site without Origin", appHost(b), map[string]string{"Sec-Fetch-Site": "same-site"}},
+		{"shared proxy address", "127.0.0.1:4040", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := send(http.MethodPut, b, "k", tt.host, tt.headers, "a's"); code != http.StatusForbidden {
+				t.Errorf("write = %d, want %d", code, http.StatusForbidden)
+			}
+			if code := send(http.MethodGet, b, "k", tt.host, tt.headers, ""); code != http.StatusForbidden {
+				t.Errorf("read = %d, want %d", code, http.StatusForbidden)
+			}
+		})
+	}
+
+	// Each SCID only sees its own keys
+	if code := send(http.MethodGet, a, "k", appHost(a), map[string]string{"Origin": appOrigin(a)}, ""); code != http.StatusNotFound {
+		t.Errorf("a read b's key: %d", code)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/tela/"+b+"/"+bridgePrefix+"storage/k", nil)
+	r.Host = appHost(b)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Body.String() != "b's" {
+		t.Errorf("b's value = %q", w.Body.String())
+	}
+}
+
+func TestStorageKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"settings", true},
+		{"a.b_c-d", true},
+		{"_x", true},
+		{"", false},
+		{".hidden", false},
+		{"..", false},
+		{"a/b", false},
+		{`a\\b`, false},
+		{strings.Repeat("k", 128), true},
+		{strings.Repeat("k", 129), false},
+	}
+	for _, tt := range tests {
+		if got := storageKey.MatchString(tt.key); got != tt.want {
+			t.Errorf("storageKey(%q) = %v, want %v", tt.key, got, tt.want)
+		}
+	}
+}
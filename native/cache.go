@@ -0,0 +1,488 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/civilware/tela"
+)
+
+// The cache lives in the cache directory:
+//
+//	docs/<code hash>.code     DOC code, stored once per distinct code
+//	apps/<scid>/<version>/    reconstructed app files for an INDEX
+//	apps/<scid>/<version>.enc/ compressed DOC payloads and brotli copies,
+//	                          served as-is to clients that accept them
+//	index.json                SCID -> hash mapping and LRU bookkeeping
+//
+// DOC contracts are immutable, so a DOC SCID maps to exactly one code hash.
+// Everything else about a DOC (its SCID, name, subdir, author, signature)
+// differs between DOCs sharing that code and is kept in the index entry.
+// An app version is the hash of its ordered DOC hashes, which lets a reload
+// of an unchanged INDEX reuse the folder already on disk.
+
+type cacheEntry struct {
+	Hash     string    `json:"hash"`
+	Size     int64     `json:"size"`
+	LastUsed time.Time `json:"lastUsed"`
+
+	// App entries only
+	DURL  string              `json:"dURL,omitempty"`
+	DOCs  []string            `json:"docs,omitempty"`
+	Entry string              `json:"entry,omitempty"`
+	Files map[string]fileMeta `json:"files,omitempty"`
+
+	Candidates []string `json:"candidates,omitempty"` // when the entrypoint was ambiguous
+
+	Libraries []library `json:"libraries,omitempty"`
+
+	Author     string   `json:"author,omitempty"`
+	DOCAuthors []string `json:"docAuthors,omitempty"`
+	Verified   bool     `json:"verified,omitempty"`
+
+	// DOC entries only: the DOC without its code
+	DOC *tela.DOC `json:"doc,omitempty"`
+}
+
+// fileMeta describes one reconstructed file of an app.
+type fileMeta struct {
+	Hash        string   `json:"hash"` // sha256 of the decompressed file
+	DocType     string   `json:"docType,omitempty"`
+	Compression string   `json:"compression,omitempty"`
+	Encoded     bool     `json:"encoded,omitempty"` // compressed form kept in the .enc folder
+	Brotli      bool     `json:"brotli,omitempty"`  // brotli copy kept in the .enc folder
+	DOCs        []string `json:"docs,omitempty"`
+}
+
+type contentCache struct {
+	mu    sync.Mutex
+	dir   string
+	limit int64
+
+	Docs map[string]*cacheEntry `json:"docs"` // by DOC SCID
+	Apps map[string]*cacheEntry `json:"apps"` // by INDEX SCID
+
+	hits   int64
+	misses int64
+
+	pinned map[string]int // SCIDs of loads in progress, never evicted
+}
+
+var cache *contentCache
+
+func initCache() error {
+	dir, err := cachePath()
+	if err != nil {
+		return err
+	}
+	c := &contentCache{
+		dir:   dir,
+		limit: int64(settingInt("cacheMB")) << 20,
+		Docs:  map[string]*cacheEntry{},
+		Apps:  map[string]*cacheEntry{},
+	}
+	for _, sub := range []string{"docs", "apps"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return err
+		}
+	}
+
+	if b, err := os.ReadFile(filepath.Join(dir, "index.json")); err == nil {
+		if err := json.Unmarshal(b, c); err != nil {
+			logTELA.Warn("cache index unreadable, starting empty", "err", err)
+			c.Docs = map[string]*cacheEntry{}
+			c.Apps = map[string]*cacheEntry{}
+		}
+	}
+
+	// Blobs from before DOC metadata moved into the index held whole DOCs
+	// and are fetched again
+	for scid, e := range c.Docs {
+		if e.DOC == nil {
+			delete(c.Docs, scid)
+		}
+	}
+	if old, _ := filepath.Glob(filepath.Join(dir, "docs", "*.json")); len(old) > 0 {
+		for _, f := range old {
+			os.Remove(f)
+		}
+		logTELA.Info("dropped old-format DOC blobs", "count", len(old))
+	}
+
+	cache = c
+	logTELA.Info("cache opened", "dir", dir, "docs", len(c.Docs), "apps", len(c.Apps), "bytes", c.size())
+	return nil
+}
+
+func docHash(doc tela.DOC) string {
+	sum := sha256.Sum256([]byte(doc.Code))
+	return hex.EncodeToString(sum[:])
+}
+
+// appVersion identifies an app by the ordered hashes of the DOCs it is built from.
+func appVersion(docs []tela.DOC) string {
+	h := sha256.New()
+	for _, doc := range docs {
+		h.Write([]byte(docHash(doc)))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *contentCache) blobPath(hash string) string {
+	return filepath.Join(c.dir, "docs", hash+".code")
+}
+
+func (c *contentCache) appDir(scid, version string) string {
+	return filepath.Join(c.dir, "apps", scid, version[:16])
+}
+
+// encodedDir holds the compressed payloads next to an app folder.
+func encodedDir(appDir string) string {
+	return appDir + ".enc"
+}
+
+// getDOC returns a cached DOC by SCID.
+func (c *contentCache) getDOC(scid string) (tela.DOC, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.Docs[scid]
+	if ok && e.DOC != nil {
+		if b, err := os.ReadFile(c.blobPath(e.Hash)); err == nil {
+			doc := *e.DOC
+			doc.Code = string(b)
+			e.LastUsed = time.Now()
+			c.hits++
+			return doc, true
+		}
+	}
+	if ok {
+		// Blob gone, or an entry from before DOC metadata was kept
+		delete(c.Docs, scid)
+	}
+	c.misses++
+	return tela.DOC{}, false
+}
+
+// hasDOC reports whether a DOC is cached without touching its LRU state.
+func (c *contentCache) hasDOC(scid string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.Docs[scid]
+	return ok
+}
+
+// putDOC stores the code of doc under its hash, sharing the blob with any
+// other SCID that carries identical code, and the rest of doc in its entry.
+func (c *contentCache) putDOC(doc tela.DOC) error {
+	hash := docHash(doc)
+	served := servedSCIDs()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	path := c.blobPath(hash)
+	if _, err := os.Stat(path); err != nil {
+		if err := os.WriteFile(path, []byte(doc.Code), 0644); err != nil {
+			return err
+		}
+	}
+	meta := doc
+	meta.Code = ""
+	c.Docs[doc.SCID] = &cacheEntry{Hash: hash, Size: int64(len(doc.Code)), LastUsed: time.Now(), DOC: &meta}
+	c.evict(served)
+	return nil
+}
+
+// pin keeps scids out of eviction until unpin, so that a load cannot lose
+// the DOCs and app folder it is building. Pins nest.
+func (c *contentCache) pin(scids ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pinned == nil {
+		c.pinned = map[string]int{}
+	}
+	for _, scid := range scids {
+		c.pinned[scid]++
+	}
+}
+
+func (c *contentCache) unpin(scids ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, scid := range scids {
+		if c.pinned[scid]--; c.pinned[scid] <= 0 {
+			delete(c.pinned, scid)
+		}
+	}
+}
+
+// getApp returns the cached app folder for scid if it was built from version.
+func (c *contentCache) getApp(scid, version string) (*cacheEntry, string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.Apps[scid]
+	if !ok || e.Hash != version {
+		c.misses++
+		return nil, "", false
+	}
+	dir := c.appDir(scid, version)
+	if _, err := os.Stat(dir); err != nil {
+		delete(c.Apps, scid)
+		c.misses++
+		return nil, "", false
+	}
+	e.LastUsed = time.Now()
+	c.hits++
+	return e, dir, true
+}
+
+// lastApp returns the most recently built app folder for scid, whatever
+// version it is. Used to serve previously loaded SCIDs without a node.
+func (c *contentCache) lastApp(scid string) (*cacheEntry, string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.Apps[scid]
+	if !ok {
+		return nil, "", false
+	}
+	dir := c.appDir(scid, e.Hash)
+	if _, err := os.Stat(dir); err != nil {
+		return nil, "", false
+	}
+	e.LastUsed = time.Now()
+	return e, dir, true
+}
+
+// putApp records the app folder built for scid, replacing older versions.
+func (c *contentCache) putApp(scid string, e *cacheEntry) {
+	served := servedSCIDs()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e.LastUsed = time.Now()
+	c.Apps[scid] = e
+	c.evict(served)
+}
+
+func (c *contentCache) save() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		return
+	}
+	if err := os.WriteFile(filepath.Join(c.dir, "index.json"), b, 0644); err != nil {
+		logTELA.Error("cache save failed", "err", err)
+	}
+}
+
+// size returns the bytes used, counting shared DOC blobs once.
+// Callers must hold c.mu.
+func (c *contentCache) size() int64 {
+	var total int64
+	seen := map[string]bool{}
+	for _, e := range c.Docs {
+		if !seen[e.Hash] {
+			seen[e.Hash] = true
+			total += e.Size
+		}
+	}
+	for _, e := range c.Apps {
+		total += e.Size
+	}
+	return total
+}
+
+// servedSCIDs snapshots the apps being served for evict and purge. It takes
+// mu, so it must be called before c.mu: loads hold mu while they use the
+// cache, and waiting on mu with c.mu held would deadlock against them.
+func servedSCIDs() map[string]bool {
+	served := map[string]bool{}
+	for _, scid := range loadedSCIDs() {
+		served[scid] = true
+	}
+	return served
+}
+
+// evict drops least recently used entries until the cache fits its limit.
+// Apps in served and pinned SCIDs are never evicted. Callers must hold c.mu.
+func (c *contentCache) evict(served map[string]bool) {
+	if c.limit <= 0 || c.size() <= c.limit {
+		return
+	}
+
+	type candidate struct {
+		scid  string
+		isApp bool
+		used  time.Time
+	}
+	var list []candidate
+	for scid, e := range c.Docs {
+		if c.pinned[scid] == 0 {
+			list = append(list, candidate{scid, false, e.LastUsed})
+		}
+	}
+	for scid, e := range c.Apps {
+		if c.pinned[scid] == 0 && !served[scid] {
+			list = append(list, candidate{scid, true, e.LastUsed})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].used.Before(list[j].used) })
+
+	for _, cand := range list {
+		if c.size() <= c.limit {
+			break
+		}
+		if cand.isApp {
+			c.removeApp(cand.scid)
+		} else {
+			c.removeDOC(cand.scid)
+		}
+		logTELA.Info("evicted from cache", "scid", cand.scid)
+	}
+}
+
+// setLimit changes the size limit, evicting right away if the cache no
+// longer fits. A limit of 0 disables eviction.
+func (c *contentCache) setLimit(limit int64) {
+	served := servedSCIDs()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.limit = limit
+	c.evict(served)
+	b, _ := json.Marshal(c)
+	os.WriteFile(filepath.Join(c.dir, "index.json"), b, 0644)
+}
+
+// dropDOC removes a single DOC from the cache.
+func (c *contentCache) dropDOC(scid string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.removeDOC(scid)
+}
+
+// removeDOC drops a DOC entry and its blob once no other SCID shares it.
+// Callers must hold c.mu.
+func (c *contentCache) removeDOC(scid string) int64 {
+	e, ok := c.Docs[scid]
+	if !ok {
+		return 0
+	}
+	delete(c.Docs, scid)
+	for _, other := range c.Docs {
+		if other.Hash == e.Hash {
+			return 0
+		}
+	}
+	os.Remove(c.blobPath(e.Hash))
+	return e.Size
+}
+
+// removeApp drops every cached version of an app. Callers must hold c.mu.
+func (c *contentCache) removeApp(scid string) int64 {
+	e, ok := c.Apps[scid]
+	if !ok {
+		return 0
+	}
+	delete(c.Apps, scid)
+	os.RemoveAll(filepath.Join(c.dir, "apps", scid))
+	return e.Size
+}
+
+// pruneVersions removes the folders of scid other than the version its
+// index entry records, such as the one served before a reload and builds
+// left behind by an interrupted load.
+func (c *contentCache) pruneVersions(scid string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.Apps[scid]
+	if !ok {
+		return
+	}
+	keep := filepath.Base(c.appDir(scid, e.Hash))
+	dir := filepath.Join(c.dir, "apps", scid)
+	entries, _ := os.ReadDir(dir)
+	for _, ent := range entries {
+		if name := ent.Name(); name != keep && name != filepath.Base(encodedDir(keep)) {
+			os.RemoveAll(filepath.Join(dir, name))
+		}
+	}
+}
+
+// purge removes scid from the cache, or everything but the apps currently
+// served when scid is empty. Purging an INDEX also drops the DOCs only it
+// referenced.
+func (c *contentCache) purge(scid string) int64 {
+	served := servedSCIDs()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var freed int64
+	if scid == "" {
+		for app := range c.Apps {
+			if !served[app] {
+				freed += c.removeApp(app)
+			}
+		}
+		for doc := range c.Docs {
+			freed += c.removeDOC(doc)
+		}
+	} else {
+		if app, ok := c.Apps[scid]; ok {
+			freed += c.removeApp(scid)
+			for _, doc := range app.DOCs {
+				if !c.referenced(doc) {
+					freed += c.removeDOC(doc)
+				}
+			}
+		}
+		freed += c.removeDOC(scid)
+	}
+
+	b, _ := json.Marshal(c)
+	os.WriteFile(filepath.Join(c.dir, "index.json"), b, 0644)
+	return freed
+}
+
+// referenced reports whether any cached app still uses docSCID.
+// Callers must hold c.mu.
+func (c *contentCache) referenced(docSCID string) bool {
+	for _, app := range c.Apps {
+		for _, doc := range app.DOCs {
+			if doc == docSCID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *contentCache) stats() map[string]any {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return map[string]any{
+		"dir":    c.dir,
+		"size":   c.size(),
+		"limit":  c.limit,
+		"docs":   len(c.Docs),
+		"apps":   len(c.Apps),
+		"hits":   c.hits,
+		"misses": c.misses,
+	}
+}
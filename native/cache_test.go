@@ -0,0 +1,157 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/civilware/tela"
+)
+
+func newTestCache(t *testing.T, limit int64) *contentCache {
+	t.Helper()
+	dir := t.TempDir()
+	for _, sub := range []string{"docs", "apps"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &contentCache{
+		dir:   dir,
+		limit: limit,
+		Docs:  map[string]*cacheEntry{},
+		Apps:  map[string]*cacheEntry{},
+	}
+}
+
+func TestCacheSharedBlobKeepsDOCMetadata(t *testing.T) {
+	c := newTestCache(t, 0)
+	code := "/*\nhello\n*/"
+	docs := []tela.DOC{
+		{SCID: "aa", Code: code, SubDir: "one", Author: "dero1a", Headers: tela.Headers{NameHdr: "a.html"}},
+		{SCID: "bb", Code: code, SubDir: "two", Author: "dero1b", Headers: tela.Headers{NameHdr: "b.html"}},
+	}
+	for _, d := range docs {
+		if err := c.putDOC(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	blobs, _ := filepath.Glob(filepath.Join(c.dir, "docs", "*"))
+	if len(blobs) != 1 {
+		t.Fatalf("want one shared blob, got %d", len(blobs))
+	}
+	for _, want := range docs {
+		got, ok := c.getDOC(want.SCID)
+		if !ok {
+			t.Fatalf("%s: not cached", want.SCID)
+		}
+		if got.SCID != want.SCID || got.SubDir != want.SubDir || got.Author != want.Author ||
+			got.NameHdr != want.NameHdr || got.Code != want.Code {
+			t.Errorf("%s: got %+v, want %+v", want.SCID, got, want)
+		}
+	}
+
+	// The blob stays while another SCID still uses it
+	c.dropDOC("aa")
+	if _, ok := c.getDOC("bb"); !ok {
+		t.Fatal("bb lost its blob when aa was dropped")
+	}
+	c.dropDOC("bb")
+	if blobs, _ := filepath.Glob(filepath.Join(c.dir, "docs", "*")); len(blobs) != 0 {
+		t.Fatalf("blob left behind: %v", blobs)
+	}
+}
+
+func TestCacheEvictSkipsPinned(t *testing.T) {
+	c := newTestCache(t, 10)
+	c.pin("new")
+	c.putApp("new", &cacheEntry{Hash: "v1", Size: 8})
+	c.Apps["old"] = &cacheEntry{Hash: "v1", Size: 8, LastUsed: time.Now().Add(-time.Hour)}
+	c.Apps["new"].LastUsed = time.Now().Add(-2 * time.Hour)
+
+	c.mu.Lock()
+	c.evict(nil)
+	c.mu.Unlock()
+	if _, ok := c.Apps["new"]; !ok {
+		t.Fatal("pinned app was evicted")
+	}
+	if _, ok := c.Apps["old"]; ok {
+		t.Fatal("unpinned app was kept over the limit")
+	}
+
+	c.unpin("new")
+	c.setLimit(1)
+	if _, ok := c.Apps["new"]; ok {
+		t.Fatal("app still cached after unpin and a lower limit")
+	}
+}
+
+func TestCachePurge(t *testing.T) {
+	c := newTestCache(t, 0)
+	for _, d := range []tela.DOC{{SCID: "d1", Code: "one"}, {SCID: "d2", Code: "two"}, {SCID: "d3", Code: "three"}} {
+		if err := c.putDOC(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.putApp("app1", &cacheEntry{Hash: "v1", Size: 4, DOCs: []string{"d1", "d2"}})
+	c.putApp("app2", &cacheEntry{Hash: "v2", Size: 4, DOCs: []string{"d2"}})
+
+	// d2 is still used by app2
+	if freed := c.purge("app1"); freed != 4+3 {
+		t.Errorf("purge app1 freed %d, want %d", freed, 7)
+	}
+	if c.hasDOC("d1") || !c.hasDOC("d2") || !c.hasDOC("d3") {
+		t.Errorf("unexpected DOCs after purging app1: %v", c.Docs)
+	}
+
+	c.purge("")
+	if len(c.Apps) != 0 || len(c.Docs) != 0 {
+		t.Errorf("purge all left apps %v, docs %v", c.Apps, c.Docs)
+	}
+}
+
+func TestCacheEvictSkipsServed(t *testing.T) {
+	withApps(t, "served")
+	c := newTestCache(t, 10)
+	c.putApp("served", &cacheEntry{Hash: "v1", Size: 8, LastUsed: time.Now().Add(-time.Hour)})
+	c.putApp("other", &cacheEntry{Hash: "v1", Size: 8})
+	if _, ok := c.Apps["served"]; !ok {
+		t.Fatal("served app was evicted")
+	}
+	if _, ok := c.Apps["other"]; ok {
+		t.Fatal("app was kept over the limit")
+	}
+	c.purge("")
+	if _, ok := c.Apps["served"]; !ok {
+		t.Fatal("served app was purged")
+	}
+}
+
+// Loads hold mu while they call into the cache, so the cache must not wait
+// on mu while it holds c.mu.
+func TestCacheLockOrder(t *testing.T) {
+	c := newTestCache(t, 1)
+	mu.Lock()
+	evicted := make(chan struct{})
+	go func() {
+		c.putApp("app", &cacheEntry{Hash: "v1", Size: 8})
+		close(evicted)
+	}()
+	time.Sleep(50 * time.Millisecond) // let putApp reach its wait on mu
+
+	statsDone := make(chan struct{})
+	go func() {
+		c.stats()
+		close(statsDone)
+	}()
+	select {
+	case <-statsDone:
+	case <-time.After(2 * time.Second):
+		t.Error("cache lock held while waiting on the app lock")
+	}
+	mu.Unlock()
+	<-evicted
+	<-statsDone
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// Every browser profile launches its own host, but only one may own the
+// Gnomon DB and the TELA and Gnomon ports. Native-messaging launches are
+// thin clients: they relay the browser's stdin and stdout to a background
+// daemon over a Unix socket in the state dir, starting the daemon (this
+// binary with --daemon) if none answers. The daemon holds the instance lock
+// and exits once no client has been connected for daemonIdleTimeout. A
+// daemon of another version, left running across an update, is stopped and
+// replaced when a client connects.
+
+const (
+	daemonStartTimeout = 30 * time.Second // opening the DB can take a while
+	daemonIdleTimeout  = 5 * time.Minute
+	handshakeID        = "purewolf-handshake"
+)
+
+var errInstanceLocked = errors.New("another PureWolf host is running on this data directory")
+
+func socketPath() (string, error) { return statePath("purewolf.sock") }
+
+// -------------------- THIN CLIENT --------------------
+
+// runThinClient relays stdin and stdout to the daemon, starting it first if
+// needed. Returns once the browser or the daemon closes the connection.
+func runThinClient() error {
+	if !daemonSupported {
+		return fmt.Errorf("shared daemon not supported on this platform")
+	}
+	sock, err := socketPath()
+	if err != nil {
+		return err
+	}
+
+	conn, err := connectDaemon(sock)
+	if err != nil {
+		return err
+	}
+	running, pid, err := daemonHello(conn)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("daemon handshake: %w", err)
+	}
+	if running != version {
+		logNative.Info("replacing daemon of another version", "daemon", running, "version", version, "pid", pid)
+		conn.Close()
+		if err := stopDaemon(sock, pid); err != nil {
+			return err
+		}
+		if conn, err = connectDaemon(sock); err != nil {
+			return err
+		}
+	}
+	defer conn.Close()
+
+	go func() {
+		io.Copy(conn, os.Stdin)
+		conn.(*net.UnixConn).CloseWrite()
+	}()
+	io.Copy(nativeStdout, conn)
+	return nil
+}
+
+// connectDaemon connects to the daemon, starting it if none answers.
+func connectDaemon(sock string) (net.Conn, error) {
+	conn, err := net.Dial("unix", sock)
+	if err == nil {
+		return conn, nil
+	}
+	if err := spawnDaemon(); err != nil {
+		return nil, fmt.Errorf("starting daemon: %w", err)
+	}
+	return waitForDaemon(sock)
+}
+
+// daemonHello asks the daemon for its version and pid. Events the daemon
+// sends ahead of the reply are passed on to the browser.
+func daemonHello(conn net.Conn) (string, int, error) {
+	b, _ := json.Marshal(map[string]any{"id": handshakeID, "cmd": "hello"})
+	h := make([]byte, 4)
+	binary.LittleEndian.PutUint32(h, uint32(len(b)))
+	if _, err := conn.Write(append(h, b...)); err != nil {
+		return "", 0, err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(daemonStartTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+	for {
+		raw, err := readMsg(conn)
+		if err != nil {
+			return "", 0, err
+		}
+		var reply struct {
+			ID     any `json:"id"`
+			Result struct {
+				Version string `json:"version"`
+				PID     int    `json:"pid"`
+			} `json:"result"`
+		}
+		if json.Unmarshal(raw, &reply) == nil && reply.ID == handshakeID {
+			return reply.Result.Version, reply.Result.PID, nil
+		}
+		binary.LittleEndian.PutUint32(h, uint32(len(raw)))
+		nativeStdout.Write(h)
+		nativeStdout.Write(raw)
+	}
+}
+
+// stopDaemon stops the daemon with the given pid and waits until its
+// socket no longer answers, so a new daemon can take the instance lock.
+func stopDaemon(sock string, pid int) error {
+	p, err := os.FindProcess(pid)
+	if err != nil || pid <= 0 {
+		return fmt.Errorf("daemon pid %d not found", pid)
+	}
+	if err := p.Signal(syscall.SIGTERM); err != nil {
+		return fmt.Errorf("stopping daemon: %w", err)
+	}
+	deadline := time.Now().Add(daemonStartTimeout)
+	for {
+		conn, err := net.Dial("unix", sock)
+		if err != nil {
+			return nil
+		}
+		conn.Close()
+		if time.Now().After(deadline) {
+			return fmt.Errorf("daemon %d did not stop", pid)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// spawnDaemon starts this binary as a detached daemon with the same flags,
+// so it resolves the same data directory.
+func spawnDaemon() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	args := []string{"--daemon"}
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name != "daemon" && f.Name != "standalone" {
+			args = append(args, fmt.Sprintf("--%s=%s", f.Name, f.Value))
+		}
+	})
+
+	// The environment carries PUREWOLF_DATA_DIR and the XDG dirs over
+	cmd := exec.Command(exe, args...)
+	detach(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+func waitForDaemon(sock string) (net.Conn, error) {
+	deadline := time.Now().Add(daemonStartTimeout)
+	for {
+		conn, err := net.Dial("unix", sock)
+		if err == nil {
+			return conn, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("daemon did not start: %w", err)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// -------------------- DAEMON --------------------
+
+// serveDaemon accepts thin clients on the socket and dispatches their
+// messages. Never returns; the daemon exits through shutdown.
+func serveDaemon() {
+	sock, err := socketPath()
+	if err != nil {
+		fatal("Failed to find socket path", err)
+	}
+	// Holding the instance lock means any socket left behind is stale
+	os.Remove(sock)
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		fatal("Failed to listen on socket", err)
+	}
+	os.Chmod(sock, 0600)
+	logNative.Info("daemon listening", "socket", sock, "pid", os.Getpid())
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			if err != nil {
+				logNative.Error("daemon accept failed", "err", err)
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			go func() {
+				logNative.Info("client connected", "clients", clientCount()+1)
+				serveClient(&nativeClient{w: conn}, conn)
+				conn.Close()
+				logNative.Info("client disconnected", "clients", clientCount())
+			}()
+		}
+	}()
+
+	go func() {
+		idleSince := time.Now()
+		for range time.Tick(10 * time.Second) {
+			if clientCount() > 0 {
+				idleSince = time.Now()
+				continue
+			}
+			if time.Since(idleSince) >= daemonIdleTimeout {
+				logNative.Info("no clients, shutting down", "idle", daemonIdleTimeout)
+				ln.Close()
+				os.Remove(sock)
+				shutdown()
+			}
+		}
+	}()
+
+	nativeLoop()
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFrame(t *testing.T, conn net.Conn, v any) {
+	t.Helper()
+	b, _ := json.Marshal(v)
+	h := make([]byte, 4)
+	binary.LittleEndian.PutUint32(h, uint32(len(b)))
+	if _, err := conn.Write(append(h, b...)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDaemonHello(t *testing.T) {
+	out, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := nativeStdout
+	nativeStdout = out
+	t.Cleanup(func() { nativeStdout = prev })
+
+	client, daemon := net.Pipe()
+	defer client.Close()
+	go func() {
+		raw, err := readMsg(daemon)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		var req map[string]any
+		json.Unmarshal(raw, &req)
+		if req["cmd"] != "hello" {
+			t.Errorf("handshake sent %v", req)
+		}
+		// An event broadcast before the reply belongs to the browser
+		writeFrame(t, daemon, map[string]any{"event": "sync_progress"})
+		writeFrame(t, daemon, map[string]any{"ok": true, "id": req["id"], "result": map[string]any{"version": "0.1", "pid": 42}})
+	}()
+
+	v, pid, err := daemonHello(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != "0.1" || pid != 42 {
+		t.Errorf("got version %q pid %d", v, pid)
+	}
+
+	out.Seek(0, 0)
+	raw, err := readMsg(out)
+	if err != nil {
+		t.Fatalf("event not passed on: %v", err)
+	}
+	var ev map[string]any
+	json.Unmarshal(raw, &ev)
+	if ev["event"] != "sync_progress" {
+		t.Errorf("passed on %v", ev)
+	}
+	if _, err := readMsg(out); err == nil {
+		t.Error("handshake reply passed on to the browser")
+	}
+}
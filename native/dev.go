@@ -0,0 +1,259 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/civilware/tela"
+)
+
+// Developer mode serves every folder under --scid-root at /tela/<folder>/,
+// through the same handler and headers as on-chain apps. Folders are named
+// after the SCID or dURL they will be published as. The root is watched and
+// a reload event is sent whenever a folder's files change.
+
+const devPollInterval = time.Second
+
+var (
+	devMu       sync.Mutex
+	devRoot     string
+	devWatching bool
+)
+
+// initDevMode enables developer mode when the SCID root exists, or turns it
+// off when it does not. Relative roots are resolved inside the data
+// directory. Safe to call again when the root changes; the watcher picks up
+// the new root.
+func initDevMode() {
+	root := settingString("scidRoot")
+	if root != "" && !filepath.IsAbs(root) {
+		base, err := dataPath()
+		if err != nil {
+			return
+		}
+		root = filepath.Join(base, root)
+	}
+	if root != "" {
+		if fi, err := os.Stat(root); err != nil || !fi.IsDir() {
+			logTELA.Info("SCID root not found, developer mode off", "root", root)
+			root = ""
+		}
+	}
+
+	devMu.Lock()
+	devRoot = root
+	start := root != "" && !devWatching
+	devWatching = devWatching || start
+	devMu.Unlock()
+
+	if root == "" {
+		return
+	}
+	logTELA.Info("developer mode on", "root", root)
+	startTELA()
+	if start {
+		go watchDevFolders()
+	}
+}
+
+func currentDevRoot() string {
+	devMu.Lock()
+	defer devMu.Unlock()
+	return devRoot
+}
+
+// devFingerprint summarises a folder by the name, size and modification time
+// of every file in it, which is enough to notice edits without reading them.
+func devFingerprint(dir string) string {
+	h := sha256.New()
+	filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if strings.HasPrefix(d.Name(), ".") && p != dir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if fi, err := d.Info(); err == nil {
+			fmt.Fprintf(h, "%s|%d|%d\n", p, fi.Size(), fi.ModTime().UnixNano())
+		}
+		return nil
+	})
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// devFileMetas hashes the files of a dev folder and assigns the DOC type
+// tela would give them when published, so they are served with the same
+// Content-Type and ETags.
+func devFileMetas(dir string) (map[string]fileMeta, error) {
+	metas := map[string]fileMeta{}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".") && p != dir {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		sum := sha256.Sum256(b)
+		metas[filepath.ToSlash(rel)] = fileMeta{
+			Hash:    hex.EncodeToString(sum[:]),
+			DocType: tela.ParseDocType(d.Name()),
+		}
+		return nil
+	})
+	return metas, err
+}
+
+// mountDevFolder (re)registers a dev folder for serving. Folders named like
+// a SCID that is already loaded from chain are left alone.
+func mountDevFolder(root, name string) error {
+	dir := filepath.Join(root, name)
+
+	mu.RLock()
+	existing := apps[name]
+	mu.RUnlock()
+	if existing != nil && !existing.dev {
+		return fmt.Errorf("%s is loaded from chain", name)
+	}
+
+	metas, err := devFileMetas(dir)
+	if err != nil {
+		return err
+	}
+	entry, candidates, err := findEntrypoint(dir, "")
+	if err != nil {
+		return err
+	}
+
+	details := map[string]any{
+		"stale": false,
+		"dev":   true,
+		"entry": entry,
+	}
+	if len(candidates) > 1 {
+		details["candidates"] = candidates
+	}
+
+	mu.Lock()
+	apps[name] = &telaApp{dir: dir, entry: entry, dev: true, files: metas, details: details}
+	mu.Unlock()
+	return nil
+}
+
+// watchDevFolders polls the SCID root, mounting new folders, dropping
+// removed ones and sending a reload event when a folder changes. When the
+// root changes, the folders of the old root are dropped.
+func watchDevFolders() {
+	prints := map[string]string{}
+	watched := ""
+
+	for {
+		root := currentDevRoot()
+		if root != watched {
+			unmountDevFolders()
+			prints = map[string]string{}
+			watched = root
+		}
+		if root == "" {
+			time.Sleep(devPollInterval)
+			continue
+		}
+
+		entries, err := os.ReadDir(root)
+		if err != nil {
+			logTELA.Warn("reading SCID root", "err", err)
+		}
+
+		present := map[string]bool{}
+		for _, e := range entries {
+			name := e.Name()
+			if !e.IsDir() || strings.HasPrefix(name, ".") {
+				continue
+			}
+			present[name] = true
+
+			fp := devFingerprint(filepath.Join(root, name))
+			old, known := prints[name]
+			if known && old == fp {
+				continue
+			}
+			prints[name] = fp
+
+			if err := mountDevFolder(root, name); err != nil {
+				logTELA.Warn("dev folder not mounted", "folder", name, "err", err)
+				continue
+			}
+			if !known {
+				logTELA.Info("dev folder mounted", "folder", name, "url", appURL(name))
+				continue
+			}
+			logTELA.Info("dev folder changed, reloading", "folder", name)
+			sendMsg(map[string]any{
+				"event": "reload",
+				"scid":  name,
+				"url":   appURL(name),
+			})
+		}
+
+		for name := range prints {
+			if present[name] {
+				continue
+			}
+			delete(prints, name)
+			mu.Lock()
+			if app := apps[name]; app != nil && app.dev {
+				delete(apps, name)
+			}
+			mu.Unlock()
+			logTELA.Info("dev folder unmounted", "folder", name)
+		}
+
+		time.Sleep(devPollInterval)
+	}
+}
+
+// unmountDevFolders stops serving every dev folder.
+func unmountDevFolders() {
+	mu.Lock()
+	defer mu.Unlock()
+	for name, app := range apps {
+		if app.dev {
+			delete(apps, name)
+		}
+	}
+}
+
+// devFolders lists the dev folders currently served.
+func devFolders() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	list := []string{}
+	for name, app := range apps {
+		if app.dev {
+			list = append(list, name)
+		}
+	}
+	sort.Strings(list)
+	return list
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDevFingerprint(t *testing.T) {
+	dir := writeTree(t, "index.html", "js/app.js", ".git/HEAD")
+	first := devFingerprint(dir)
+	if devFingerprint(dir) != first {
+		t.Fatal("fingerprint not stable")
+	}
+
+	// Hidden folders are not watched
+	os.WriteFile(filepath.Join(dir, ".git", "HEAD"), []byte("changed"), 0644)
+	if devFingerprint(dir) != first {
+		t.Error("change in a hidden folder noticed")
+	}
+
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Join(dir, "js", "app.js"), later, later)
+	if devFingerprint(dir) == first {
+		t.Error("edited file not noticed")
+	}
+}
+
+func TestMountDevFolder(t *testing.T) {
+	root := t.TempDir()
+	site := filepath.Join(root, "site")
+	os.MkdirAll(filepath.Join(site, "pages"), 0755)
+	os.WriteFile(filepath.Join(site, "pages", "a.html"), []byte("<p>a</p>"), 0644)
+	os.WriteFile(filepath.Join(site, "pages", "b.html"), []byte("<p>b</p>"), 0644)
+	os.MkdirAll(filepath.Join(root, "chain"), 0755)
+	os.WriteFile(filepath.Join(root, "chain", "index.html"), []byte("dev"), 0644)
+
+	mu.Lock()
+	apps["chain"] = &telaApp{dir: t.TempDir()}
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		delete(apps, "site")
+		delete(apps, "chain")
+		mu.Unlock()
+	})
+
+	if err := mountDevFolder(root, "site"); err != nil {
+		t.Fatal(err)
+	}
+	mu.RLock()
+	app := apps["site"]
+	mu.RUnlock()
+	if app == nil || !app.dev {
+		t.Fatal("dev folder not mounted")
+	}
+	if app.entry != "pages/a.html" || len(app.details["candidates"].([]string)) != 2 {
+		t.Errorf("entry %q, details %v", app.entry, app.details)
+	}
+	if _, ok := app.files["pages/b.html"]; !ok {
+		t.Errorf("files %v", app.files)
+	}
+
+	// A SCID loaded from chain is not shadowed by a folder of the same name
+	if err := mountDevFolder(root, "chain"); err == nil {
+		t.Error("dev folder replaced a chain app")
+	}
+	if folders := devFolders(); len(folders) != 1 || folders[0] != "site" {
+		t.Errorf("devFolders = %v", folders)
+	}
+
+	unmountDevFolders()
+	if isLoaded("site") || !isLoaded("chain") {
+		t.Error("unmount did not drop only the dev folders")
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// A diagnostics bundle is a zip in the state dir holding report.json and the
+// tail of the log. Everything in it is redacted: home paths, wallet
+// addresses and URL credentials never leave the machine in a bundle.
+
+const (
+	diagLogTail = 256 << 10 // bytes of the log included
+	diagErrors  = 100       // recent warnings and errors included
+)
+
+var (
+	diagAddress  = regexp.MustCompile(`\b(dero|deto)i?1[0-9a-z]{50,}`)
+	diagURLCreds = regexp.MustCompile(`(://)[^/@\s"]+:[^/@\s"]*@`)
+	diagSecrets  = regexp.MustCompile(`(?i)("(?:pass|password|token|secret)"\s*:\s*)"[^"]*"`)
+)
+
+// redact strips personal details from a diagnostics file.
+func redact(s string) string {
+	if home, err := os.UserHomeDir(); err == nil && home != "" && home != "/" {
+		s = strings.ReplaceAll(s, home, "~")
+	}
+	s = diagAddress.ReplaceAllStringFunc(s, func(a string) string {
+		return a[:6] + "…redacted"
+	})
+	s = diagURLCreds.ReplaceAllString(s, "${1}redacted@")
+	s = diagSecrets.ReplaceAllString(s, `${1}"redacted"`)
+	return s
+}
+
+// hostInfo describes the running binary.
+func hostInfo() map[string]any {
+	info := map[string]any{
+		"version": version,
+		"go":      runtime.Version(),
+		"os":      runtime.GOOS,
+		"arch":    runtime.GOARCH,
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			if s.Key == "vcs.revision" || s.Key == "vcs.modified" {
+				info[s.Key] = s.Value
+			}
+		}
+	}
+	return info
+}
+
+// dirSizes returns the size of every file directly or indirectly under dir,
+// keyed by path relative to it.
+func dirSizes(dir string) (map[string]int64, int64) {
+	sizes := map[string]int64{}
+	var total int64
+	filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if fi, err := d.Info(); err == nil {
+			rel, _ := filepath.Rel(dir, p)
+			sizes[rel] = fi.Size()
+			total += fi.Size()
+		}
+		return nil
+	})
+	return sizes, total
+}
+
+// recentProblems returns the warnings and errors still in the log history,
+// oldest first.
+func recentProblems(limit int) []logEntry {
+	logStreamMu.Lock()
+	defer logStreamMu.Unlock()
+
+	list := []logEntry{}
+	for i := range logHistory {
+		e := logHistory[(logNext+i)%len(logHistory)]
+		if e.level >= slog.LevelWarn {
+			list = append(list, e)
+		}
+	}
+	if len(list) > limit {
+		list = list[len(list)-limit:]
+	}
+	return list
+}
+
+// logTail reads the last n bytes of the log file, starting at a line.
+func logTail(n int64) ([]byte, error) {
+	path, err := statePath("purewolf-native.log")
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	start := max(fi.Size()-n, 0)
+	b := make([]byte, fi.Size()-start)
+	if _, err := f.ReadAt(b, start); err != nil && err != io.EOF {
+		return nil, err
+	}
+	if start > 0 {
+		if i := strings.IndexByte(string(b), '\n'); i >= 0 {
+			b = b[i+1:]
+		}
+	}
+	return b, nil
+}
+
+// diagnosticsReport gathers the state worth looking at when a SCID fails to
+// load or sync stalls.
+func diagnosticsReport() map[string]any {
+	report := map[string]any{
+		"createdAt": time.Now().UTC(),
+		"host":      hostInfo(),
+		"settings":  getSettings(),
+		"logLevels": currentLogLevels(),
+		"dirs": map[string]any{
+			"data":  dataDirs.data,
+			"cache": dataDirs.cache,
+			"logs":  dataDirs.state,
+		},
+	}
+
+	addr, disconnected, indexing := nodeStatus()
+	node := map[string]any{"address": addr, "disconnected": disconnected}
+	if addr != "" {
+		var info map[string]any
+		start := time.Now()
+		if err := callDaemon(addr, "DERO.GetInfo", nil, &info); err != nil {
+			node["error"] = err.Error()
+		} else {
+			node["info"] = info
+		}
+		node["latencyMs"] = time.Since(start).Milliseconds()
+	}
+	report["node"] = node
+
+	indexed, indexErr := boltDB.GetLastIndexHeight()
+	gravIndexed, _ := gravDB.GetLastIndexHeight()
+	syncState := map[string]any{
+		"indexerRunning": indexing,
+		"indexed":        indexed,
+		"gravIndexed":    gravIndexed,
+	}
+	if indexErr != nil {
+		syncState["error"] = indexErr.Error()
+	}
+	if addr != "" {
+		syncState["chain"] = getChainHeightFromDaemon(addr)
+	}
+	report["sync"] = syncState
+
+	db := map[string]any{}
+	if dir, err := dataPath("gnomondb"); err == nil {
+		files, total := dirSizes(dir)
+		db["files"], db["total"] = files, total
+	}
+	report["db"] = db
+	report["cache"] = cache.stats()
+
+	mu.RLock()
+	loaded := []map[string]any{}
+	for scid, app := range apps {
+		loaded = append(loaded, map[string]any{
+			"scid":    scid,
+			"url":     appURL(scid),
+			"entry":   app.entry,
+			"stale":   app.stale,
+			"dev":     app.dev,
+			"details": app.details,
+		})
+	}
+	mu.RUnlock()
+	report["scids"] = loaded
+	report["stale"] = staleSCIDs()
+	report["recentErrors"] = recentProblems(diagErrors)
+
+	return report
+}
+
+// writeDiagnostics writes a redacted diagnostics bundle and returns its path.
+func writeDiagnostics() (map[string]any, error) {
+	name := "purewolf-diagnostics-" + time.Now().Format("20060102-150405") + ".zip"
+	dest, err := statePath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	report, err := json.MarshalIndent(diagnosticsReport(), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	files := map[string][]byte{"report.json": []byte(redact(string(report)))}
+	if tail, err := logTail(diagLogTail); err == nil {
+		files["purewolf-native.log"] = []byte(redact(string(tail)))
+	} else {
+		files["purewolf-native.log"] = []byte(fmt.Sprintf("log unavailable: %v\n", err))
+	}
+
+	tmp := dest + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	zw := zip.NewWriter(f)
+	for _, n := range []string{"report.json", "purewolf-native.log"} {
+		w, err := zw.Create(n)
+		if err == nil {
+			_, err = w.Write(files[n])
+		}
+		if err != nil {
+			f.Close()
+			os.Remove(tmp)
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+
+	fi, err := os.Stat(dest)
+	if err != nil {
+		return nil, err
+	}
+	logNative.Info("diagnostics written", "path", dest)
+	return map[string]any{"path": dest, "size": fi.Size()}, nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/civilware/tela"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// textExts are the file types diff_scid renders as unified text diffs.
+// Everything else is compared by content and reported as a size delta.
+var textExts = map[string]bool{
+	".html": true,
+	".htm":  true,
+	".js":   true,
+	".css":  true,
+}
+
+type fileDiff struct {
+	Path     string `json:"path"`
+	SizeFrom int    `json:"sizeFrom"`
+	SizeTo   int    `json:"sizeTo"`
+	Delta    int    `json:"delta"`
+	Diff     string `json:"diff,omitempty"`
+}
+
+// indexVersion is the DOC list an INDEX referenced at one height.
+type indexVersion struct {
+	height int64
+	durl   string
+	docs   []string
+}
+
+// resolveVersion returns the INDEX version at height. A height of 0 means the
+// latest version; pinned selects the version recorded when the SCID was last
+// loaded.
+func resolveVersion(node, scid string, height int64, pinned bool) (indexVersion, error) {
+	if pinned {
+		p, ok := getPin(scid)
+		if !ok {
+			return indexVersion{}, fmt.Errorf("no pinned version for %s, pass a height", scid)
+		}
+		return indexVersion{height: p.Height, durl: p.DURL, docs: p.DOCs}, nil
+	}
+
+	durl, docs, err := indexDOCsAtHeight(node, scid, height)
+	if err != nil {
+		return indexVersion{}, err
+	}
+	if height == 0 {
+		height = getChainHeightFromDaemon(node)
+	}
+	return indexVersion{height: height, durl: durl, docs: docs}, nil
+}
+
+// diffSCID compares two versions of a TELA INDEX. from <= 0 compares against
+// the pinned version, to <= 0 against the latest one.
+func diffSCID(scid string, from, to int64) (map[string]any, error) {
+	node := currentNode()
+	if node == "" {
+		return nil, fmt.Errorf("node not set")
+	}
+
+	a, err := resolveVersion(node, scid, from, from <= 0)
+	if err != nil {
+		return nil, err
+	}
+	b, err := resolveVersion(node, scid, max(to, 0), false)
+	if err != nil {
+		return nil, err
+	}
+
+	// DOC contracts are immutable, so cached DOCs are reused and each SCID
+	// only needs fetching once even when both versions reference it.
+	telaNode := strings.TrimPrefix(node, "http://")
+	fetched := map[string]tela.DOC{}
+	assemble := func(v indexVersion) (map[string]*appFile, error) {
+		// Libraries are resolved at their current version for both sides
+		docSCIDs, _, err := expandLibraries(v.docs, telaNode)
+		if err != nil {
+			return nil, err
+		}
+		docs := make([]tela.DOC, 0, len(docSCIDs))
+		for _, docSCID := range docSCIDs {
+			doc, ok := fetched[docSCID]
+			if !ok {
+				doc, ok = cache.getDOC(docSCID)
+			}
+			if !ok {
+				var err error
+				doc, err = fetchDOC(docSCID, telaNode)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", docSCID, err)
+				}
+				fetched[docSCID] = doc
+			}
+			docs = append(docs, doc)
+		}
+		return assembleFiles(docs, strings.HasSuffix(v.durl, tela.TAG_DOC_SHARDS))
+	}
+
+	filesA, err := assemble(a)
+	if err != nil {
+		return nil, err
+	}
+	filesB, err := assemble(b)
+	if err != nil {
+		return nil, err
+	}
+
+	docsAdded, docsRemoved := diffStrings(a.docs, b.docs)
+
+	added := []fileDiff{}
+	removed := []fileDiff{}
+	changed := []fileDiff{}
+	unchanged := 0
+
+	for path, fb := range filesB {
+		fa, ok := filesA[path]
+		if !ok {
+			added = append(added, fileDiff{Path: path, SizeTo: len(fb.data), Delta: len(fb.data)})
+			continue
+		}
+		if string(fa.data) == string(fb.data) {
+			unchanged++
+			continue
+		}
+
+		d := fileDiff{
+			Path:     path,
+			SizeFrom: len(fa.data),
+			SizeTo:   len(fb.data),
+			Delta:    len(fb.data) - len(fa.data),
+		}
+		if textExts[strings.ToLower(filepath.Ext(path))] {
+			d.Diff, _ = difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+				A:        difflib.SplitLines(string(fa.data)),
+				B:        difflib.SplitLines(string(fb.data)),
+				FromFile: "a/" + path,
+				ToFile:   "b/" + path,
+				Context:  3,
+			})
+		}
+		changed = append(changed, d)
+	}
+	for path, fa := range filesA {
+		if _, ok := filesB[path]; !ok {
+			removed = append(removed, fileDiff{Path: path, SizeFrom: len(fa.data), Delta: -len(fa.data)})
+		}
+	}
+
+	for _, list := range [][]fileDiff{added, removed, changed} {
+		sort.Slice(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+	}
+
+	return map[string]any{
+		"scid": scid,
+		"from": map[string]any{"height": a.height, "dURL": a.durl, "pinned": from <= 0},
+		"to":   map[string]any{"height": b.height, "dURL": b.durl},
+		"docs": map[string]any{
+			"added":   docsAdded,
+			"removed": docsRemoved,
+		},
+		"files": map[string]any{
+			"added":     added,
+			"removed":   removed,
+			"changed":   changed,
+			"unchanged": unchanged,
+		},
+	}, nil
+}
+
+// diffStrings returns the entries only present in b and only present in a.
+func diffStrings(a, b []string) (added, removed []string) {
+	added, removed = []string{}, []string{}
+	inA := make(map[string]bool, len(a))
+	for _, s := range a {
+		inA[s] = true
+	}
+	inB := make(map[string]bool, len(b))
+	for _, s := range b {
+		inB[s] = true
+		if !inA[s] {
+			added = append(added, s)
+		}
+	}
+	for _, s := range a {
+		if !inB[s] {
+			removed = append(removed, s)
+		}
+	}
+	return added, removed
+}
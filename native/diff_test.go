@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/civilware/tela"
+)
+
+func TestDiffStrings(t *testing.T) {
+	tests := []struct {
+		name           string
+		a, b           []string
+		added, removed []string
+	}{
+		{"same", []string{"x", "y"}, []string{"y", "x"}, []string{}, []string{}},
+		{"added", []string{"x"}, []string{"x", "y"}, []string{"y"}, []string{}},
+		{"removed", []string{"x", "y"}, []string{"y"}, []string{}, []string{"x"}},
+		{"replaced keeps order", []string{"a", "b", "c"}, []string{"c", "d", "e"}, []string{"d", "e"}, []string{"a", "b"}},
+		{"from nothing", nil, []string{"x"}, []string{"x"}, []string{}},
+		{"to nothing", []string{"x"}, nil, []string{}, []string{"x"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			added, removed := diffStrings(tt.a, tt.b)
+			if !reflect.DeepEqual(added, tt.added) || !reflect.DeepEqual(removed, tt.removed) {
+				t.Errorf("got +%v -%v, want +%v -%v", added, removed, tt.added, tt.removed)
+			}
+		})
+	}
+}
+
+// indexCode is the InitializePrivate of a TELA INDEX listing docs.
+func indexCode(durl string, docs ...string) string {
+	code := "Function InitializePrivate() Uint64\n10 IF init() == 0 THEN GOTO 30\n20 RETURN 1\n"
+	code += fmt.Sprintf("33 STORE(\"dURL\", \"%s\")\n", durl)
+	for i, d := range docs {
+		code += fmt.Sprintf("%d STORE(\"DOC%d\", \"%s\")\n", 40+i, i+1, d)
+	}
+	return code + "1000 RETURN 0\nEnd Function\n\nFunction UpdateCode(mods String, code String) Uint64\n10 RETURN 1\nEnd Function\n"
+}
+
+func TestIndexDOCs(t *testing.T) {
+	installed := map[string]string{"dURL": "app.tela", "DOC1": "d1", "DOC2": "d2"}
+	tests := []struct {
+		name string
+		vars map[string]string
+		code string
+		durl string
+		docs []string
+	}{
+		{"installed", installed, indexCode("app.tela", "d1", "d2"), "app.tela", []string{"d1", "d2"}},
+		{"updated code wins", installed, indexCode("app2.tela", "d1", "d3", "d4"), "app2.tela", []string{"d1", "d3", "d4"}},
+		{"code without DOCs", installed, "Function Other() Uint64\n10 RETURN 0\nEnd Function\n", "app.tela", []string{"d1", "d2"}},
+		{"no code", installed, "", "app.tela", []string{"d1", "d2"}},
+		{"gap ends the list", nil, "Function InitializePrivate() Uint64\n40 STORE(\"DOC1\", \"d1\")\n42 STORE(\"DOC3\", \"d3\")\nEnd Function", "", []string{"d1"}},
+		{"not an INDEX", map[string]string{"docType": "TELA-JS-1"}, "", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			durl, docs := indexDOCs(tt.vars, tt.code)
+			if durl != tt.durl || !reflect.DeepEqual(docs, tt.docs) {
+				t.Errorf("got %q %v, want %q %v", durl, docs, tt.durl, tt.docs)
+			}
+		})
+	}
+}
+
+func TestDiffSCIDAfterUpdate(t *testing.T) {
+	withDataDirs(t)
+	withTestCache(t)
+
+	signer := newTestSigner()
+	d1, d2, d3 := strings.Repeat("d1", 32), strings.Repeat("d2", 32), strings.Repeat("d3", 32)
+	for _, doc := range []tela.DOC{
+		signer.doc(d1, "index.html", "<html>hi</html>\n"),
+		signer.doc(d2, "app.js", "console.log(1)\n"),
+		signer.doc(d3, "app.js", "console.log(2)\n"),
+	} {
+		if err := cache.putDOC(doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// UpdateCode at height 200 swaps d2 for d3 in the code only; the
+	// variables keep listing what was installed
+	scid := strings.Repeat("a1", 32)
+	installed := map[string]string{"dURL": "app.tela", "DOC1": d1, "DOC2": d2}
+	updated := map[string]string{fakeCode: indexCode("app.tela", d1, d3)}
+	for k, v := range installed {
+		updated[k] = v
+	}
+	installed[fakeCode] = indexCode("app.tela", d1, d2)
+	withNode(t, fakeDaemon(t, map[string]map[string]string{scid: installed, scid + "@200": updated}))
+
+	tests := []struct {
+		name           string
+		from, to       int64
+		added, removed []string
+		changed        []string
+	}{
+		{"across the update", 100, 300, []string{d3}, []string{d2}, []string{"app.js"}},
+		{"before the update", 50, 150, []string{}, []string{}, nil},
+		{"after the update", 250, 300, []string{}, []string{}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := diffSCID(scid, tt.from, tt.to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			docs := res["docs"].(map[string]any)
+			if !reflect.DeepEqual(docs["added"], tt.added) || !reflect.DeepEqual(docs["removed"], tt.removed) {
+				t.Errorf("docs = +%v -%v, want +%v -%v", docs["added"], docs["removed"], tt.added, tt.removed)
+			}
+			var changed []string
+			for _, d := range res["files"].(map[string]any)["changed"].([]fileDiff) {
+				changed = append(changed, d.Path)
+				if d.Diff == "" {
+					t.Errorf("%s has no text diff", d.Path)
+				}
+			}
+			if !reflect.DeepEqual(changed, tt.changed) {
+				t.Errorf("changed = %v, want %v", changed, tt.changed)
+			}
+		})
+	}
+}
@@ -3,11 +3,10 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
-	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/civilware/Gnomon/api"
@@ -23,14 +22,11 @@ var (
 	myIndexer *indexer.Indexer
 )
 
-var indexerRunning bool
-
 func initDB() error {
-	home, err := os.UserHomeDir()
+	db, err := dataPath("gnomondb")
 	if err != nil {
-		return fmt.Errorf("could not find home dir: %w", err)
+		return err
 	}
-	db := filepath.Join(home, ".purewolf", "gnomondb")
 	os.MkdirAll(db, 0755)
 
 	boltDB, err = storage.NewBBoltDB(db, "GNOMON.db")
@@ -43,7 +39,7 @@ func initDB() error {
 		return fmt.Errorf("gravdb: %w", err)
 	}
 
-	log.Printf("DB handles reinitialized")
+	logGnomon.Info("DB handles reinitialized")
 	return nil
 }
 
@@ -52,30 +48,57 @@ func initStorage() error {
 		return err
 	}
 
+	// The API server binds its address itself and cannot be handed a
+	// listener, so find a free port first and release it just before the
+	// server takes it; waitForGnomonAPI checks it really did
+	port := settingInt("gnomonPort")
+	ln, err := listenLocal("gnomon", port)
+	recordBound(&gnomonBound, port, ln, "/api", err)
+	if err != nil {
+		logGnomon.Error("Gnomon API not started", "err", err)
+		return nil
+	}
+	addr := ln.Addr().String()
+
 	apiCfg := &structures.APIConfig{
 		Enabled: true,
-		Listen:  fmt.Sprintf("127.0.0.1:%d", *gnomonPort),
+		Listen:  addr,
 	}
 	apiServer = api.NewApiServer(apiCfg, gravDB, boltDB, "boltdb")
+	ln.Close()
 	go apiServer.Start()
+	go waitForGnomonAPI()
 
-	log.Printf("Storage + Gnomon API ready on :%d", *gnomonPort)
+	logGnomon.Info("storage and Gnomon API ready", "addr", addr)
 	return nil
 }
 
-var syncCancel chan struct{}
+// syncMu guards the running sync: its cancel channel and the indexer.
+var (
+	syncMu     sync.Mutex
+	syncCancel chan struct{}
+)
 
 func startSync(node string) {
 	if !strings.HasPrefix(node, "http://") {
 		node = "http://" + node
 	}
 
-	// Cancel any previous sync goroutines
+	// Cancel any previous sync goroutines. Syncs start in the background, so
+	// one for a node that has been replaced since does not start at all.
+	syncMu.Lock()
+	if currentNode() != node {
+		syncMu.Unlock()
+		return
+	}
 	if syncCancel != nil {
 		close(syncCancel)
 	}
 	syncCancel = make(chan struct{})
 	cancel := syncCancel
+	syncMu.Unlock()
+
+	setSyncState("waiting")
 
 	// Get target height from daemon before starting
 	targetHeight := int64(0)
@@ -89,7 +112,7 @@ func startSync(node string) {
 		targetHeight = getChainHeightFromDaemon(node)
 		if targetHeight == 0 {
 			retries++
-			log.Printf("Sync: waiting for daemon at %s (attempt %d)...", node, retries)
+			logGnomon.Warn("sync waiting for daemon", "node", node, "attempt", retries)
 
 			// After 2 attempts (~6s) notify the UI the node is unreachable
 			if retries == 2 {
@@ -106,23 +129,26 @@ func startSync(node string) {
 			}
 		}
 	}
-	log.Printf("Sync: target locked at height %d", targetHeight)
-	nodeDisconnected = false
+	logGnomon.Info("sync target locked", "height", targetHeight)
+	nodeAnswered(node)
+	go revalidateStale()
 
 	lastHeight, err := boltDB.GetLastIndexHeight()
-	log.Printf("Resuming from lastHeight=%d err=%v", lastHeight, err)
+	logGnomon.Info("resuming index", "lastHeight", lastHeight, "err", err)
 
 	sf := []string{"telaVersion"}
 
 	// Fastsync indexer
-	myIndexer = indexer.NewIndexer(
+	fastIndexer := indexer.NewIndexer(
 		gravDB, boltDB, "boltdb",
 		sf, lastHeight, node, "daemon",
 		false, false, &structures.FastSyncConfig{Enabled: true}, []string{},
 	)
-	go myIndexer.StartDaemonMode(5)
-	indexerRunning = true
-	log.Printf("Indexer started with fastsync, resuming from height %d", lastHeight)
+	if !startIndexer(fastIndexer, cancel) {
+		return
+	}
+	setSyncState("fastsync")
+	logGnomon.Info("indexer started with fastsync", "from", lastHeight)
 
 	go func() {
 		// Refresh chain height every 5s independently so progress stays accurate
@@ -154,16 +180,14 @@ func startSync(node string) {
 			// During fastsync, BoltDB is only written at completion — Gnomon
 			// buffers in memory. Check GravitonDB which is updated more frequently.
 			indexed, err := boltDB.GetLastIndexHeight()
-			log.Printf("DEBUG boltDB indexed=%d err=%v", indexed, err)
 
 			if err != nil || indexed == 0 {
 				gravIndexed, gravErr := gravDB.GetLastIndexHeight()
-				log.Printf("DEBUG gravDB indexed=%d err=%v", gravIndexed, gravErr)
 				if gravErr == nil && gravIndexed > indexed {
 					indexed = gravIndexed
 				}
 			}
-			log.Printf("DEBUG final indexed=%d target=%d", indexed, targetHeight)
+			logGnomon.Debug("fastsync progress", "indexed", indexed, "target", targetHeight)
 			sendMsg(map[string]any{
 				"event":   "sync_progress",
 				"indexed": indexed,
@@ -171,18 +195,21 @@ func startSync(node string) {
 			})
 
 			if indexed >= targetHeight-3 {
-				log.Printf("Fastsync complete at height %d, switching to normal sync", indexed)
+				logGnomon.Info("fastsync complete, switching to normal sync", "height", indexed)
 
 				finalHeight := indexed
 				finalNode := node
 
-				myIndexer = indexer.NewIndexer(
+				liveIndexer := indexer.NewIndexer(
 					gravDB, boltDB, "boltdb",
 					[]string{"telaVersion"}, finalHeight, finalNode, "daemon",
 					false, false, nil, []string{},
 				)
-				go myIndexer.StartDaemonMode(5)
-				log.Printf("Normal sync started from height %d", finalHeight)
+				if !startIndexer(liveIndexer, cancel) {
+					return
+				}
+				setSyncState("live")
+				logGnomon.Info("normal sync started", "from", finalHeight)
 
 				sendMsg(map[string]any{
 					"event":  "sync_complete",
@@ -199,7 +226,7 @@ func startSync(node string) {
 						}
 
 						// Stop polling if node was disconnected
-						if currentNode == "" {
+						if currentNode() == "" {
 							return
 						}
 
@@ -208,7 +235,7 @@ func startSync(node string) {
 						if dbHeight == 0 {
 							dbHeight = finalHeight
 						}
-						log.Printf("Live poll: dbHeight=%d chainHeight=%d", dbHeight, chainHeight)
+						logGnomon.Debug("live poll", "indexed", dbHeight, "chain", chainHeight)
 						sendMsg(map[string]any{
 							"event":   "sync_progress",
 							"indexed": dbHeight,
@@ -223,13 +250,16 @@ func startSync(node string) {
 }
 
 func stopSync() {
+	syncMu.Lock()
 	if syncCancel != nil {
 		close(syncCancel)
 		syncCancel = nil
 	}
+	syncMu.Unlock()
 	stopIndexer()
+	setSyncState("stopped")
 	if err := initDB(); err != nil {
-		log.Printf("stopSync: reinit DB failed: %v", err)
+		logGnomon.Error("reinit DB after stopping sync failed", "err", err)
 		return
 	}
 	updateAPIServerDB()
@@ -241,15 +271,17 @@ func updateAPIServerDB() {
 	}
 	apiServer.GravDBBackend = gravDB
 	apiServer.BBSBackend = boltDB
-	log.Printf("API server DB handles updated")
+	logGnomon.Info("API server DB handles updated")
 }
 
 func getChainHeightFromDaemon(node string) int64 {
 	client := &http.Client{Timeout: 5 * time.Second}
 	body := strings.NewReader(`{"jsonrpc":"2.0","id":"1","method":"DERO.GetInfo"}`)
+	start := time.Now()
 	resp, err := client.Post(node+"/json_rpc", "application/json", body)
+	timeDaemonCall("DERO.GetInfo", start, err)
 	if err != nil {
-		log.Printf("getChainHeightFromDaemon error: %v", err)
+		logGnomon.Warn("chain height unavailable", "node", node, "err", err)
 		return 0
 	}
 	defer resp.Body.Close()
@@ -260,17 +292,36 @@ func getChainHeightFromDaemon(node string) int64 {
 	json.NewDecoder(resp.Body).Decode(&result)
 
 	if h, ok := result.Result["topoheight"].(float64); ok {
+		lastChainHeight.Store(int64(h))
 		return int64(h)
 	}
 	return 0
 }
 
+// startIndexer makes idx the running indexer, unless the sync it belongs to
+// was cancelled meanwhile.
+func startIndexer(idx *indexer.Indexer, cancel chan struct{}) bool {
+	syncMu.Lock()
+	defer syncMu.Unlock()
+	select {
+	case <-cancel:
+		return false
+	default:
+	}
+	myIndexer = idx
+	go idx.StartDaemonMode(5)
+	setIndexerRunning(true)
+	return true
+}
+
 func stopIndexer() {
+	syncMu.Lock()
 	if myIndexer != nil {
 		myIndexer.Close()
 		myIndexer = nil
 	}
-	indexerRunning = false
+	syncMu.Unlock()
+	setIndexerRunning(false)
 }
 
 func closeStorage() {
@@ -0,0 +1,13 @@
+//go:build !unix && !windows
+
+package main
+
+import "os/exec"
+
+// Without flock and sessions each launch runs its own in-process host, as
+// before the shared daemon.
+const daemonSupported = false
+
+func lockInstance() error { return nil }
+
+func detach(cmd *exec.Cmd) {}
@@ -0,0 +1,41 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+const daemonSupported = true
+
+// instanceLock stays open for the life of the process; the kernel drops the
+// lock when it exits, however it exits.
+var instanceLock *os.File
+
+// lockInstance takes the instance lock of the data directory.
+func lockInstance() error {
+	path, err := statePath("purewolf.lock")
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		return errInstanceLocked
+	}
+	f.Truncate(0)
+	fmt.Fprintf(f, "%d\n", os.Getpid())
+	instanceLock = f
+	return nil
+}
+
+// detach runs cmd in its own session so it outlives the browser's host.
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
@@ -0,0 +1,29 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestLockInstance(t *testing.T) {
+	withDataDirs(t)
+	prev := instanceLock
+	t.Cleanup(func() { instanceLock = prev })
+
+	if err := lockInstance(); err != nil {
+		t.Fatal(err)
+	}
+	held := instanceLock
+	if err := lockInstance(); !errors.Is(err, errInstanceLocked) {
+		t.Fatalf("second lock = %v, want errInstanceLocked", err)
+	}
+
+	// The lock goes with the file, however the holder exits
+	held.Close()
+	if err := lockInstance(); err != nil {
+		t.Fatalf("lock not released: %v", err)
+	}
+	instanceLock.Close()
+}
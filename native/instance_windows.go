@@ -0,0 +1,44 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	"golang.org/x/sys/windows"
+)
+
+// Windows has no shared daemon yet, so each launch runs an in-process host.
+// The lock still keeps a second host from opening the same Gnomon DB.
+const daemonSupported = false
+
+// instanceLock stays open for the life of the process; Windows drops the
+// lock when the handle is closed, however the process exits.
+var instanceLock *os.File
+
+// lockInstance takes the instance lock of the data directory.
+func lockInstance() error {
+	path, err := statePath("purewolf.lock")
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	// Lock a byte past the pid so the lock does not stop it being read
+	ol := &windows.Overlapped{Offset: 1 << 20}
+	flags := uint32(windows.LOCKFILE_EXCLUSIVE_LOCK | windows.LOCKFILE_FAIL_IMMEDIATELY)
+	if err := windows.LockFileEx(windows.Handle(f.Fd()), flags, 0, 1, 0, ol); err != nil {
+		f.Close()
+		return errInstanceLocked
+	}
+	f.Truncate(0)
+	fmt.Fprintf(f, "%d\n", os.Getpid())
+	instanceLock = f
+	return nil
+}
+
+func detach(cmd *exec.Cmd) {}
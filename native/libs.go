@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/civilware/tela"
+)
+
+// library is a TELA-LIB an app was built with. Libraries are either an
+// INDEX listed among the app's DOCs, whose own DOCs are mounted into the
+// app, or a single DOC published with a .lib dURL.
+type library struct {
+	SCID    string   `json:"scid"`
+	DURL    string   `json:"dURL"`
+	Version string   `json:"version"`
+	DOCs    []string `json:"docs"`
+}
+
+// maxLibraryDepth bounds libraries that pull in other libraries.
+const maxLibraryDepth = 4
+
+// expandLibraries replaces library INDEX references in an INDEX DOC list with
+// the DOCs of that library and returns the flattened list along with the
+// libraries it resolved. Library files keep the subDir their DOCs declare,
+// which is the path apps built against the library import them from.
+//
+// Each level of the list is looked up in parallel with retries, like DOCs
+// are fetched. DOCs already in the cache are known not to be an INDEX and are
+// not looked up again. A library reached through several others is expanded
+// once and its DOCs listed once; only a library that includes itself is an
+// error.
+func expandLibraries(docSCIDs []string, telaNode string) ([]string, []library, error) {
+	libs := []library{}
+	indexOf := map[string]indexVersion{}
+	resolved := map[string][]string{} // library -> the DOCs it expands to
+	expanding := map[string]bool{}    // libraries on the current path
+
+	var expand func(list []string, depth int) ([]string, error)
+	expand = func(list []string, depth int) ([]string, error) {
+		if err := lookupINDEXes(list, indexOf, telaNode); err != nil {
+			return nil, err
+		}
+
+		var out []string
+		for _, scid := range list {
+			libDOCs := indexOf[scid].docs
+			if len(libDOCs) == 0 {
+				out = append(out, scid)
+				continue
+			}
+			if nested, ok := resolved[scid]; ok {
+				out = append(out, nested...)
+				continue
+			}
+			if expanding[scid] {
+				return nil, fmt.Errorf("library %s includes itself", scid)
+			}
+			if depth >= maxLibraryDepth {
+				return nil, fmt.Errorf("library %s nested more than %d deep", scid, maxLibraryDepth)
+			}
+
+			expanding[scid] = true
+			nested, err := expand(libDOCs, depth+1)
+			delete(expanding, scid)
+			if err != nil {
+				return nil, err
+			}
+			resolved[scid] = nested
+			libs = append(libs, library{
+				SCID:    scid,
+				DURL:    indexOf[scid].durl,
+				Version: listVersion(nested),
+				DOCs:    nested,
+			})
+			out = append(out, nested...)
+		}
+		return out, nil
+	}
+
+	docs, err := expand(docSCIDs, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return dedupeDOCs(docSCIDs, docs), libs, nil
+}
+
+// lookupINDEXes fetches the current DOC list of every SCID in list that is
+// not cached as a DOC or looked up already, in parallel and with retries.
+// SCIDs that are not an INDEX get an empty list.
+func lookupINDEXes(list []string, indexOf map[string]indexVersion, telaNode string) error {
+	var todo []string
+	for _, scid := range list {
+		if _, done := indexOf[scid]; !done && !cache.hasDOC(scid) {
+			indexOf[scid] = indexVersion{}
+			todo = append(todo, scid)
+		}
+	}
+
+	found := make([]indexVersion, len(todo))
+	errs := make([]error, len(todo))
+	forEachParallel(len(todo), func(i int) {
+		errs[i] = withRetries("library lookup", todo[i], func() error {
+			vars, code, err := getSC(telaNode, todo[i], 0, true)
+			found[i].durl, found[i].docs = indexDOCs(vars, code)
+			return err
+		})
+	})
+	for i, scid := range todo {
+		if errs[i] != nil {
+			return fmt.Errorf("%s: %w", scid, errs[i])
+		}
+		indexOf[scid] = found[i]
+	}
+	return nil
+}
+
+// dedupeDOCs drops repeats of DOCs that several libraries share. A DOC the
+// INDEX itself lists twice is kept twice so that verification reports it.
+func dedupeDOCs(indexDOCs, docs []string) []string {
+	direct := map[string]int{}
+	for _, scid := range indexDOCs {
+		direct[scid]++
+	}
+	seen := map[string]bool{}
+	out := make([]string, 0, len(docs))
+	for _, scid := range docs {
+		if seen[scid] && direct[scid] < 2 {
+			continue
+		}
+		seen[scid] = true
+		out = append(out, scid)
+	}
+	return out
+}
+
+// docLibraries lists the DOCs that were published as single-file libraries.
+func docLibraries(docs []tela.DOC) []library {
+	var libs []library
+	for _, doc := range docs {
+		if strings.HasSuffix(doc.DURL, tela.TAG_LIBRARY) {
+			libs = append(libs, library{
+				SCID:    doc.SCID,
+				DURL:    doc.DURL,
+				Version: docHash(doc)[:16],
+				DOCs:    []string{doc.SCID},
+			})
+		}
+	}
+	return libs
+}
+
+// listVersion identifies a library INDEX version by the DOCs it resolves to.
+// DOC contracts are immutable, so the same list always serves the same files.
+func listVersion(docSCIDs []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(docSCIDs, ",")))
+	return hex.EncodeToString(sum[:])[:16]
+}
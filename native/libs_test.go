@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/deroproject/derohe/rpc"
+)
+
+// fakeDaemon answers DERO.GetSC with the string variables of contracts. A
+// contract's fakeCode entry is returned as its code instead, and an entry
+// keyed "<scid>@<height>" is the contract from that topoheight on, as after
+// an UpdateCode. Each SCID in flaky fails its first lookup. Every
+// transaction it is asked about is reported mined at fakeTxHeight.
+func fakeDaemon(t *testing.T, contracts map[string]map[string]string, flaky ...string) string {
+	t.Helper()
+	var mu sync.Mutex
+	failed := map[string]bool{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Method string `json:"method"`
+			Params struct {
+				SCID       string `json:"scid"`
+				TopoHeight int64  `json:"topoheight"`
+			} `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Method == "DERO.GetTransaction" {
+			tx := rpc.Tx_Related_Info{ValidBlock: "fake", Block_Height: fakeTxHeight}
+			json.NewEncoder(w).Encode(map[string]any{"result": rpc.GetTransaction_Result{Txs: []rpc.Tx_Related_Info{tx}}})
+			return
+		}
+		scid := req.Params.SCID
+
+		mu.Lock()
+		fail := false
+		for _, f := range flaky {
+			if f == scid && !failed[scid] {
+				failed[scid], fail = true, true
+			}
+		}
+		mu.Unlock()
+		if fail {
+			json.NewEncoder(w).Encode(map[string]any{"error": map[string]any{"code": -1, "message": "busy"}})
+			return
+		}
+
+		contract, since := contracts[scid], int64(-1)
+		for key, c := range contracts {
+			id, at, ok := strings.Cut(key, "@")
+			h, _ := strconv.ParseInt(at, 10, 64)
+			if ok && id == scid && h > since && (req.Params.TopoHeight == 0 || h <= req.Params.TopoHeight) {
+				contract, since = c, h
+			}
+		}
+		keys := map[string]any{}
+		for k, v := range contract {
+			if k != fakeCode {
+				keys[k] = hex.EncodeToString([]byte(v))
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"result": map[string]any{"stringkeys": keys, "code": contract[fakeCode]}})
+	}))
+	t.Cleanup(srv.Close)
+	return strings.TrimPrefix(srv.URL, "http://")
+}
+
+const (
+	// fakeCode is the fakeDaemon contract entry holding its code.
+	fakeCode = "#code"
+	// fakeTxHeight is the height fakeDaemon mines transactions at.
+	fakeTxHeight = 42
+)
+
+// withNode points the host at node, a fakeDaemon address or "" for none,
+// for one test.
+func withNode(t *testing.T, node string) {
+	t.Helper()
+	prev := currentNode()
+	if node != "" {
+		node = "http://" + node
+	}
+	setNode(node)
+	t.Cleanup(func() { setNode(prev) })
+}
+
+func withTestCache(t *testing.T) {
+	t.Helper()
+	prev := cache
+	cache = newTestCache(t, 0)
+	t.Cleanup(func() { cache = prev })
+}
+
+func TestExpandLibraries(t *testing.T) {
+	withTestCache(t)
+	node := fakeDaemon(t, map[string]map[string]string{
+		"libA":   {"dURL": "a.lib", "DOC1": "a1", "DOC2": "shared"},
+		"libB":   {"dURL": "b.lib", "DOC1": "libC", "DOC2": "b1"},
+		"libC":   {"dURL": "c.lib", "DOC1": "shared", "DOC2": "c1"},
+		"loop":   {"dURL": "loop.lib", "DOC1": "inner"},
+		"inner":  {"dURL": "inner.lib", "DOC1": "loop"},
+		"self":   {"dURL": "self.lib", "DOC1": "self"},
+		"flakyL": {"dURL": "f.lib", "DOC1": "f1"},
+	}, "flakyL", "a1")
+
+	docs, libs, err := expandLibraries([]string{"page", "libA", "libB", "libC", "flakyL"}, node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"page", "a1", "shared", "c1", "b1", "f1"}
+	if !reflect.DeepEqual(docs, want) {
+		t.Errorf("docs = %v, want %v", docs, want)
+	}
+	var names []string
+	for _, l := range libs {
+		names = append(names, l.SCID)
+	}
+	if want := []string{"libA", "libC", "libB", "flakyL"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("libraries = %v, want %v", names, want)
+	}
+
+	for _, list := range [][]string{{"loop"}, {"self"}} {
+		if _, _, err := expandLibraries(list, node); err == nil || !strings.Contains(err.Error(), "includes itself") {
+			t.Errorf("%v: err = %v, want a cycle error", list, err)
+		}
+	}
+}
+
+func TestDedupeDOCs(t *testing.T) {
+	tests := []struct {
+		index, docs, want []string
+	}{
+		{[]string{"a", "lib"}, []string{"a", "x", "a"}, []string{"a", "x"}},
+		{[]string{"a", "a"}, []string{"a", "a"}, []string{"a", "a"}},
+		{[]string{"l1", "l2"}, []string{"x", "y", "x"}, []string{"x", "y"}},
+	}
+	for _, tt := range tests {
+		if got := dedupeDOCs(tt.index, tt.docs); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("dedupeDOCs(%v, %v) = %v, want %v", tt.index, tt.docs, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Every subsystem logs through its own slog logger so its level can be
+// changed on its own. Records go to a size-rotated file in the state dir.
+
+const (
+	maxLogSize = 5 << 20 // rotate once the log reaches this size
+	logBackups = 3       // rotated files kept: .1 (newest) to .3
+)
+
+var (
+	logLevels = map[string]*slog.LevelVar{}
+	logOut    = &rotatingLog{}
+
+	logNative = newLogger("native")
+	logTELA   = newLogger("tela")
+	logGnomon = newLogger("gnomon")
+	logProxy  = newLogger("proxy")
+)
+
+func newLogger(subsystem string) *slog.Logger {
+	lv := new(slog.LevelVar)
+	logLevels[subsystem] = lv
+	text := slog.NewTextHandler(logOut, &slog.HandlerOptions{Level: slog.LevelDebug})
+	return slog.New(&subsystemHandler{
+		subsystem: subsystem,
+		level:     lv,
+		text:      text.WithAttrs([]slog.Attr{slog.String("subsystem", subsystem)}),
+	})
+}
+
+// subsystemHandler writes records at or above the subsystem's level to the
+// log file, and hands records to the log stream, which may want a lower
+// level than the file.
+type subsystemHandler struct {
+	subsystem string
+	level     *slog.LevelVar
+	text      slog.Handler
+	attrs     []slog.Attr
+}
+
+func (h *subsystemHandler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= h.level.Level() || logStreamWants(h.subsystem, l)
+}
+
+func (h *subsystemHandler) Handle(ctx context.Context, r slog.Record) error {
+	recordLog(h.subsystem, r, h.attrs)
+	if r.Level < h.level.Level() {
+		return nil
+	}
+	return h.text.Handle(ctx, r)
+}
+
+func (h *subsystemHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.text = h.text.WithAttrs(attrs)
+	c.attrs = append(append([]slog.Attr{}, h.attrs...), attrs...)
+	return &c
+}
+
+func (h *subsystemHandler) WithGroup(name string) slog.Handler {
+	c := *h
+	c.text = h.text.WithGroup(name)
+	return &c
+}
+
+// -------------------- ROTATION --------------------
+
+// rotatingLog is the log file, renamed to .1 when it outgrows maxLogSize.
+// Until it is opened, records go to stderr.
+type rotatingLog struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+	size int64
+}
+
+func (l *rotatingLog) open(path string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.path = path
+	return l.reopen()
+}
+
+// reopen opens l.path for appending. Callers must hold l.mu.
+func (l *rotatingLog) reopen() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	// Files from older versions were created 0666
+	f.Chmod(0600)
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if l.f != nil {
+		l.f.Close()
+	}
+	l.f, l.size = f, fi.Size()
+	return nil
+}
+
+// rotate shifts the backups up by one and starts a new file. Callers must
+// hold l.mu.
+func (l *rotatingLog) rotate() error {
+	l.f.Close()
+	l.f = nil
+	for i := logBackups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1))
+	}
+	if err := os.Rename(l.path, l.path+".1"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return l.reopen()
+}
+
+func (l *rotatingLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.f == nil {
+		return os.Stderr.Write(p)
+	}
+	if l.size+int64(len(p)) > maxLogSize && l.size > 0 {
+		if err := l.rotate(); err != nil {
+			return os.Stderr.Write(p)
+		}
+	}
+	n, err := l.f.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+// -------------------- SETUP --------------------
+
+// initLogging opens the log file in the state dir and routes the standard
+// log package and stdout, which the Gnomon indexer writes to, through the
+// gnomon logger. os.Stdout must not reach the browser: it is replaced by a
+// pipe, and native messages go to nativeStdout.
+func initLogging() error {
+	path, err := statePath("purewolf-native.log")
+	if err != nil {
+		return err
+	}
+	if err := logOut.open(path); err != nil {
+		return err
+	}
+
+	log.SetFlags(0)
+	log.SetOutput(lineWriter(logNative, slog.LevelInfo))
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	os.Stdout = w
+	go func() {
+		s := bufio.NewScanner(r)
+		for s.Scan() {
+			logGnomon.Info(strings.TrimSpace(s.Text()), "stream", "stdout")
+		}
+	}()
+	return nil
+}
+
+// lineWriter turns each write into one record of logger, for libraries that
+// log through the standard log package.
+func lineWriter(logger *slog.Logger, level slog.Level) io.Writer {
+	return writerFunc(func(p []byte) (int, error) {
+		logger.Log(context.Background(), level, strings.TrimSpace(string(p)))
+		return len(p), nil
+	})
+}
+
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+// setLogLevel changes the level of one subsystem, or all of them when
+// subsystem is empty, and returns the levels now in effect.
+func setLogLevel(subsystem, level string) (map[string]string, error) {
+	var lv slog.Level
+	if err := lv.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("level must be debug, info, warn or error")
+	}
+	if subsystem != "" {
+		v, ok := logLevels[subsystem]
+		if !ok {
+			return nil, fmt.Errorf("unknown subsystem %q, expected one of %s", subsystem, strings.Join(logSubsystems(), ", "))
+		}
+		v.Set(lv)
+	} else {
+		for _, v := range logLevels {
+			v.Set(lv)
+		}
+	}
+	logNative.Info("log level changed", "target", subsystem, "level", lv)
+	return currentLogLevels(), nil
+}
+
+func currentLogLevels() map[string]string {
+	levels := map[string]string{}
+	for name, v := range logLevels {
+		levels[name] = strings.ToLower(v.Level().String())
+	}
+	return levels
+}
+
+func logSubsystems() []string {
+	names := make([]string, 0, len(logLevels))
+	for name := range logLevels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
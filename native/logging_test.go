@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotatingLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.log")
+	l := &rotatingLog{}
+	if err := l.open(path); err != nil {
+		t.Fatal(err)
+	}
+	defer l.f.Close()
+
+	// Each chunk is over half the limit, so every write after the first
+	// rotates
+	chunk := func(i int) []byte { return bytes.Repeat([]byte{byte('a' + i)}, maxLogSize/2+1) }
+	for i := 0; i < logBackups+2; i++ {
+		if _, err := l.Write(chunk(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := map[string]int{path: logBackups + 1}
+	for i := 1; i <= logBackups; i++ {
+		want[fmt.Sprintf("%s.%d", path, i)] = logBackups + 1 - i
+	}
+	for file, i := range want {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, chunk(i)) {
+			t.Errorf("%s does not hold write %d", filepath.Base(file), i)
+		}
+	}
+	if _, err := os.Stat(fmt.Sprintf("%s.%d", path, logBackups+1)); err == nil {
+		t.Errorf("more than %d backups kept", logBackups)
+	}
+	if fi, _ := os.Stat(path); fi.Mode().Perm() != 0600 {
+		t.Errorf("log mode %v, want 0600", fi.Mode().Perm())
+	}
+}
+
+func TestSetLogLevel(t *testing.T) {
+	prev := currentLogLevels()
+	t.Cleanup(func() {
+		for name, level := range prev {
+			setLogLevel(name, level)
+		}
+	})
+
+	tests := []struct {
+		subsystem, level string
+		want             map[string]string
+		wantErr          bool
+	}{
+		{"", "warn", map[string]string{"native": "warn", "tela": "warn", "gnomon": "warn", "proxy": "warn"}, false},
+		{"tela", "debug", map[string]string{"native": "warn", "tela": "debug", "gnomon": "warn", "proxy": "warn"}, false},
+		{"tela", "loud", nil, true},
+		{"nobody", "info", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := setLogLevel(tt.subsystem, tt.level)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("setLogLevel(%q, %q) err = %v", tt.subsystem, tt.level, err)
+		}
+		for name, level := range tt.want {
+			if got[name] != level {
+				t.Errorf("setLogLevel(%q, %q): %s at %s, want %s", tt.subsystem, tt.level, name, got[name], level)
+			}
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The extension can subscribe to log records, which are then sent as log
+// events. Each client subscribes per tab, so several dashboards can follow
+// different filters; events carry the tab they are for. The last
+// logHistorySize records are kept so a dashboard opened later can show what
+// happened before it subscribed.
+
+const logHistorySize = 500
+
+type logEntry struct {
+	Time      time.Time      `json:"time"`
+	Level     string         `json:"level"`
+	Subsystem string         `json:"subsystem"`
+	Msg       string         `json:"msg"`
+	Attrs     map[string]any `json:"attrs,omitempty"`
+
+	level slog.Level
+}
+
+// logFilter selects the records a subscriber receives. No subsystems means
+// all of them.
+type logFilter struct {
+	level      slog.Level
+	subsystems map[string]bool
+}
+
+func (f *logFilter) match(subsystem string, l slog.Level) bool {
+	return l >= f.level && (len(f.subsystems) == 0 || f.subsystems[subsystem])
+}
+
+// logSubscriber is one tab of one client following the log.
+type logSubscriber struct {
+	client *nativeClient
+	tab    string
+}
+
+var (
+	logStreamMu sync.Mutex
+	logHistory  []logEntry // ring of logHistorySize, oldest at logNext once full
+	logNext     int
+	logSubs     = map[logSubscriber]*logFilter{}
+)
+
+// logStreamWants reports whether a subscriber wants records the log file
+// does not, so they are not filtered out before reaching the stream.
+func logStreamWants(subsystem string, l slog.Level) bool {
+	logStreamMu.Lock()
+	defer logStreamMu.Unlock()
+	for _, f := range logSubs {
+		if f.match(subsystem, l) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordLog adds a record to the history and sends it to the subscribers
+// whose filter it matches.
+func recordLog(subsystem string, r slog.Record, attrs []slog.Attr) {
+	e := logEntry{
+		Time:      r.Time,
+		Level:     strings.ToLower(r.Level.String()),
+		Subsystem: subsystem,
+		Msg:       r.Message,
+		level:     r.Level,
+	}
+	add := func(a slog.Attr) bool {
+		if e.Attrs == nil {
+			e.Attrs = map[string]any{}
+		}
+		v := a.Value.Resolve()
+		if v.Kind() == slog.KindAny {
+			e.Attrs[a.Key] = fmt.Sprint(v.Any())
+		} else {
+			e.Attrs[a.Key] = v.Any()
+		}
+		return true
+	}
+	for _, a := range attrs {
+		add(a)
+	}
+	r.Attrs(add)
+
+	logStreamMu.Lock()
+	if len(logHistory) < logHistorySize {
+		logHistory = append(logHistory, e)
+	} else {
+		logHistory[logNext] = e
+	}
+	logNext = (logNext + 1) % logHistorySize
+	var targets []logSubscriber
+	for sub, f := range logSubs {
+		if f.match(subsystem, r.Level) {
+			targets = append(targets, sub)
+		}
+	}
+	logStreamMu.Unlock()
+
+	for _, sub := range targets {
+		ev := map[string]any{
+			"event":     "log",
+			"time":      e.Time,
+			"level":     e.Level,
+			"subsystem": e.Subsystem,
+			"msg":       e.Msg,
+			"attrs":     e.Attrs,
+		}
+		if sub.tab != "" {
+			ev["tab"] = sub.tab
+		}
+		sendTo(sub.client, ev)
+	}
+}
+
+// subscribeLogs starts streaming records at or above level from the given
+// subsystems (all when empty) to a tab of c, replacing that tab's previous
+// filter, and returns the matching history, oldest first.
+func subscribeLogs(c *nativeClient, tab, level string, subsystems []string) (map[string]any, error) {
+	f := &logFilter{level: slog.LevelInfo, subsystems: map[string]bool{}}
+	if level != "" {
+		if err := f.level.UnmarshalText([]byte(level)); err != nil {
+			return nil, fmt.Errorf("level must be debug, info, warn or error")
+		}
+	}
+	for _, s := range subsystems {
+		if _, ok := logLevels[s]; !ok {
+			return nil, fmt.Errorf("unknown subsystem %q, expected one of %s", s, strings.Join(logSubsystems(), ", "))
+		}
+		f.subsystems[s] = true
+	}
+
+	logStreamMu.Lock()
+	defer logStreamMu.Unlock()
+	logSubs[logSubscriber{client: c, tab: tab}] = f
+
+	history := []logEntry{}
+	for i := range logHistory {
+		e := logHistory[(logNext+i)%len(logHistory)]
+		if f.match(e.Subsystem, e.level) {
+			history = append(history, e)
+		}
+	}
+	return map[string]any{
+		"history":    history,
+		"subsystems": logSubsystems(),
+		"levels":     currentLogLevels(),
+	}, nil
+}
+
+// unsubscribeLogs stops streaming to a tab of c, or to all of its tabs when
+// tab is nil.
+func unsubscribeLogs(c *nativeClient, tab *string) {
+	logStreamMu.Lock()
+	defer logStreamMu.Unlock()
+	for sub := range logSubs {
+		if sub.client == c && (tab == nil || sub.tab == *tab) {
+			delete(logSubs, sub)
+		}
+	}
+}
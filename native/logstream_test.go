@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+// receivedMsgs decodes the messages written to a client.
+func receivedMsgs(t *testing.T, b *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var events []map[string]any
+	for b.Len() > 0 {
+		raw, err := readMsg(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ev map[string]any
+		if err := json.Unmarshal(raw, &ev); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, ev)
+	}
+	return events
+}
+
+// withClient connects a client for one test.
+func withClient(t *testing.T) *nativeClient {
+	t.Helper()
+	c := &nativeClient{w: &bytes.Buffer{}}
+	clientsMu.Lock()
+	clients[c] = true
+	clientsMu.Unlock()
+	t.Cleanup(func() {
+		clientsMu.Lock()
+		delete(clients, c)
+		clientsMu.Unlock()
+	})
+	return c
+}
+
+// received decodes the messages a withClient client was sent since the
+// last call. Safe while other goroutines are still sending.
+func received(t *testing.T, c *nativeClient) []map[string]any {
+	t.Helper()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return receivedMsgs(t, c.w.(*bytes.Buffer))
+}
+
+func TestLogSubscriptionsPerTab(t *testing.T) {
+	var outA, outB bytes.Buffer
+	a, b := &nativeClient{w: &outA}, &nativeClient{w: &outB}
+	t.Cleanup(func() {
+		unsubscribeLogs(a, nil)
+		unsubscribeLogs(b, nil)
+	})
+
+	if _, err := subscribeLogs(a, "all", "debug", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := subscribeLogs(a, "tela", "info", []string{"tela"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := subscribeLogs(b, "", "error", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	record := func(subsystem string, level slog.Level) {
+		recordLog(subsystem, slog.NewRecord(time.Now(), level, "msg", 0), nil)
+	}
+	record("tela", slog.LevelInfo)
+	record("proxy", slog.LevelDebug)
+	record("gnomon", slog.LevelError)
+
+	tabsOf := func(events []map[string]any) map[string]int {
+		n := map[string]int{}
+		for _, ev := range events {
+			tab, _ := ev["tab"].(string)
+			n[tab]++
+		}
+		return n
+	}
+	gotA := tabsOf(receivedMsgs(t, &outA))
+	if gotA["all"] != 3 || gotA["tela"] != 1 || len(gotA) != 2 {
+		t.Errorf("client a got %v, want all:3 tela:1", gotA)
+	}
+	gotB := tabsOf(receivedMsgs(t, &outB))
+	if gotB[""] != 1 || len(gotB) != 1 {
+		t.Errorf("client b got %v, want one untagged error", gotB)
+	}
+
+	// One tab leaving keeps the others; a client leaving drops all of its
+	// tabs but no one else's
+	tab := "tela"
+	unsubscribeLogs(a, &tab)
+	record("tela", slog.LevelError)
+	if got := tabsOf(receivedMsgs(t, &outA)); got["all"] != 1 || len(got) != 1 {
+		t.Errorf("after unsubscribing a tab, a got %v", got)
+	}
+	receivedMsgs(t, &outB)
+
+	unsubscribeLogs(a, nil)
+	record("tela", slog.LevelError)
+	if outA.Len() != 0 {
+		t.Error("disconnected client still receives logs")
+	}
+	if got := receivedMsgs(t, &outB); len(got) != 1 {
+		t.Errorf("other client got %d events", len(got))
+	}
+}
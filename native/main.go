@@ -2,7 +2,6 @@ package main
 
 import (
 	"flag"
-	"log"
 	"os"
 	"os/signal"
 	"syscall"
@@ -10,34 +9,91 @@ import (
 	"github.com/civilware/tela"
 )
 
+// version is the release of the host, overridden at build time with
+// -ldflags "-X main.version=...".
+var version = "0.9.70"
+
 var (
 	telaPort   = flag.Int("tela-port", 4040, "TELA control port")
-	scidRoot   = flag.String("scid-root", "scids", "Local SCID folders")
+	scidRoot   = flag.String("scid-root", "scids", "Developer mode: serve the folders in this directory (relative to the data directory)")
 	gnomonPort = flag.Int("gnomon-api", 8099, "Gnomon API")
+	cacheMB    = flag.Int("cache-mb", 512, "TELA cache size limit in MB")
+	metricsOn  = flag.Bool("metrics", false, "Serve Prometheus metrics at /metrics on the TELA port")
+	daemonMode = flag.Bool("daemon", false, "Run as the shared background host (started automatically)")
+	standalone = flag.Bool("standalone", false, "Run in-process instead of relaying to the shared background host")
+	dataDir    = flag.String("data-dir", "", "Keep all data, cache and logs under this directory (default: XDG dirs, or $PUREWOLF_DATA_DIR)")
 )
 
 func main() {
 	nativeStdout = os.Stdout
 
-	logFile, _ := os.OpenFile("/tmp/purewolf-native.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-	log.SetOutput(logFile)
-	os.Stdout = logFile
-
 	flag.Parse()
 
+	if err := initDataDirs(*dataDir); err != nil {
+		fatal("Failed to init data dirs", err)
+	}
+	// Launched by the browser: relay to the shared daemon, falling back to
+	// running in-process when it cannot be reached or started
+	if !*daemonMode && !*standalone {
+		err := runThinClient()
+		if err == nil {
+			return
+		}
+		logNative.Warn("running in-process", "err", err)
+	}
+	if err := lockInstance(); err != nil {
+		fatal("Failed to lock data dir", err)
+	}
+
+	if err := initLogging(); err != nil {
+		fatal("Failed to open log", err)
+	}
+
+	migrateLegacyDir()
+	logNative.Info("data directories", "data", dataDirs.data, "cache", dataDirs.cache, "logs", dataDirs.state)
+	loadSettings()
+	if err := tela.SetShardPath(dataDirs.data); err != nil {
+		logTELA.Warn("could not set clone path", "err", err)
+	}
+
 	if err := initStorage(); err != nil {
-		log.Fatalf("Failed to init storage: %v", err)
+		fatal("Failed to init storage", err)
+	}
+	if err := initCache(); err != nil {
+		fatal("Failed to init cache", err)
+	}
+	initDevMode()
+	if settingBool("metrics") {
+		startTELA()
 	}
 
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-c
-		closeStorage()
-		tela.ShutdownTELA()
-		os.Exit(0)
+		shutdown()
 	}()
 
-	log.Println("PureWolf Native started")
+	logNative.Info("PureWolf Native started", "version", version, "daemon", *daemonMode)
+	if *daemonMode {
+		serveDaemon()
+		return
+	}
+
+	go func() {
+		serveClient(&nativeClient{w: nativeStdout}, os.Stdin)
+		close(inbox)
+	}()
 	nativeLoop()
-}
\ No newline at end of file
+}
+
+func shutdown() {
+	closeStorage()
+	tela.ShutdownTELA()
+	os.Exit(0)
+}
+
+func fatal(msg string, err error) {
+	logNative.Error(msg, "err", err)
+	os.Exit(1)
+}
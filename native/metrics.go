@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// With --metrics (or the metrics setting) the TELA port also serves
+// /metrics in the Prometheus text format. Everything is counted whether or
+// not the endpoint is on; it only decides whether /metrics answers.
+// The metrics name the SCIDs that were opened, so they are for scrapers
+// only: never for pages, and only on the proxy's own address.
+
+// -------------------- COLLECTORS --------------------
+
+// metricVec is a counter or histogram family keyed by its label values.
+type metricVec struct {
+	mu      sync.Mutex
+	name    string
+	help    string
+	kind    string // counter or histogram
+	labels  []string
+	buckets []float64 // histograms only
+	series  map[string]*series
+}
+
+type series struct {
+	labels []string
+	value  float64  // counter value, or histogram sum
+	count  uint64   // histogram observations
+	counts []uint64 // histogram observations per bucket
+}
+
+var latencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+var metricFamilies []*metricVec
+
+func newCounter(name, help string, labels ...string) *metricVec {
+	m := &metricVec{name: name, help: help, kind: "counter", labels: labels, series: map[string]*series{}}
+	metricFamilies = append(metricFamilies, m)
+	return m
+}
+
+func newHistogram(name, help string, buckets []float64, labels ...string) *metricVec {
+	m := newCounter(name, help, labels...)
+	m.kind, m.buckets = "histogram", buckets
+	return m
+}
+
+func (m *metricVec) get(values []string) *series {
+	key := strings.Join(values, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{labels: values, counts: make([]uint64, len(m.buckets))}
+		m.series[key] = s
+	}
+	return s
+}
+
+func (m *metricVec) add(v float64, values ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.get(values).value += v
+}
+
+func (m *metricVec) inc(values ...string) { m.add(1, values...) }
+
+func (m *metricVec) observe(v float64, values ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.get(values)
+	s.value += v
+	s.count++
+	for i, b := range m.buckets {
+		if v <= b {
+			s.counts[i]++
+		}
+	}
+}
+
+var (
+	mDaemonDuration = newHistogram("purewolf_daemon_rpc_duration_seconds", "Daemon RPC call latency.", latencyBuckets, "method")
+	mDaemonErrors   = newCounter("purewolf_daemon_rpc_errors_total", "Daemon RPC calls that failed.", "method")
+	mLoadDuration   = newHistogram("purewolf_scid_load_duration_seconds", "Time to load a SCID that loaded.", latencyBuckets)
+	mLoadFailures   = newCounter("purewolf_scid_load_failures_total", "SCID loads that failed.", "reason")
+	mProxyRequests  = newCounter("purewolf_proxy_requests_total", "Requests served by the TELA proxy.", "scid", "status")
+	mNativeMessages = newCounter("purewolf_native_messages_total", "Native messages exchanged with the extension.", "direction")
+	mNativeBytes    = newCounter("purewolf_native_message_bytes_total", "Bytes of native messages exchanged with the extension.", "direction")
+
+	syncStateName   atomic.Value // string
+	lastChainHeight atomic.Int64
+)
+
+// Sync states reported by purewolf_sync_state.
+var syncStates = []string{"stopped", "waiting", "fastsync", "live"}
+
+func setSyncState(state string) { syncStateName.Store(state) }
+
+// timeDaemonCall records the latency and outcome of one daemon RPC.
+func timeDaemonCall(method string, start time.Time, err error) {
+	mDaemonDuration.observe(time.Since(start).Seconds(), method)
+	if err != nil {
+		mDaemonErrors.inc(method)
+	}
+}
+
+// -------------------- PROXY --------------------
+
+// statusRecorder remembers the status written by a handler. It passes
+// Hijack through so the bridge's WebSocket upgrade still works.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("connection cannot be hijacked")
+	}
+	r.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter { return r.ResponseWriter }
+
+// countRequests counts proxy requests by SCID and status. Paths that are not
+// a served SCID share one label so random requests cannot grow the series.
+func countRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		scid, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/tela/"), "/")
+		if !isLoaded(scid) {
+			scid = "other"
+		}
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		mProxyRequests.inc(scid, fmt.Sprint(rec.status))
+	})
+}
+
+// -------------------- EXPOSITION --------------------
+
+// metricsRefusal says why a request may not read the metrics, or returns
+// "" when it may. Browsers mark requests made by pages with Origin or
+// Sec-Fetch-Site; only a user typing the address (Sec-Fetch-Site: none)
+// gets through. App origins and other names for this host are refused so
+// that pages cannot reach the endpoint by DNS rebinding either.
+func metricsRefusal(r *http.Request) string {
+	if r.Header.Get("Origin") != "" {
+		return "request from a page"
+	}
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" && site != "none" {
+		return "request from a page"
+	}
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	if host != "127.0.0.1" && host != "localhost" && host != "[::1]" && host != "::1" {
+		return "request to " + host
+	}
+	return ""
+}
+
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	if !settingBool("metrics") {
+		http.NotFound(w, r)
+		return
+	}
+	if why := metricsRefusal(r); why != "" {
+		logProxy.Warn("metrics request refused", "reason", why)
+		http.Error(w, "metrics are only served to local scrapers", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	b := bufio.NewWriter(w)
+	defer b.Flush()
+
+	gauge := func(name, help string, v float64) {
+		fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s gauge\n%s %g\n", name, help, name, name, v)
+	}
+
+	indexed, _ := boltDB.GetLastIndexHeight()
+	gauge("purewolf_indexed_height", "Height indexed by Gnomon.", float64(indexed))
+	gauge("purewolf_chain_height", "Chain height last reported by the daemon.", float64(lastChainHeight.Load()))
+
+	state, _ := syncStateName.Load().(string)
+	if state == "" {
+		state = "stopped"
+	}
+	fmt.Fprintf(b, "# HELP purewolf_sync_state Current sync state, 1 for the active one.\n# TYPE purewolf_sync_state gauge\n")
+	for _, s := range syncStates {
+		v := 0
+		if s == state {
+			v = 1
+		}
+		fmt.Fprintf(b, "purewolf_sync_state{state=%q} %d\n", s, v)
+	}
+
+	stats := cache.stats()
+	counter := func(name, help string, v any) {
+		fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s counter\n%s %v\n", name, help, name, name, v)
+	}
+	counter("purewolf_cache_hits_total", "App cache lookups served from the cache.", stats["hits"])
+	counter("purewolf_cache_misses_total", "App cache lookups that had to rebuild.", stats["misses"])
+	gauge("purewolf_cache_size_bytes", "Bytes used by the content cache.", float64(stats["size"].(int64)))
+	gauge("purewolf_cache_limit_bytes", "Content cache size limit, 0 for none.", float64(stats["limit"].(int64)))
+	gauge("purewolf_loaded_scids", "SCIDs currently served.", float64(len(loadedSCIDs())))
+
+	for _, m := range metricFamilies {
+		m.write(b)
+	}
+}
+
+func (m *metricVec) write(b *bufio.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+	keys := make([]string, 0, len(m.series))
+	for k := range m.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		s := m.series[k]
+		if m.kind == "counter" {
+			fmt.Fprintf(b, "%s%s %g\n", m.name, labelSet(m.labels, s.labels, ""), s.value)
+			continue
+		}
+		for i, le := range m.buckets {
+			fmt.Fprintf(b, "%s_bucket%s %d\n", m.name, labelSet(m.labels, s.labels, fmt.Sprint(le)), s.counts[i])
+		}
+		fmt.Fprintf(b, "%s_bucket%s %d\n", m.name, labelSet(m.labels, s.labels, "+Inf"), s.count)
+		fmt.Fprintf(b, "%s_sum%s %g\n", m.name, labelSet(m.labels, s.labels, ""), s.value)
+		fmt.Fprintf(b, "%s_count%s %d\n", m.name, labelSet(m.labels, s.labels, ""), s.count)
+	}
+}
+
+// labelSet formats {name="value",...}, adding le for histogram buckets.
+func labelSet(names, values []string, le string) string {
+	var parts []string
+	for i, n := range names {
+		parts = append(parts, fmt.Sprintf("%s=%q", n, values[i]))
+	}
+	if le != "" {
+		parts = append(parts, fmt.Sprintf("le=%q", le))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLabelSet(t *testing.T) {
+	tests := []struct {
+		names, values []string
+		le            string
+		want          string
+	}{
+		{nil, nil, "", ""},
+		{nil, nil, "+Inf", `{le="+Inf"}`},
+		{[]string{"method"}, []string{"GetInfo"}, "", `{method="GetInfo"}`},
+		{[]string{"scid", "status"}, []string{"ab", "200"}, "0.5", `{scid="ab",status="200",le="0.5"}`},
+		{[]string{"reason"}, []string{`say "no"`}, "", `{reason="say \"no\""}`},
+	}
+	for _, tt := range tests {
+		if got := labelSet(tt.names, tt.values, tt.le); got != tt.want {
+			t.Errorf("labelSet(%v, %v, %q) = %s, want %s", tt.names, tt.values, tt.le, got, tt.want)
+		}
+	}
+}
+
+func TestMetricOutput(t *testing.T) {
+	render := func(m *metricVec) string {
+		var buf bytes.Buffer
+		b := bufio.NewWriter(&buf)
+		m.write(b)
+		b.Flush()
+		return buf.String()
+	}
+
+	c := &metricVec{name: "test_total", help: "Test.", kind: "counter", labels: []string{"dir"}, series: map[string]*series{}}
+	c.inc("out")
+	c.add(2, "in")
+	want := "# HELP test_total Test.\n# TYPE test_total counter\n" +
+		"test_total{dir=\"in\"} 2\ntest_total{dir=\"out\"} 1\n"
+	if got := render(c); got != want {
+		t.Errorf("counter:\n%s\nwant:\n%s", got, want)
+	}
+
+	h := &metricVec{name: "test_seconds", help: "Test.", kind: "histogram", buckets: []float64{.1, 1}, series: map[string]*series{}}
+	h.observe(.05)
+	h.observe(.5)
+	h.observe(5)
+	want = "# HELP test_seconds Test.\n# TYPE test_seconds histogram\n" +
+		"test_seconds_bucket{le=\"0.1\"} 1\n" +
+		"test_seconds_bucket{le=\"1\"} 2\n" +
+		"test_seconds_bucket{le=\"+Inf\"} 3\n" +
+		"test_seconds_sum 5.55\n" +
+		"test_seconds_count 3\n"
+	if got := render(h); got != want {
+		t.Errorf("histogram:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestMetricsRefusal(t *testing.T) {
+	tests := []struct {
+		name    string
+		host    string
+		headers map[string]string
+		allowed bool
+	}{
+		{"scraper", "127.0.0.1:4040", nil, true},
+		{"scraper by name", "localhost:4040", nil, true},
+		{"typed in the address bar", "127.0.0.1:4040", map[string]string{"Sec-Fetch-Site": "none"}, true},
+		{"page fetch", "127.0.0.1:4040", map[string]string{"Origin": "http://x.localhost:4040"}, false},
+		{"page without Origin", "127.0.0.1:4040", map[string]string{"Sec-Fetch-Site": "cross-site"}, false},
+		{"app origin", appHost("a1"), nil, false},
+		{"rebound name", "evil.example:4040", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/metrics", nil)
+			r.Host = tt.host
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			why := metricsRefusal(r)
+			if (why == "") != tt.allowed {
+				t.Errorf("refusal = %q, allowed want %v", why, tt.allowed)
+			}
+			if !tt.allowed && strings.TrimSpace(why) == "" {
+				t.Error("no reason given")
+			}
+		})
+	}
+}
+
+// counterValue reads one series of a counter.
+func counterValue(m *metricVec, values ...string) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.get(values).value
+}
+
+func TestInboundMessageMetrics(t *testing.T) {
+	frame := func(body string) []byte {
+		h := make([]byte, 4)
+		binary.LittleEndian.PutUint32(h, uint32(len(body)))
+		return append(h, body...)
+	}
+	var in bytes.Buffer
+	in.Write(frame(`{"cmd":"hello","id":1}`))
+	in.Write(frame(`{"cmd":`))
+	in.Write(frame(`[1,2]`))
+	in.Write(frame(`{"cmd":"server_status"}`))
+	in.Write(frame(`{"cmd":"cut off"}`)[:10])
+
+	msgs, size := counterValue(mNativeMessages, "in"), counterValue(mNativeBytes, "in")
+	serveClient(&nativeClient{w: io.Discard}, &in)
+
+	var cmds []string
+	for len(inbox) > 0 {
+		cmd, _ := (<-inbox).msg["cmd"].(string)
+		cmds = append(cmds, cmd)
+	}
+	if strings.Join(cmds, ",") != "hello,server_status" {
+		t.Errorf("dispatched %v", cmds)
+	}
+	if got := counterValue(mNativeMessages, "in") - msgs; got != 2 {
+		t.Errorf("counted %v messages, want 2", got)
+	}
+	want := float64(len(`{"cmd":"hello","id":1}`) + len(`{"cmd":"server_status"}`))
+	if got := counterValue(mNativeBytes, "in") - size; got != want {
+		t.Errorf("counted %v bytes, want %v", got, want)
+	}
+}
@@ -3,194 +3,520 @@ package main
 import (
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"os"
 	"strings"
+	"sync"
 )
 
 var nativeStdout *os.File
-var nodeDisconnected bool
 
-// readMsg reads a length-prefixed JSON message from stdin.
+// nativeClient is one extension connection: the browser's own stdin and
+// stdout, or a thin client relaying them over the daemon socket.
+type nativeClient struct {
+	// mu keeps concurrent senders (sync, load progress) from interleaving
+	// the length prefix and body of separate messages.
+	mu sync.Mutex
+	w  io.Writer
+}
+
+type inboundMsg struct {
+	client *nativeClient
+	msg    map[string]any
+}
+
+var (
+	clientsMu sync.Mutex
+	clients   = map[*nativeClient]bool{}
+	replyTo   = map[any]*nativeClient{} // request id -> client awaiting the reply
+	inbox     = make(chan inboundMsg, 64)
+)
+
+// readMsg reads a length-prefixed JSON message from r.
 // The Chrome Native Messaging protocol sends a 4-byte little-endian
 // length header followed by that many bytes of JSON.
-func readMsg() ([]byte, error) {
+func readMsg(r io.Reader) ([]byte, error) {
 	h := make([]byte, 4)
-	if _, err := io.ReadFull(os.Stdin, h); err != nil {
+	if _, err := io.ReadFull(r, h); err != nil {
 		return nil, err
 	}
 	l := binary.LittleEndian.Uint32(h)
 	msg := make([]byte, l)
-	_, err := io.ReadFull(os.Stdin, msg)
+	_, err := io.ReadFull(r, msg)
 	return msg, err
 }
 
-// sendMsg encodes v as JSON and writes it to the native messaging stdout
-// with the required 4-byte little-endian length prefix.
+// serveClient feeds the messages c sends on r to the dispatcher until r is
+// closed. Messages that are not JSON objects are dropped uncounted.
+func serveClient(c *nativeClient, r io.Reader) {
+	clientsMu.Lock()
+	clients[c] = true
+	clientsMu.Unlock()
+
+	for {
+		raw, err := readMsg(r)
+		if err != nil {
+			break
+		}
+		var msg map[string]any
+		if json.Unmarshal(raw, &msg) != nil {
+			continue
+		}
+		mNativeMessages.inc("in")
+		mNativeBytes.add(float64(len(raw)), "in")
+		inbox <- inboundMsg{client: c, msg: msg}
+	}
+
+	clientsMu.Lock()
+	delete(clients, c)
+	for id, owner := range replyTo {
+		if owner == c {
+			delete(replyTo, id)
+		}
+	}
+	clientsMu.Unlock()
+	unsubscribeLogs(c, nil)
+}
+
+// replyKey returns id as a map key. The extension sends numeric ids; other
+// JSON values are not comparable and are never routed.
+func replyKey(id any) (any, bool) {
+	switch id.(type) {
+	case float64, string:
+		return id, true
+	}
+	return nil, false
+}
+
+func clientCount() int {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	return len(clients)
+}
+
+// sendMsg encodes v as JSON and writes it with the required 4-byte
+// little-endian length prefix. Replies go to the client that sent the
+// request; events and everything else go to every client.
 func sendMsg(v any) {
 	b, _ := json.Marshal(v)
 	h := make([]byte, 4)
 	binary.LittleEndian.PutUint32(h, uint32(len(b)))
-	nativeStdout.Write(h)
-	nativeStdout.Write(b)
+
+	var targets []*nativeClient
+	clientsMu.Lock()
+	if m, ok := v.(map[string]any); ok {
+		if key, ok := replyKey(m["id"]); ok {
+			if c, ok := replyTo[key]; ok {
+				delete(replyTo, key)
+				targets = []*nativeClient{c}
+			}
+		}
+	}
+	if targets == nil {
+		for c := range clients {
+			targets = append(targets, c)
+		}
+	}
+	clientsMu.Unlock()
+
+	for _, c := range targets {
+		c.write(h, b)
+	}
 }
 
-// nativeLoop is the main command dispatcher. It reads messages from stdin
-// in a tight loop and dispatches to the appropriate handler.
-// Returns only when stdin is closed (extension unloaded / browser exit).
-func nativeLoop() {
-	for {
-		raw, err := readMsg()
+// sendTo encodes v like sendMsg but only sends it to c.
+func sendTo(c *nativeClient, v any) {
+	b, _ := json.Marshal(v)
+	h := make([]byte, 4)
+	binary.LittleEndian.PutUint32(h, uint32(len(b)))
+	c.write(h, b)
+}
+
+func (c *nativeClient) write(h, b []byte) {
+	c.mu.Lock()
+	c.w.Write(h)
+	c.w.Write(b)
+	c.mu.Unlock()
+	mNativeMessages.inc("out")
+	mNativeBytes.add(float64(len(b)), "out")
+}
+
+// replyLater runs a command that waits on the node, the wallet or the disk
+// off the dispatcher, so one client's slow command does not hold up the
+// others, and replies when it is done.
+func replyLater(id any, run func() (any, error)) {
+	go func() {
+		result, err := run()
 		if err != nil {
+			sendMsg(map[string]any{"ok": false, "id": id, "error": err.Error()})
 			return
 		}
+		sendMsg(map[string]any{"ok": true, "id": id, "result": result})
+	}()
+}
 
-		var msg map[string]any
-		if json.Unmarshal(raw, &msg) != nil {
-			continue
-		}
-
+// nativeLoop is the main command dispatcher. It handles the messages of
+// every client in the order they arrive, handing slow commands to
+// replyLater.
+// Returns only when the inbox is closed (extension unloaded / browser exit).
+func nativeLoop() {
+	for in := range inbox {
+		msg := in.msg
 		cmd, _ := msg["cmd"].(string)
 		id := msg["id"]
+		if key, ok := replyKey(id); ok {
+			clientsMu.Lock()
+			replyTo[key] = in.client
+			clientsMu.Unlock()
+		}
 
 		switch cmd {
 
+		case "hello":
+			// Where the servers actually listen; a taken port moves them
+			ports := listenAddrs()
+			sendMsg(map[string]any{"ok": true, "id": id, "result": map[string]any{
+				"version": version,
+				"pid":     os.Getpid(),
+				"daemon":  *daemonMode,
+				"tela":    ports["tela"],
+				"gnomon":  ports["gnomon"],
+			}})
+
 		case "set_node":
 			node := strings.TrimSpace(msg["params"].(map[string]any)["node"].(string))
 			if !strings.HasPrefix(node, "http://") {
 				node = "http://" + node
 			}
 
-			if node == currentNode {
+			// The node is shared by every client; the others hear about
+			// the change through node_changed
+			if !setNode(node) {
 				sendMsg(map[string]any{"ok": true, "id": id})
 				break
 			}
-
-			// Cancel previous sync attempt if any
-			if syncCancel != nil {
-				close(syncCancel)
-				syncCancel = nil
-			}
-
-			currentNode = node
-			nodeDisconnected = true
 			startTELA()
 			go startSync(node)
 
 			sendMsg(map[string]any{"ok": true, "id": id})
-
-			mu.RLock()
-			initialSCIDs := make([]string, 0, len(proxies))
-			for scid := range proxies {
-				initialSCIDs = append(initialSCIDs, scid)
-			}
-			mu.RUnlock()
+			broadcastNode()
 
 			sendMsg(map[string]any{
 				"ok":     true,
 				"id":     "init_scids",
-				"result": map[string]any{"scids": initialSCIDs},
+				"result": map[string]any{"scids": loadedSCIDs()},
 			})
 
 		case "disconnect_node":
-			nodeDisconnected = true
+			setNode("")
 			stopSync()
-			resetProxies()
-			currentNode = ""
+			resetApps()
 			sendMsg(map[string]any{"ok": true, "id": id})
+			broadcastNode()
 				
 		case "load_scid":
-			// Ask the TELA proxy to load a SCID and return its URL
-			if currentNode == "" {
-				sendMsg(map[string]any{"ok": false, "id": id, "error": "node not set"})
+			// Ask the TELA proxy to load a SCID and return its URL. Without a
+			// node, previously loaded SCIDs are served from the cache.
+			startTELA()
+
+			scid, _ := msg["params"].(map[string]any)["scid"].(string)
+			addURL := fmt.Sprintf("%s/add/%s", telaOrigin(), scid)
+
+			replyLater(id, func() (any, error) {
+				resp, err := http.Get(addURL)
+				if err != nil {
+					return nil, err
+				}
+				defer resp.Body.Close()
+				if resp.StatusCode != http.StatusOK {
+					b, _ := io.ReadAll(resp.Body)
+					return nil, errors.New(strings.TrimSpace(string(b)))
+				}
+
+				var res map[string]any
+				json.NewDecoder(resp.Body).Decode(&res)
+				result, _ := res["result"].(map[string]any)
+				return result, nil
+			})
+
+		case "diff_scid":
+			// Compare two versions of an INDEX; defaults to pinned vs latest
+			params, _ := msg["params"].(map[string]any)
+			scid, _ := params["scid"].(string)
+			from, _ := params["from"].(float64)
+			to, _ := params["to"].(float64)
+
+			replyLater(id, func() (any, error) { return diffSCID(scid, int64(from), int64(to)) })
+
+		case "verify_scid":
+			// Re-verify a SCID's DOCs and reconstructed files
+			scid, _ := msg["params"].(map[string]any)["scid"].(string)
+			replyLater(id, func() (any, error) { return verifySCID(scid) })
+
+		case "export_scid":
+			// Write a cached SCID and its manifest to a zip or tar archive
+			params, _ := msg["params"].(map[string]any)
+			scid, _ := params["scid"].(string)
+			path, _ := params["path"].(string)
+			replyLater(id, func() (any, error) { return exportSCID(scid, path) })
+
+		case "import_archive":
+			// Serve an exported archive without a node
+			path, _ := msg["params"].(map[string]any)["path"].(string)
+			replyLater(id, func() (any, error) { return importArchive(path) })
+
+		case "validate_folder":
+			// Check a local folder against TELA's publishing rules
+			folder, _ := msg["params"].(map[string]any)["folder"].(string)
+			replyLater(id, func() (any, error) { return validateFolder(folder) })
+
+		case "set_wallet":
+			// Connect a wallet through its RPC server or XSWD
+			params, _ := msg["params"].(map[string]any)
+			mode, _ := params["mode"].(string)
+			endpoint, _ := params["endpoint"].(string)
+			user, _ := params["user"].(string)
+			pass, _ := params["pass"].(string)
+			replyLater(id, func() (any, error) { return connectWallet(mode, strings.TrimSpace(endpoint), user, pass) })
+
+		case "wallet_status":
+			sendMsg(map[string]any{"ok": true, "id": id, "result": walletStatus()})
+
+		case "disconnect_wallet":
+			disconnectWallet()
+			sendMsg(map[string]any{"ok": true, "id": id, "result": walletStatus()})
+
+		case "install_tela":
+			// Publish a local folder through the wallet. Runs in the
+			// background since every transaction waits on the wallet and
+			// the chain; progress arrives as install_progress events.
+			params, _ := msg["params"].(map[string]any)
+			req := installRequest{}
+			req.Folder, _ = params["folder"].(string)
+			req.DURL, _ = params["dURL"].(string)
+			req.Name, _ = params["name"].(string)
+			req.Description, _ = params["description"].(string)
+			req.Icon, _ = params["icon"].(string)
+			go func() {
+				result, err := installTELA(req)
+				if err != nil {
+					logNative.Error("install failed", "folder", req.Folder, "err", err)
+					sendMsg(map[string]any{"ok": false, "id": id, "error": err.Error()})
+					return
+				}
+				sendMsg(map[string]any{"ok": true, "id": id, "result": result})
+			}()
+
+		case "rate_scid":
+			// Rate a SCID through the wallet; category and detail are 0-9.
+			// Runs in the background since the wallet may wait on the user.
+			params, _ := msg["params"].(map[string]any)
+			scid, _ := params["scid"].(string)
+			category, _ := params["category"].(float64)
+			detail, _ := params["detail"].(float64)
+			go func() {
+				result, err := rateSCID(strings.TrimSpace(scid), int(category), int(detail))
+				if err != nil {
+					sendMsg(map[string]any{"ok": false, "id": id, "error": err.Error()})
+					return
+				}
+				sendMsg(map[string]any{"ok": true, "id": id, "result": result})
+			}()
+
+		case "wallet_prompt_reply":
+			// Answer a wallet_prompt event raised by a page's bridge
+			params, _ := msg["params"].(map[string]any)
+			prompt, _ := params["prompt"].(string)
+			allow, _ := params["allow"].(bool)
+			remember, _ := params["remember"].(bool)
+			if err := answerPrompt(prompt, allow, remember); err != nil {
+				sendMsg(map[string]any{"ok": false, "id": id, "error": err.Error()})
 				break
 			}
+			sendMsg(map[string]any{"ok": true, "id": id})
+
+		case "list_wallet_prompts":
+			sendMsg(map[string]any{"ok": true, "id": id, "result": map[string]any{"prompts": pendingPrompts()}})
 
+		case "get_permissions":
+			// One SCID's effective permissions, or every stored SCID
 			scid, _ := msg["params"].(map[string]any)["scid"].(string)
-			addURL := fmt.Sprintf("http://127.0.0.1:%d/add/%s", *telaPort, scid)
+			sendMsg(map[string]any{"ok": true, "id": id, "result": getPermissions(scid)})
 
-			resp, err := http.Get(addURL)
+		case "set_permissions":
+			params, _ := msg["params"].(map[string]any)
+			scid, _ := params["scid"].(string)
+			reset, _ := params["reset"].(bool)
+			result, err := setPermissions(strings.TrimSpace(scid), params, reset)
 			if err != nil {
 				sendMsg(map[string]any{"ok": false, "id": id, "error": err.Error()})
 				break
 			}
-			defer resp.Body.Close()
+			sendMsg(map[string]any{"ok": true, "id": id, "result": result})
 
-			var res map[string]any
-			json.NewDecoder(resp.Body).Decode(&res)
+		case "get_settings":
+			sendMsg(map[string]any{"ok": true, "id": id, "result": getSettings()})
 
-			result, _ := res["result"].(map[string]any)
-			sendMsg(map[string]any{
-				"ok": true,
-				"id": id,
-				"result": map[string]any{
-					"url": result["url"],
-				},
-			})
+		case "set_settings":
+			// Save settings; ports only change on the next start
+			params, _ := msg["params"].(map[string]any)
+			result, err := setSettings(params)
+			if err != nil {
+				sendMsg(map[string]any{"ok": false, "id": id, "error": err.Error()})
+				break
+			}
+			sendMsg(map[string]any{"ok": true, "id": id, "result": result})
 
-		case "server_status":
-			// Check TELA proxy
-			telaOk := false
-			if resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/", *telaPort)); err == nil {
-				resp.Body.Close()
-				telaOk = true
+		case "set_log_level":
+			// Change the level of one subsystem, or of all of them
+			params, _ := msg["params"].(map[string]any)
+			subsystem, _ := params["subsystem"].(string)
+			level, _ := params["level"].(string)
+			levels, err := setLogLevel(subsystem, level)
+			if err != nil {
+				sendMsg(map[string]any{"ok": false, "id": id, "error": err.Error()})
+				break
 			}
-			if nodeDisconnected {
-				telaOk = false
+			sendMsg(map[string]any{"ok": true, "id": id, "result": map[string]any{"levels": levels}})
+
+		case "subscribe_logs":
+			// Stream log records as log events, starting with recent history
+			params, _ := msg["params"].(map[string]any)
+			level, _ := params["level"].(string)
+			tab, _ := params["tab"].(string)
+			var subsystems []string
+			if list, ok := params["subsystems"].([]any); ok {
+				for _, s := range list {
+					if name, ok := s.(string); ok && name != "" {
+						subsystems = append(subsystems, name)
+					}
+				}
+			}
+			result, err := subscribeLogs(in.client, tab, level, subsystems)
+			if err != nil {
+				sendMsg(map[string]any{"ok": false, "id": id, "error": err.Error()})
+				break
 			}
+			sendMsg(map[string]any{"ok": true, "id": id, "result": result})
+
+		case "unsubscribe_logs":
+			params, _ := msg["params"].(map[string]any)
+			tab, _ := params["tab"].(string)
+			unsubscribeLogs(in.client, &tab)
+			sendMsg(map[string]any{"ok": true, "id": id})
+
+		case "diagnostics":
+			// Write a redacted bundle of host, node, sync and log state
+			replyLater(id, func() (any, error) { return writeDiagnostics() })
 
-			// Check Gnomon API
-			gnomonOk := false
-			if resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/api/getinfo", *gnomonPort)); err == nil {
-				resp.Body.Close()
-				gnomonOk = true
+		case "get_authors":
+			sendMsg(map[string]any{"ok": true, "id": id, "result": getAuthors()})
+
+		case "set_author":
+			// Mark an address as trusted or blocked, or clear it with "none"
+			params, _ := msg["params"].(map[string]any)
+			address, _ := params["address"].(string)
+			trust, _ := params["trust"].(string)
+			label, _ := params["label"].(string)
+			if err := setAuthor(strings.TrimSpace(address), trust, label); err != nil {
+				sendMsg(map[string]any{"ok": false, "id": id, "error": err.Error()})
+				break
 			}
-			// Override: API may be up but indexer not connected to any node
-			if !indexerRunning || nodeDisconnected {
-				gnomonOk = false
+			enforceAuthorPolicy()
+			sendMsg(map[string]any{"ok": true, "id": id, "result": getAuthors()})
+
+		case "set_author_policy":
+			// What to do with blocked (warn/refuse) and unknown (allow/warn/refuse) authors
+			params, _ := msg["params"].(map[string]any)
+			onBlocked, _ := params["onBlocked"].(string)
+			onUnknown, _ := params["onUnknown"].(string)
+			if err := setAuthorPolicy(onBlocked, onUnknown); err != nil {
+				sendMsg(map[string]any{"ok": false, "id": id, "error": err.Error()})
+				break
 			}
+			enforceAuthorPolicy()
+			sendMsg(map[string]any{"ok": true, "id": id, "result": getAuthors()})
+
+		case "cache_stats":
+			sendMsg(map[string]any{"ok": true, "id": id, "result": cache.stats()})
 
-			dbHeight, _ := boltDB.GetLastIndexHeight()
-			chainHeight := int64(0)
-			if currentNode != "" {
-				chainHeight = getChainHeightFromDaemon(currentNode)
+		case "purge_cache":
+			// Purge one SCID (INDEX or DOC) or, without a scid, the whole cache
+			params, _ := msg["params"].(map[string]any)
+			scid, _ := params["scid"].(string)
+			if scid != "" && isLoaded(scid) {
+				sendMsg(map[string]any{"ok": false, "id": id, "error": "SCID is loaded, disconnect first"})
+				break
 			}
+			freed := cache.purge(scid)
+			sendMsg(map[string]any{"ok": true, "id": id, "result": map[string]any{"freed": freed}})
 
-			sendMsg(map[string]any{
-				"ok": true,
-				"id": id,
-				"result": map[string]any{
+		case "server_status":
+			// The node state is read here; the checks run off the dispatcher
+			node, disconnected, indexing := nodeStatus()
+			replyLater(id, func() (any, error) {
+				// Check TELA proxy
+				telaOk := false
+				if resp, err := http.Get(telaOrigin() + "/"); err == nil {
+					resp.Body.Close()
+					telaOk = true
+				}
+				if disconnected {
+					telaOk = false
+				}
+
+				// Check Gnomon API
+				gnomonOk := gnomonAnswers(gnomonAPIBase())
+				// Override: API may be up but indexer not connected to any node
+				if !indexing || disconnected {
+					gnomonOk = false
+				}
+
+				dbHeight, _ := boltDB.GetLastIndexHeight()
+				chainHeight := int64(0)
+				if node != "" {
+					chainHeight = getChainHeightFromDaemon(node)
+				}
+
+				return map[string]any{
 					"tela":      telaOk,
 					"gnomon":    gnomonOk,
 					"connected": telaOk && gnomonOk,
-					"node":      currentNode,
+					"node":      node,
+					"offline":   node == "" || disconnected,
+					"stale":     staleSCIDs(),
+					"dev":       map[string]any{"root": currentDevRoot(), "folders": devFolders()},
+					"instance": map[string]any{
+						"pid":     os.Getpid(),
+						"daemon":  *daemonMode,
+						"clients": clientCount(),
+					},
+					"ports": listenAddrs(),
+					"dirs": map[string]any{
+						"data":  dataDirs.data,
+						"cache": dataDirs.cache,
+						"logs":  dataDirs.state,
+					},
 					"heights": map[string]any{
 						"indexed": dbHeight,
 						"chain":   chainHeight,
 					},
-				},
+				}, nil
 			})
 
 		case "list_scids":
-			// Return all currently proxied SCIDs
-			mu.RLock()
-			loadedSCIDs := make([]string, 0, len(proxies))
-			for scid := range proxies {
-				loadedSCIDs = append(loadedSCIDs, scid)
-			}
-			mu.RUnlock()
-
+			// Return all currently served SCIDs
 			sendMsg(map[string]any{
 				"ok":     true,
 				"id":     id,
-				"result": map[string]any{"scids": loadedSCIDs},
+				"result": map[string]any{"scids": loadedSCIDs()},
 			})
 
 		default:
-			log.Printf("Unknown command: %s", cmd)
+			logNative.Warn("unknown command", "cmd", cmd)
 			sendMsg(map[string]any{"ok": false, "id": id, "error": "unknown command"})
 		}
 	}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/deroproject/derohe/rpc"
+)
+
+// callDaemon performs a single JSON-RPC call against the DERO daemon at node
+// and decodes the result field into out.
+func callDaemon(node, method string, params, out any) error {
+	if !strings.HasPrefix(node, "http://") {
+		node = "http://" + node
+	}
+	start := time.Now()
+	err := callRPC(node+"/json_rpc", "", "", 15*time.Second, method, params, out)
+	timeDaemonCall(method, start, err)
+	return err
+}
+
+// callRPC performs a single JSON-RPC call against endpoint, with basic auth
+// when user is set, and decodes the result field into out.
+func callRPC(endpoint, user, pass string, timeout time.Duration, method string, params, out any) error {
+	req := map[string]any{"jsonrpc": "2.0", "id": "1", "method": method}
+	if params != nil {
+		req["params"] = params
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	httpReq, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if user != "" {
+		httpReq.SetBasicAuth(user, pass)
+	}
+
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		return fmt.Errorf("%s: unauthorized", method)
+	}
+
+	var res struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	if res.Error != nil {
+		return fmt.Errorf("%s: %s", method, res.Error.Message)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(res.Result, out)
+}
+
+// getSCVariables returns the string-keyed variables of a smart contract at
+// the given topoheight (0 means the current top). String values are returned
+// hex encoded by the daemon and are decoded here.
+func getSCVariables(node, scid string, height int64) (map[string]string, error) {
+	vars, _, err := getSC(node, scid, height, false)
+	return vars, err
+}
+
+// getSC returns the decoded string-keyed variables of a smart contract at the
+// given topoheight and, when withCode is set, the code in effect there.
+func getSC(node, scid string, height int64, withCode bool) (map[string]string, string, error) {
+	var res rpc.GetSC_Result
+	params := rpc.GetSC_Params{SCID: scid, Code: withCode, Variables: true, TopoHeight: height}
+	if err := callDaemon(node, "DERO.GetSC", params, &res); err != nil {
+		return nil, "", err
+	}
+
+	vars := make(map[string]string, len(res.VariableStringKeys))
+	for k, v := range res.VariableStringKeys {
+		switch val := v.(type) {
+		case string:
+			if b, err := hex.DecodeString(val); err == nil {
+				vars[k] = string(b)
+			} else {
+				vars[k] = val
+			}
+		case float64:
+			vars[k] = strconv.FormatUint(uint64(val), 10)
+		}
+	}
+	return vars, res.Code, nil
+}
+
+// indexDOCsAtHeight returns the dURL and ordered DOC SCIDs that an INDEX
+// contract referenced at the given topoheight.
+func indexDOCsAtHeight(node, scid string, height int64) (string, []string, error) {
+	vars, code, err := getSC(node, scid, height, true)
+	if err != nil {
+		return "", nil, err
+	}
+
+	durl, docs := indexDOCs(vars, code)
+	if len(docs) == 0 {
+		return "", nil, fmt.Errorf("no DOCs found for %s at height %d", scid, height)
+	}
+	return durl, docs, nil
+}
+
+// indexDOCs returns the dURL and DOC list of an INDEX. UpdateCode replaces
+// the code of an INDEX but not the DOC1..DOCn variables its install stored,
+// so the STORE calls in the code's InitializePrivate are what is in effect,
+// as TELA reads them. The variables are only used for code that lists none.
+func indexDOCs(vars map[string]string, code string) (string, []string) {
+	if durl, docs := indexCodeDOCs(code); len(docs) > 0 {
+		return durl, docs
+	}
+	return vars["dURL"], indexDOCList(vars)
+}
+
+var (
+	initializeFunc = regexp.MustCompile(`(?s)Function\s+InitializePrivate\s*\(\s*\)\s*Uint64(.*?)End\s+Function`)
+	storeString    = regexp.MustCompile(`STORE\(\s*"([A-Za-z0-9]+)"\s*,\s*"([^"]*)"\s*\)`)
+)
+
+// indexCodeDOCs reads the dURL and DOC1..DOCn an INDEX's InitializePrivate
+// stores.
+func indexCodeDOCs(code string) (string, []string) {
+	m := initializeFunc.FindStringSubmatch(code)
+	if m == nil {
+		return "", nil
+	}
+	stored := map[string]string{}
+	for _, s := range storeString.FindAllStringSubmatch(m[1], -1) {
+		stored[s[1]] = s[2]
+	}
+	return stored["dURL"], indexDOCList(stored)
+}
+
+// indexDOCList returns the DOC1..DOCn entries of INDEX variables in order.
+// Contracts that are not an INDEX return an empty list.
+func indexDOCList(vars map[string]string) []string {
+	var docs []string
+	for i := 1; ; i++ {
+		doc, ok := vars[fmt.Sprintf("DOC%d", i)]
+		if !ok {
+			return docs
+		}
+		docs = append(docs, doc)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Capabilities a SCID can be granted. Wallet capabilities cover the XSWD
+// bridge, external fetch covers both the page's own requests (through its
+// Content-Security-Policy) and the fetch bridge, and the storage quota caps
+// the storage bridge.
+const (
+	capWalletRead    = "walletRead"
+	capWalletSign    = "walletSign"
+	capExternalFetch = "externalFetch"
+)
+
+const (
+	permAllow = "allow"
+	permDeny  = "deny"
+	permAsk   = "ask"
+)
+
+const defaultStorageQuota = 1 << 20
+
+// scidPermissions is what one SCID may do. Empty fields take the defaults.
+type scidPermissions struct {
+	WalletRead    string `json:"walletRead"`
+	WalletSign    string `json:"walletSign"`
+	ExternalFetch string `json:"externalFetch"`
+	StorageQuota  int64  `json:"storageQuota"` // bytes, 0 disables storage
+}
+
+func defaultPermissions() scidPermissions {
+	return scidPermissions{
+		WalletRead:    permAsk,
+		WalletSign:    permAsk,
+		ExternalFetch: permAsk,
+		StorageQuota:  defaultStorageQuota,
+	}
+}
+
+// walletReadMethods are the XSWD methods that only read from the wallet.
+// Everything else, including methods the host does not know, counts as
+// signing. Methods that expose keys are never relayed.
+var walletReadMethods = map[string]bool{
+	"GetAddress":             true,
+	"GetBalance":             true,
+	"GetHeight":              true,
+	"GetTransfers":           true,
+	"GetTransferbyTXID":      true,
+	"GetTrackedAssets":       true,
+	"CheckSignature":         true,
+	"GetDaemon":              true,
+	"HasMethod":              true,
+	"Subscribe":              true,
+	"Unsubscribe":            true,
+	"MakeIntegratedAddress":  true,
+	"SplitIntegratedAddress": true,
+}
+
+var walletForbiddenMethods = map[string]bool{
+	"query_key": true,
+	"QueryKey":  true,
+}
+
+// walletCapability returns the capability an XSWD method needs.
+func walletCapability(method string) string {
+	if walletReadMethods[strings.TrimPrefix(method, "DERO.")] {
+		return capWalletRead
+	}
+	return capWalletSign
+}
+
+var (
+	permsMu sync.Mutex
+	perms   map[string]*scidPermissions
+)
+
+// loadPermissions reads permissions.json on first use. Callers must hold permsMu.
+func loadPermissions() map[string]*scidPermissions {
+	if perms != nil {
+		return perms
+	}
+	perms = map[string]*scidPermissions{}
+
+	path, err := dataPath("permissions.json")
+	if err != nil {
+		return perms
+	}
+	if b, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(b, &perms); err != nil {
+			logNative.Warn("ignoring unreadable permissions file", "path", path, "err", err)
+			perms = map[string]*scidPermissions{}
+		}
+	}
+	return perms
+}
+
+// savePermissions writes the store back. Callers must hold permsMu.
+func savePermissions() error {
+	path, err := dataPath("permissions.json")
+	if err != nil {
+		return err
+	}
+	b, _ := json.MarshalIndent(perms, "", "  ")
+	return os.WriteFile(path, b, 0600)
+}
+
+// permissionsOf returns the effective permissions of scid.
+func permissionsOf(scid string) scidPermissions {
+	permsMu.Lock()
+	defer permsMu.Unlock()
+	p := defaultPermissions()
+	if set, ok := loadPermissions()[scid]; ok {
+		if set.WalletRead != "" {
+			p.WalletRead = set.WalletRead
+		}
+		if set.WalletSign != "" {
+			p.WalletSign = set.WalletSign
+		}
+		if set.ExternalFetch != "" {
+			p.ExternalFetch = set.ExternalFetch
+		}
+		p.StorageQuota = set.StorageQuota
+	}
+	return p
+}
+
+// permission returns allow, deny or ask for one capability of scid. Imports
+// that could not be tied to their SCID may not use any capability, so they
+// cannot prompt for the wallet under a name they made up.
+func permission(scid, capability string) string {
+	if isImportKey(scid) {
+		return permDeny
+	}
+	p := permissionsOf(scid)
+	switch capability {
+	case capWalletRead:
+		return p.WalletRead
+	case capWalletSign:
+		return p.WalletSign
+	case capExternalFetch:
+		return p.ExternalFetch
+	}
+	return permDeny
+}
+
+func getPermissions(scid string) map[string]any {
+	if scid != "" {
+		return map[string]any{"scid": scid, "permissions": permissionsOf(scid)}
+	}
+	permsMu.Lock()
+	defer permsMu.Unlock()
+	all := map[string]scidPermissions{}
+	for s, p := range loadPermissions() {
+		all[s] = *p
+	}
+	return map[string]any{"defaults": defaultPermissions(), "scids": all}
+}
+
+// setPermissions updates the capabilities of scid present in changes;
+// reset drops the SCID back to the defaults.
+func setPermissions(scid string, changes map[string]any, reset bool) (scidPermissions, error) {
+	if scid == "" {
+		return scidPermissions{}, fmt.Errorf("scid required")
+	}
+	if isImportKey(scid) {
+		return scidPermissions{}, fmt.Errorf("%s is an unchecked import and cannot be granted permissions", scid)
+	}
+
+	permsMu.Lock()
+	all := loadPermissions()
+	if reset {
+		delete(all, scid)
+	} else {
+		p, ok := all[scid]
+		if !ok {
+			d := defaultPermissions()
+			p = &d
+		}
+		updated := *p
+		for _, capability := range []string{capWalletRead, capWalletSign, capExternalFetch} {
+			v, ok := changes[capability].(string)
+			if !ok {
+				continue
+			}
+			if v != permAllow && v != permDeny && v != permAsk {
+				permsMu.Unlock()
+				return scidPermissions{}, fmt.Errorf("%s must be allow, deny or ask", capability)
+			}
+			switch capability {
+			case capWalletRead:
+				updated.WalletRead = v
+			case capWalletSign:
+				updated.WalletSign = v
+			case capExternalFetch:
+				updated.ExternalFetch = v
+			}
+		}
+		if q, ok := changes["storageQuota"].(float64); ok {
+			if q < 0 {
+				permsMu.Unlock()
+				return scidPermissions{}, fmt.Errorf("storageQuota must not be negative")
+			}
+			updated.StorageQuota = int64(q)
+		}
+		all[scid] = &updated
+	}
+	err := savePermissions()
+	permsMu.Unlock()
+	if err != nil {
+		return scidPermissions{}, err
+	}
+	return permissionsOf(scid), nil
+}
+
+// checkPermission decides a request against the store, asking the user when
+// the SCID is set to ask. Remembered answers are saved.
+func checkPermission(scid, app, capability, method string, params json.RawMessage) bool {
+	switch permission(scid, capability) {
+	case permAllow:
+		return true
+	case permAsk:
+		allow, remember := askUser(scid, app, capability, method, params)
+		if remember {
+			v := permDeny
+			if allow {
+				v = permAllow
+			}
+			if _, err := setPermissions(scid, map[string]any{capability: v}, false); err != nil {
+				logNative.Error("saving permission", "scid", scid, "err", err)
+			}
+		}
+		return allow
+	}
+	return false
+}
+
+// contentSecurityPolicy limits what a page may load. Pages that may not
+// fetch external resources are kept to files and bridges on their own origin. A
+// policy cannot ask, so "ask" pages can only reach out through the fetch
+// bridge, which does.
+func contentSecurityPolicy(scid string) string {
+	if permission(scid, capExternalFetch) == permAllow {
+		return "frame-ancestors 'self'"
+	}
+	self := "ws://" + appHost(scid)
+	return "default-src 'self' 'unsafe-inline' 'unsafe-eval' data: blob:; connect-src 'self' " + self + "; frame-ancestors 'self'"
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// withPermissions gives one test an empty permissions store.
+func withPermissions(t *testing.T) {
+	t.Helper()
+	withDataDirs(t)
+	permsMu.Lock()
+	prev := perms
+	perms = nil
+	permsMu.Unlock()
+	t.Cleanup(func() {
+		permsMu.Lock()
+		perms = prev
+		permsMu.Unlock()
+	})
+}
+
+func TestWalletCapability(t *testing.T) {
+	tests := []struct {
+		method string
+		want   string
+	}{
+		{"GetBalance", capWalletRead},
+		{"DERO.GetAddress", capWalletRead},
+		{"transfer", capWalletSign},
+		{"SignData", capWalletSign},
+		{"SomethingNew", capWalletSign},
+	}
+	for _, tt := range tests {
+		if got := walletCapability(tt.method); got != tt.want {
+			t.Errorf("walletCapability(%q) = %s, want %s", tt.method, got, tt.want)
+		}
+	}
+}
+
+func TestSetPermissions(t *testing.T) {
+	withPermissions(t)
+
+	tests := []struct {
+		name    string
+		changes map[string]any
+		reset   bool
+		want    scidPermissions
+		wantErr bool
+	}{
+		{"defaults", map[string]any{}, false, defaultPermissions(), false},
+		{"allow fetch", map[string]any{capExternalFetch: permAllow}, false,
+			scidPermissions{permAsk, permAsk, permAllow, defaultStorageQuota}, false},
+		{"deny signing, keep fetch", map[string]any{capWalletSign: permDeny, "storageQuota": float64(0)}, false,
+			scidPermissions{permAsk, permDeny, permAllow, 0}, false},
+		{"bad value", map[string]any{capWalletRead: "always"}, false, scidPermissions{}, true},
+		{"negative quota", map[string]any{"storageQuota": float64(-1)}, false, scidPermissions{}, true},
+		{"reset", nil, true, defaultPermissions(), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := setPermissions("s1", tt.changes, tt.reset)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	// Saved permissions survive a reload and stay per SCID
+	setPermissions("s1", map[string]any{capWalletRead: permAllow}, false)
+	permsMu.Lock()
+	perms = nil
+	permsMu.Unlock()
+	if permission("s1", capWalletRead) != permAllow {
+		t.Error("permission not saved")
+	}
+	if permission("s2", capWalletRead) != permAsk {
+		t.Error("permission leaked to another SCID")
+	}
+	if permission("s1", "unknown") != permDeny {
+		t.Error("unknown capability not denied")
+	}
+}
+
+func TestCheckPermissionRemembers(t *testing.T) {
+	withPermissions(t)
+	withTestCache(t)
+
+	answer := func(allow, remember bool) {
+		for {
+			if list := pendingPrompts(); len(list) > 0 {
+				answerPrompt(list[0]["prompt"].(string), allow, remember)
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	go answer(false, false)
+	if checkPermission("s1", "app", capWalletSign, "transfer", nil) {
+		t.Fatal("denied prompt allowed")
+	}
+	if permission("s1", capWalletSign) != permAsk {
+		t.Fatal("answer remembered without being asked to")
+	}
+
+	go answer(true, true)
+	if !checkPermission("s1", "app", capWalletSign, "transfer", nil) {
+		t.Fatal("allowed prompt denied")
+	}
+	// Remembered, so no prompt this time
+	if !checkPermission("s1", "app", capWalletSign, "transfer", nil) {
+		t.Fatal("remembered answer not used")
+	}
+	if permission("s2", capWalletSign) != permAsk {
+		t.Error("answer for s1 applied to s2")
+	}
+}
+
+func TestContentSecurityPolicy(t *testing.T) {
+	withPermissions(t)
+	setPermissions("open", map[string]any{capExternalFetch: permAllow}, false)
+	setPermissions("closed", map[string]any{capExternalFetch: permDeny}, false)
+
+	if csp := contentSecurityPolicy("open"); strings.Contains(csp, "connect-src") {
+		t.Errorf("fetching page limited: %s", csp)
+	}
+	for _, scid := range []string{"closed", "asking"} {
+		csp := contentSecurityPolicy(scid)
+		if !strings.Contains(csp, "connect-src 'self' ws://"+appHost(scid)+";") {
+			t.Errorf("%s: connect-src not limited to its own origin: %s", scid, csp)
+		}
+		if strings.Contains(csp, "127.0.0.1") {
+			t.Errorf("%s: shared proxy address allowed: %s", scid, csp)
+		}
+	}
+}
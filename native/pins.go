@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// pin records the INDEX version a SCID was last loaded at, giving diff_scid
+// a baseline to compare the latest on-chain version against.
+type pin struct {
+	Height   int64     `json:"height"`
+	DURL     string    `json:"dURL"`
+	DOCs     []string  `json:"docs"`
+	LoadedAt time.Time `json:"loadedAt"`
+}
+
+var (
+	pinsMu sync.Mutex
+	pins   map[string]pin
+)
+
+// loadPins reads pins.json on first use. Callers must hold pinsMu.
+func loadPins() {
+	if pins != nil {
+		return
+	}
+	pins = map[string]pin{}
+
+	path, err := dataPath("pins.json")
+	if err != nil {
+		return
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	if err := json.Unmarshal(b, &pins); err != nil {
+		logNative.Warn("ignoring unreadable pins file", "path", path, "err", err)
+		pins = map[string]pin{}
+	}
+}
+
+func getPin(scid string) (pin, bool) {
+	pinsMu.Lock()
+	defer pinsMu.Unlock()
+	loadPins()
+	p, ok := pins[scid]
+	return p, ok
+}
+
+func setPin(scid string, p pin) {
+	pinsMu.Lock()
+	defer pinsMu.Unlock()
+	loadPins()
+	pins[scid] = p
+
+	path, err := dataPath("pins.json")
+	if err != nil {
+		logNative.Error("pins save failed", "err", err)
+		return
+	}
+	b, _ := json.MarshalIndent(pins, "", "  ")
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		logNative.Error("pins save failed", "err", err)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/civilware/tela"
+)
+
+// withPins gives one test an empty pin store in its data directory.
+func withPins(t *testing.T) {
+	t.Helper()
+	pinsMu.Lock()
+	prev := pins
+	pins = nil
+	pinsMu.Unlock()
+	t.Cleanup(func() {
+		pinsMu.Lock()
+		pins = prev
+		pinsMu.Unlock()
+	})
+}
+
+func TestPinsPersist(t *testing.T) {
+	withDataDirs(t)
+	withPins(t)
+
+	scid := strings.Repeat("a1", 32)
+	want := pin{Height: 42, DURL: "app.tela", DOCs: []string{"d1", "d2"}, LoadedAt: time.Unix(1700000000, 0).UTC()}
+	setPin(scid, want)
+
+	// Drop the in-memory copy so the next read comes from pins.json
+	pinsMu.Lock()
+	pins = nil
+	pinsMu.Unlock()
+
+	got, ok := getPin(scid)
+	if !ok {
+		t.Fatal("pin was not saved")
+	}
+	if got.Height != want.Height || got.DURL != want.DURL || !slices.Equal(got.DOCs, want.DOCs) || !got.LoadedAt.Equal(want.LoadedAt) {
+		t.Errorf("pin = %+v, want %+v", got, want)
+	}
+	if _, ok := getPin(strings.Repeat("b2", 32)); ok {
+		t.Error("unknown SCID has a pin")
+	}
+}
+
+func TestLoadSCIDOffline(t *testing.T) {
+	withDataDirs(t)
+	withTestCache(t)
+	withAuthors(t, &authorList{
+		Trusted:   map[string]string{},
+		Blocked:   map[string]string{},
+		OnBlocked: actionRefuse,
+		OnUnknown: actionAllow,
+	})
+	withNode(t, "")
+
+	signer := newTestSigner()
+	scid := strings.Repeat("a1", 32)
+	cacheTestApp(t, scid, signer.address, []tela.DOC{
+		signer.doc(strings.Repeat("d1", 32), "index.html", "<html>hi</html>\n"),
+	})
+
+	t.Run("cached", func(t *testing.T) {
+		unloadAfter(t, scid)
+		_, extra, err := loadSCID(scid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if extra["stale"] != true {
+			t.Errorf("stale = %v, want true", extra["stale"])
+		}
+		if !slices.Contains(staleSCIDs(), scid) {
+			t.Errorf("staleSCIDs() = %v, want it to list %s", staleSCIDs(), scid)
+		}
+	})
+
+	t.Run("not cached", func(t *testing.T) {
+		other := strings.Repeat("b2", 32)
+		unloadAfter(t, other)
+		if _, _, err := loadSCID(other); err == nil {
+			t.Fatal("loaded an uncached SCID without a node")
+		}
+		if slices.Contains(staleSCIDs(), other) {
+			t.Error("failed load is listed as stale")
+		}
+	})
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// The TELA proxy and the Gnomon API listen on loopback. When the configured
+// port is taken, the next few ports are tried and then any free port; the
+// port actually bound is what URLs, probes and the hello reply use.
+
+const portFallbacks = 10
+
+// boundPort records where a server asked to listen and where it did.
+type boundPort struct {
+	Requested int    `json:"requested"`
+	Port      int    `json:"port"` // 0 until bound
+	URL       string `json:"url,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+var (
+	portsMu     sync.Mutex
+	telaBound   boundPort
+	gnomonBound boundPort
+)
+
+// listenLocal binds 127.0.0.1:port, falling back to the following ports and
+// then to a port picked by the OS.
+func listenLocal(name string, port int) (net.Listener, error) {
+	var first error
+	for p := port; p < port+portFallbacks && p <= 65535; p++ {
+		ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", p))
+		if err == nil {
+			if p != port {
+				logNative.Warn("port taken, using fallback", "server", name, "requested", port, "port", p, "err", first)
+			}
+			return ln, nil
+		}
+		if first == nil {
+			first = err
+		}
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("%s: no free port (%v)", name, first)
+	}
+	logNative.Warn("port range taken, using any free port", "server", name, "requested", port, "port", ln.Addr().(*net.TCPAddr).Port)
+	return ln, nil
+}
+
+func recordBound(b *boundPort, requested int, ln net.Listener, path string, err error) {
+	portsMu.Lock()
+	defer portsMu.Unlock()
+	*b = boundPort{Requested: requested}
+	if err != nil {
+		b.Error = err.Error()
+		return
+	}
+	b.Port = ln.Addr().(*net.TCPAddr).Port
+	b.URL = "http://" + ln.Addr().String() + path
+}
+
+// telaPortInUse is the port of the TELA proxy, or the configured one until
+// the proxy has started.
+func telaPortInUse() int {
+	portsMu.Lock()
+	defer portsMu.Unlock()
+	if telaBound.Port != 0 {
+		return telaBound.Port
+	}
+	return settingInt("telaPort")
+}
+
+func gnomonPortInUse() int {
+	portsMu.Lock()
+	defer portsMu.Unlock()
+	if gnomonBound.Port != 0 {
+		return gnomonBound.Port
+	}
+	return settingInt("gnomonPort")
+}
+
+// telaOrigin is the proxy's own address, for the host's requests to it.
+// Apps are served from origins of their own, see appOrigin.
+func telaOrigin() string {
+	return fmt.Sprintf("http://127.0.0.1:%d", telaPortInUse())
+}
+
+func gnomonAPIBase() string {
+	return fmt.Sprintf("http://127.0.0.1:%d/api", gnomonPortInUse())
+}
+
+// gnomonAnswers reports whether the Gnomon API answers at base. Another
+// service may have taken the port in the moment between finding it free and
+// the API binding it, so the reply must be a Gnomon getinfo.
+func gnomonAnswers(base string) bool {
+	client := http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Get(base + "/getinfo")
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	var reply map[string]json.RawMessage
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&reply) != nil {
+		return false
+	}
+	_, ok := reply["getinfo"]
+	return ok
+}
+
+// waitForGnomonAPI checks that the Gnomon API answers on the port it was
+// given. The API server binds it itself and does not report failures. When
+// it does not answer, its URL is withdrawn so clients do not query whatever
+// holds the port instead.
+func waitForGnomonAPI() {
+	for i := 0; i < 40; i++ {
+		if gnomonAnswers(gnomonAPIBase()) {
+			return
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+	portsMu.Lock()
+	gnomonBound.Error = "Gnomon API is not answering"
+	gnomonBound.URL = ""
+	portsMu.Unlock()
+	logGnomon.Error("Gnomon API is not answering", "port", gnomonPortInUse())
+}
+
+// listenAddrs reports the servers' addresses for hello and server_status.
+func listenAddrs() map[string]boundPort {
+	portsMu.Lock()
+	defer portsMu.Unlock()
+	return map[string]boundPort{"tela": telaBound, "gnomon": gnomonBound}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// withGnomonBound points the Gnomon API address at srv for one test.
+func withGnomonBound(t *testing.T, srv *httptest.Server) {
+	t.Helper()
+	portsMu.Lock()
+	prev := gnomonBound
+	gnomonBound = boundPort{
+		Port: srv.Listener.Addr().(*net.TCPAddr).Port,
+		URL:  srv.URL + "/api",
+	}
+	portsMu.Unlock()
+	t.Cleanup(func() {
+		portsMu.Lock()
+		gnomonBound = prev
+		portsMu.Unlock()
+	})
+}
+
+func TestListenLocalFallback(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	port := taken.Addr().(*net.TCPAddr).Port
+
+	ln, err := listenLocal("test", port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	got := ln.Addr().(*net.TCPAddr).Port
+	if got == port {
+		t.Fatalf("bound the taken port %d", port)
+	}
+
+	var b boundPort
+	recordBound(&b, port, ln, "/api", nil)
+	if b.Requested != port || b.Port != got || b.URL != fmt.Sprintf("http://127.0.0.1:%d/api", got) {
+		t.Errorf("recorded %+v", b)
+	}
+	recordBound(&b, port, nil, "/api", fmt.Errorf("no free port"))
+	if b.Port != 0 || b.URL != "" || b.Error == "" {
+		t.Errorf("recorded failure as %+v", b)
+	}
+}
+
+func TestGnomonAnswers(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		code int
+		want bool
+	}{
+		{"gnomon", `{"getinfo":{"height":5}}`, http.StatusOK, true},
+		{"gnomon before first info", `{"getinfo":null}`, http.StatusOK, true},
+		{"other JSON service", `{"status":"ok"}`, http.StatusOK, false},
+		{"other web server", `<html></html>`, http.StatusOK, false},
+		{"error", `{"getinfo":{}}`, http.StatusInternalServerError, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/api/getinfo" {
+					http.NotFound(w, r)
+					return
+				}
+				w.WriteHeader(tt.code)
+				fmt.Fprint(w, tt.body)
+			}))
+			defer srv.Close()
+			if got := gnomonAnswers(srv.URL + "/api"); got != tt.want {
+				t.Errorf("gnomonAnswers = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWaitForGnomonAPIClearsURL(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for the API timeout")
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "not gnomon")
+	}))
+	defer srv.Close()
+	withGnomonBound(t, srv)
+
+	waitForGnomonAPI()
+	got := listenAddrs()["gnomon"]
+	if got.URL != "" || got.Error == "" {
+		t.Errorf("after a wrong service answered: %+v", got)
+	}
+}
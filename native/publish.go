@@ -0,0 +1,205 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/civilware/tela"
+	"github.com/deroproject/derohe/rpc"
+)
+
+// installRingsize is the ring size of contract installs; DERO requires
+// installs to be sent with a ring of 2.
+const installRingsize = 2
+
+// installMu allows a single install at a time, since every transaction of
+// an install goes through the same wallet.
+var installMu sync.Mutex
+
+// installRequest describes the INDEX to publish for a folder.
+type installRequest struct {
+	Folder      string
+	DURL        string
+	Name        string
+	Description string
+	Icon        string
+}
+
+// plannedDOC is one DOC contract of an install.
+type plannedDOC struct {
+	path string
+	doc  tela.DOC
+}
+
+// planDOCs splits the files of a validated folder into the DOCs to install,
+// entrypoint first so it becomes DOC1. Files that validate_folder decided to
+// compress or shard are compressed and named the way detectShard reads them.
+func planDOCs(folder, entry, durl string, files []validatedFile) ([]plannedDOC, error) {
+	sort.SliceStable(files, func(i, j int) bool { return files[i].Path == entry && files[j].Path != entry })
+
+	var planned []plannedDOC
+	for _, f := range files {
+		data, err := os.ReadFile(filepath.Join(folder, filepath.FromSlash(f.Path)))
+		if err != nil {
+			return nil, err
+		}
+		code := string(data)
+		if f.Compression != "" {
+			if code, err = tela.Compress(data, f.Compression); err != nil {
+				return nil, fmt.Errorf("%s: %w", f.Path, err)
+			}
+		}
+
+		subDir, base := path.Split(f.Path)
+		subDir = strings.TrimSuffix(subDir, "/")
+
+		for i := 0; i < f.DOCs; i++ {
+			chunk := code
+			name := base + f.Compression
+			if f.DOCs > 1 {
+				end := min((i+1)*int(tela.SHARD_SIZE), len(code))
+				chunk = code[i*int(tela.SHARD_SIZE) : end]
+				name = fmt.Sprintf("%s-%d%s", base, i+1, f.Compression)
+			}
+			planned = append(planned, plannedDOC{
+				path: f.Path,
+				doc: tela.DOC{
+					DocType:     f.DocType,
+					Code:        chunk,
+					SubDir:      subDir,
+					DURL:        durl,
+					Compression: f.Compression,
+					Headers:     tela.Headers{NameHdr: name},
+				},
+			})
+		}
+	}
+	return planned, nil
+}
+
+// installTELA publishes a local folder as DOC contracts and an INDEX through
+// the connected wallet, sending an install_progress event for every
+// transaction. DOCs are signed when the wallet can sign data. Returns once
+// the INDEX is mined.
+func installTELA(req installRequest) (map[string]any, error) {
+	if !installMu.TryLock() {
+		return nil, fmt.Errorf("an install is already running")
+	}
+	defer installMu.Unlock()
+
+	if _, err := currentWallet(); err != nil {
+		return nil, err
+	}
+	if currentNode() == "" {
+		return nil, fmt.Errorf("node not set")
+	}
+	if strings.TrimSpace(req.DURL) == "" || strings.TrimSpace(req.Name) == "" {
+		return nil, fmt.Errorf("dURL and name are required")
+	}
+
+	report, err := validateFolder(req.Folder)
+	if err != nil {
+		return nil, err
+	}
+	if report["ok"] != true {
+		return nil, fmt.Errorf("folder does not validate: %s", strings.Join(report["errors"].([]string), "; "))
+	}
+	folder := report["folder"].(string)
+
+	durl := req.DURL
+	if report["needsShards"] == true && !strings.HasSuffix(durl, tela.TAG_DOC_SHARDS) {
+		durl += tela.TAG_DOC_SHARDS
+	}
+
+	planned, err := planDOCs(folder, report["entry"].(string), durl, report["files"].([]validatedFile))
+	if err != nil {
+		return nil, err
+	}
+
+	progress := func(stage string, fields map[string]any) {
+		ev := map[string]any{"event": "install_progress", "folder": folder, "stage": stage, "total": len(planned)}
+		for k, v := range fields {
+			ev[k] = v
+		}
+		sendMsg(ev)
+	}
+
+	warnings := []string{}
+	docSCIDs := make([]string, 0, len(planned))
+	for i, p := range planned {
+		doc := p.doc
+		c, s, err := walletSign([]byte(doc.Code))
+		switch {
+		case errors.Is(err, errSigningUnsupported):
+			if len(warnings) == 0 {
+				warnings = append(warnings, "DOCs installed unsigned: "+err.Error())
+			}
+		case err != nil:
+			return nil, fmt.Errorf("signing %s: %w", doc.NameHdr, err)
+		default:
+			doc.CheckC, doc.CheckS = c, s
+		}
+
+		args, err := tela.NewInstallArgs(&doc)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", doc.NameHdr, err)
+		}
+		txid, err := walletTransfer(rpc.Transfer_Params{SC_RPC: args, Ringsize: installRingsize})
+		if err != nil {
+			return nil, fmt.Errorf("installing %s: %w", doc.NameHdr, err)
+		}
+
+		logNative.Info("DOC installed", "doc", i+1, "name", doc.NameHdr, "txid", txid)
+		progress("doc", map[string]any{"index": i + 1, "path": p.path, "name": doc.NameHdr, "txid": txid})
+		docSCIDs = append(docSCIDs, txid)
+	}
+
+	// The INDEX is only installed once every DOC it lists is on chain
+	for i, txid := range docSCIDs {
+		height, err := waitForTx(txid)
+		if err != nil {
+			return nil, err
+		}
+		progress("confirmed", map[string]any{"index": i + 1, "txid": txid, "height": height})
+	}
+
+	index := tela.INDEX{
+		DURL: durl,
+		DOCs: docSCIDs,
+		Headers: tela.Headers{
+			NameHdr:  req.Name,
+			DescrHdr: req.Description,
+			IconHdr:  req.Icon,
+		},
+	}
+	args, err := tela.NewInstallArgs(&index)
+	if err != nil {
+		return nil, fmt.Errorf("INDEX: %w", err)
+	}
+	scid, err := walletTransfer(rpc.Transfer_Params{SC_RPC: args, Ringsize: installRingsize})
+	if err != nil {
+		return nil, fmt.Errorf("installing INDEX: %w", err)
+	}
+	progress("index", map[string]any{"txid": scid})
+
+	height, err := waitForTx(scid)
+	if err != nil {
+		return nil, err
+	}
+	progress("done", map[string]any{"txid": scid, "height": height})
+	logNative.Info("INDEX installed", "scid", scid, "dURL", durl, "docs", len(docSCIDs), "height", height)
+
+	return map[string]any{
+		"scid":     scid,
+		"dURL":     durl,
+		"docs":     docSCIDs,
+		"height":   height,
+		"warnings": warnings,
+	}, nil
+}
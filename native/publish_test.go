@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/civilware/tela"
+	"github.com/deroproject/derohe/rpc"
+)
+
+func TestPlanDOCs(t *testing.T) {
+	big := noise(2*int(tela.SHARD_SIZE) + 100)
+	zipped, _ := tela.Compress(big, tela.COMPRESSION_GZIP)
+	zippedDOCs := (len(zipped) + int(tela.SHARD_SIZE) - 1) / int(tela.SHARD_SIZE)
+	var zippedNames, zippedPaths []string
+	for i := 1; i <= zippedDOCs; i++ {
+		zippedNames = append(zippedNames, fmt.Sprintf("app.js-%d.gz", i))
+		zippedPaths = append(zippedPaths, "app.js")
+	}
+	text := []byte(strings.Repeat("body { margin: 0 }\n", 2000))
+	tests := []struct {
+		name  string
+		files map[string][]byte
+		plan  []validatedFile // as validate_folder would plan it if nil
+		entry string
+		names []string // NameHdr of each DOC, in order
+		paths []string
+	}{
+		{
+			name:  "entrypoint first",
+			files: map[string][]byte{"a.js": []byte("1"), "b/index.html": []byte("<p>"), "c.css": []byte("p{}")},
+			entry: "b/index.html",
+			names: []string{"index.html", "a.js", "c.css"},
+			paths: []string{"b/index.html", "a.js", "c.css"},
+		},
+		{
+			name:  "compressed",
+			files: map[string][]byte{"index.html": []byte("<p>"), "css/site.css": text},
+			entry: "index.html",
+			names: []string{"index.html", "site.css.gz"},
+			paths: []string{"index.html", "css/site.css"},
+		},
+		{
+			name:  "sharded",
+			files: map[string][]byte{"index.html": []byte("<p>"), "app.js": big},
+			entry: "index.html",
+			names: []string{"index.html", "app.js-1", "app.js-2", "app.js-3"},
+			paths: []string{"index.html", "app.js", "app.js", "app.js"},
+		},
+		{
+			name:  "compressed and sharded",
+			files: map[string][]byte{"app.js": big},
+			plan:  []validatedFile{{Path: "app.js", DocType: "TELA-JS-1", Compression: tela.COMPRESSION_GZIP, DOCs: zippedDOCs}},
+			entry: "app.js",
+			names: zippedNames,
+			paths: zippedPaths,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			folder := t.TempDir()
+			for name, data := range tt.files {
+				p := filepath.Join(folder, filepath.FromSlash(name))
+				os.MkdirAll(filepath.Dir(p), 0755)
+				if err := os.WriteFile(p, data, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			files := tt.plan
+			if files == nil {
+				res, err := validateFolder(folder)
+				if err != nil {
+					t.Fatal(err)
+				}
+				files = res["files"].([]validatedFile)
+			}
+
+			planned, err := planDOCs(folder, tt.entry, "app.tela", files)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names, paths []string
+			code := map[string]string{}
+			for _, p := range planned {
+				names = append(names, p.doc.NameHdr)
+				paths = append(paths, p.path)
+				if p.doc.DURL != "app.tela" || p.doc.DocType == "" {
+					t.Errorf("%s: dURL %q docType %q", p.doc.NameHdr, p.doc.DURL, p.doc.DocType)
+				}
+				if want := strings.TrimSuffix(path.Dir(p.path), "."); p.doc.SubDir != want {
+					t.Errorf("%s: subDir %q, want %q", p.doc.NameHdr, p.doc.SubDir, want)
+				}
+				if len(p.doc.Code) > int(tela.SHARD_SIZE) {
+					t.Errorf("%s: shard of %d bytes", p.doc.NameHdr, len(p.doc.Code))
+				}
+				// Shards must read back as the file they were cut from
+				if idx, base := detectShard(p.doc.NameHdr, p.doc.Compression); idx > 0 && base != filepath.Base(p.path) {
+					t.Errorf("%s reads as shard %d of %q", p.doc.NameHdr, idx, base)
+				}
+				code[p.path] += p.doc.Code
+			}
+			if !reflect.DeepEqual(names, tt.names) || !reflect.DeepEqual(paths, tt.paths) {
+				t.Errorf("planned %v %v, want %v %v", names, paths, tt.names, tt.paths)
+			}
+			for _, f := range files {
+				got := []byte(code[f.Path])
+				if f.Compression != "" {
+					if got, err = tela.Decompress(got, f.Compression); err != nil {
+						t.Fatalf("%s: %v", f.Path, err)
+					}
+				}
+				if string(got) != string(tt.files[f.Path]) {
+					t.Errorf("%s: DOCs do not join back into the file", f.Path)
+				}
+			}
+		})
+	}
+
+	if _, err := planDOCs(t.TempDir(), "index.html", "app.tela", []validatedFile{{Path: "index.html", DOCs: 1}}); err == nil {
+		t.Error("planned a file that does not exist")
+	}
+}
+
+// fakeWallet records the calls made to it and fails the failAt'th call to
+// the method fail. Only an XSWD wallet can sign.
+type fakeWallet struct {
+	xswd   bool
+	fail   string
+	failAt int
+
+	mu    sync.Mutex
+	calls []string
+	sent  []rpc.Transfer_Params
+}
+
+func (w *fakeWallet) call(method string, params, out any) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.calls = append(w.calls, method)
+	n := 0
+	for _, c := range w.calls {
+		if c == method {
+			n++
+		}
+	}
+	if method == w.fail && n == w.failAt {
+		return errors.New("wallet said no")
+	}
+	switch method {
+	case "SignData":
+		sig := pem.EncodeToMemory(&pem.Block{Type: "DERO SIGNED MESSAGE", Headers: map[string]string{"C": "c", "S": "s"}})
+		out.(*struct {
+			Signature []byte `json:"signature"`
+		}).Signature = sig
+	case "transfer":
+		w.sent = append(w.sent, params.(rpc.Transfer_Params))
+		out.(*rpc.Transfer_Result).TXID = fmt.Sprintf("tx%d", n)
+	}
+	return nil
+}
+
+func (w *fakeWallet) mode() string {
+	if w.xswd {
+		return walletModeXSWD
+	}
+	return walletModeRPC
+}
+
+func (w *fakeWallet) close() {}
+
+// withWallet connects w for one test.
+func withWallet(t *testing.T, w walletConn) {
+	t.Helper()
+	walletMu.Lock()
+	prev, prevAddress := wallet, walletAddress
+	wallet, walletAddress = w, "deto1test"
+	walletMu.Unlock()
+	t.Cleanup(func() {
+		walletMu.Lock()
+		wallet, walletAddress = prev, prevAddress
+		walletMu.Unlock()
+	})
+}
+
+func TestInstallTELA(t *testing.T) {
+	withNode(t, fakeDaemon(t, nil))
+	folder := writeTree(t, "app.js", "index.html")
+	req := installRequest{Folder: folder, DURL: "app.tela", Name: "App"}
+
+	const (
+		sign     = "SignData"
+		transfer = "transfer"
+	)
+	tests := []struct {
+		name   string
+		wallet *fakeWallet
+		err    string
+		calls  []string
+		stages []string
+	}{
+		{"signed", &fakeWallet{xswd: true}, "", []string{sign, transfer, sign, transfer, transfer}, []string{"doc", "doc", "confirmed", "confirmed", "index", "done"}},
+		{"wallet cannot sign", &fakeWallet{}, "", []string{transfer, transfer, transfer}, []string{"doc", "doc", "confirmed", "confirmed", "index", "done"}},
+		{"signing fails", &fakeWallet{xswd: true, fail: sign, failAt: 2}, "signing app.js", []string{sign, transfer, sign}, []string{"doc"}},
+		{"DOC transfer fails", &fakeWallet{xswd: true, fail: transfer, failAt: 1}, "installing index.html", []string{sign, transfer}, nil},
+		{"INDEX transfer fails", &fakeWallet{fail: transfer, failAt: 3}, "installing INDEX", []string{transfer, transfer, transfer}, []string{"doc", "doc", "confirmed", "confirmed"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withWallet(t, tt.wallet)
+			client := withClient(t)
+
+			res, err := installTELA(req)
+			if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("got error %v, want %q", err, tt.err)
+			}
+			if !reflect.DeepEqual(tt.wallet.calls, tt.calls) {
+				t.Errorf("wallet calls %v, want %v", tt.wallet.calls, tt.calls)
+			}
+			for _, tx := range tt.wallet.sent {
+				if tx.Ringsize != installRingsize {
+					t.Errorf("sent with ring size %d", tx.Ringsize)
+				}
+			}
+
+			var stages, paths []string
+			for _, ev := range received(t, client) {
+				if ev["event"] == "install_progress" {
+					stages = append(stages, ev["stage"].(string))
+					if ev["stage"] == "doc" {
+						paths = append(paths, ev["path"].(string))
+					}
+				}
+			}
+			if !reflect.DeepEqual(stages, tt.stages) {
+				t.Errorf("progress %v, want %v", stages, tt.stages)
+			}
+			if len(paths) > 0 && paths[0] != "index.html" {
+				t.Errorf("installed %v, want the entrypoint first", paths)
+			}
+			if err != nil {
+				return
+			}
+
+			unsigned := len(res["warnings"].([]string)) > 0
+			if unsigned == tt.wallet.xswd {
+				t.Errorf("warnings %v from a wallet that signs: %v", res["warnings"], tt.wallet.xswd)
+			}
+			if res["scid"] != "tx3" || !reflect.DeepEqual(res["docs"], []string{"tx1", "tx2"}) || res["height"] != int64(fakeTxHeight) {
+				t.Errorf("got %v", res)
+			}
+		})
+	}
+}
+
+func TestInstallTELARequires(t *testing.T) {
+	folder := writeTree(t, "index.html")
+	bad := writeTree(t, "index.html", "Makefile")
+	tests := []struct {
+		name   string
+		wallet bool
+		node   bool
+		req    installRequest
+		err    string
+	}{
+		{"no wallet", false, true, installRequest{Folder: folder, DURL: "a.tela", Name: "A"}, "wallet not connected"},
+		{"no node", true, false, installRequest{Folder: folder, DURL: "a.tela", Name: "A"}, "node not set"},
+		{"no dURL", true, true, installRequest{Folder: folder, Name: "A"}, "required"},
+		{"no name", true, true, installRequest{Folder: folder, DURL: "a.tela", Name: " "}, "required"},
+		{"folder does not validate", true, true, installRequest{Folder: bad, DURL: "a.tela", Name: "A"}, "not supported"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &fakeWallet{xswd: true}
+			if tt.wallet {
+				withWallet(t, w)
+			} else {
+				withWallet(t, nil)
+			}
+			if tt.node {
+				withNode(t, fakeDaemon(t, nil))
+			} else {
+				withNode(t, "")
+			}
+			if _, err := installTELA(tt.req); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %v, want %q", err, tt.err)
+			}
+			if len(w.calls) > 0 {
+				t.Errorf("wallet was called: %v", w.calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/civilware/tela"
+	"github.com/deroproject/derohe/rpc"
+)
+
+// TELA ratings are a single number from 0 to 99: the tens are the category
+// (0 "do not use" to 9 "exceptional") and the units the detail within it.
+// Contracts store them under the rater's address, so a rating has to be sent
+// with a ring of 2 for the address to be known.
+const rateRingsize = 2
+
+// rateSCID submits a rating for scid through the connected wallet and
+// returns the txid. Confirmation is tracked in the background: a
+// rating_confirmed event follows once the transaction is mined and
+// rating_indexed once Gnomon has indexed that block.
+func rateSCID(scid string, category, detail int) (map[string]any, error) {
+	if category < 0 || category > 9 || detail < 0 || detail > 9 {
+		return nil, fmt.Errorf("category and detail must be between 0 and 9")
+	}
+	node := currentNode()
+	if node == "" {
+		return nil, fmt.Errorf("node not set")
+	}
+	if _, err := currentWallet(); err != nil {
+		return nil, err
+	}
+
+	// Contracts keep the first rating of each address
+	address, _ := walletStatus()["address"].(string)
+	vars, err := getSCVariables(node, scid, 0)
+	if err != nil {
+		return nil, err
+	}
+	if prev, ok := vars[address]; ok {
+		return nil, fmt.Errorf("this wallet already rated %s (%s)", scid, prev)
+	}
+
+	rating := uint64(category*10 + detail)
+	args, err := tela.NewRateArgs(scid, rating)
+	if err != nil {
+		return nil, err
+	}
+	txid, err := walletTransfer(rpc.Transfer_Params{SC_ID: scid, SC_RPC: args, Ringsize: rateRingsize})
+	if err != nil {
+		return nil, err
+	}
+	logNative.Info("rating sent", "scid", scid, "rating", rating, "txid", txid)
+
+	go trackRating(scid, txid)
+
+	return map[string]any{"scid": scid, "rating": rating, "txid": txid}, nil
+}
+
+// trackRating waits for a rating transaction to be mined and then for Gnomon
+// to index it, so the extension can refresh the SCID's aggregate.
+func trackRating(scid, txid string) {
+	height, err := waitForTx(txid)
+	if err != nil {
+		logNative.Error("rating failed", "scid", scid, "err", err)
+		sendMsg(map[string]any{"event": "rating_failed", "scid": scid, "txid": txid, "error": err.Error()})
+		return
+	}
+	sendMsg(map[string]any{"event": "rating_confirmed", "scid": scid, "txid": txid, "height": height})
+
+	deadline := time.Now().Add(txWaitTimeout)
+	for time.Now().Before(deadline) {
+		if indexed, err := boltDB.GetLastIndexHeight(); err == nil && indexed >= height {
+			logNative.Info("rating indexed", "scid", scid, "height", indexed)
+			sendMsg(map[string]any{"event": "rating_indexed", "scid": scid, "txid": txid, "height": height})
+			return
+		}
+		time.Sleep(txPollInterval)
+	}
+	logNative.Warn("rating not indexed in time", "scid", scid, "height", height)
+}
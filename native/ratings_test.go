@@ -0,0 +1,123 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/civilware/Gnomon/storage"
+)
+
+// withIndexedHeight gives the test a Gnomon database indexed up to height.
+func withIndexedHeight(t *testing.T, height int64) {
+	t.Helper()
+	db, err := storage.NewBBoltDB(t.TempDir(), "GNOMON.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.StoreLastIndexHeight(height); err != nil {
+		t.Fatal(err)
+	}
+	prev := boltDB
+	boltDB = db
+	t.Cleanup(func() {
+		boltDB = prev
+		db.DB.Close()
+	})
+}
+
+// awaitEvents waits for c to be sent each of events and returns them.
+func awaitEvents(t *testing.T, c *nativeClient, events ...string) map[string]map[string]any {
+	t.Helper()
+	got := map[string]map[string]any{}
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		for _, ev := range received(t, c) {
+			if name, _ := ev["event"].(string); slices.Contains(events, name) {
+				got[name] = ev
+			}
+		}
+		if len(got) == len(events) {
+			return got
+		}
+	}
+	t.Fatalf("got %v, want %v", got, events)
+	return nil
+}
+
+func TestRateSCID(t *testing.T) {
+	const scid = "a5daa9a02a81a762c83f3d4ce4592310140586badb4e988431819f47657559f7"
+	node := fakeDaemon(t, map[string]map[string]string{
+		scid:    {"likes": "3"},
+		"rated": {"deto1test": "45"},
+	})
+
+	tests := []struct {
+		name             string
+		scid             string
+		category, detail int
+		wallet           *fakeWallet
+		err              string
+		rating           uint64
+	}{
+		{"lowest", scid, 0, 0, &fakeWallet{}, "", 0},
+		{"highest", scid, 9, 9, &fakeWallet{}, "", 99},
+		{"category and detail", scid, 4, 7, &fakeWallet{}, "", 47},
+		{"negative category", scid, -1, 0, &fakeWallet{}, "between 0 and 9", 0},
+		{"category too high", scid, 10, 0, &fakeWallet{}, "between 0 and 9", 0},
+		{"negative detail", scid, 0, -1, &fakeWallet{}, "between 0 and 9", 0},
+		{"detail too high", scid, 0, 10, &fakeWallet{}, "between 0 and 9", 0},
+		{"already rated", "rated", 5, 5, &fakeWallet{}, "already rated rated (45)", 0},
+		{"no wallet", scid, 5, 5, nil, "wallet not connected", 0},
+		{"transfer fails", scid, 5, 5, &fakeWallet{fail: "transfer", failAt: 1}, "wallet said no", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withNode(t, node)
+			withIndexedHeight(t, fakeTxHeight)
+			if tt.wallet != nil {
+				withWallet(t, tt.wallet)
+			} else {
+				withWallet(t, nil)
+			}
+			client := withClient(t)
+
+			res, err := rateSCID(tt.scid, tt.category, tt.detail)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				if tt.wallet != nil && len(tt.wallet.sent) > 0 {
+					t.Errorf("sent %v", tt.wallet.sent)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res["rating"] != tt.rating || res["txid"] != "tx1" {
+				t.Errorf("got %v, want rating %d", res, tt.rating)
+			}
+			if len(tt.wallet.sent) != 1 {
+				t.Fatalf("sent %d transfers", len(tt.wallet.sent))
+			}
+			if tx := tt.wallet.sent[0]; tx.SC_ID != tt.scid || tx.Ringsize != rateRingsize {
+				t.Errorf("sent to %q with ring size %d", tx.SC_ID, tx.Ringsize)
+			}
+
+			// The rating is followed until Gnomon has indexed its block
+			got := awaitEvents(t, client, "rating_confirmed", "rating_indexed")
+			for name, ev := range got {
+				if ev["scid"] != tt.scid || ev["txid"] != "tx1" || ev["height"] != float64(fakeTxHeight) {
+					t.Errorf("%s: got %v", name, ev)
+				}
+			}
+		})
+	}
+
+	withWallet(t, &fakeWallet{})
+	withNode(t, "")
+	if _, err := rateSCID(scid, 5, 5); err == nil || !strings.Contains(err.Error(), "node not set") {
+		t.Errorf("rated without a node: %v", err)
+	}
+}
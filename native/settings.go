@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The browser launches the host without arguments, so the options behind
+// the command line flags are also read from settings.json in the data
+// directory. Flags given on the command line still win over the file.
+// The flags only seed the values the host runs with; those are kept here
+// and read through the setting accessors, since some change while running.
+
+// settingFlags maps each setting to the flag it configures.
+var settingFlags = map[string]string{
+	"telaPort":   "tela-port",
+	"gnomonPort": "gnomon-api",
+	"scidRoot":   "scid-root",
+	"cacheMB":    "cache-mb",
+	"metrics":    "metrics",
+}
+
+// restartSettings only take effect on the next start: the TELA proxy and
+// the Gnomon API keep listening on the port they started with.
+var restartSettings = map[string]bool{
+	"telaPort":   true,
+	"gnomonPort": true,
+}
+
+var (
+	settingsMu    sync.Mutex
+	savedSettings map[string]any  // what settings.json holds
+	cliSettings   map[string]bool // settings given as flags
+
+	runningMu sync.RWMutex
+	running   map[string]any // values in effect, by setting
+)
+
+// validateSetting checks a value from settings.json or set_settings and
+// returns it in the type of its flag.
+func validateSetting(key string, v any) (any, error) {
+	switch key {
+	case "telaPort", "gnomonPort":
+		n, ok := v.(float64)
+		if !ok || n != math.Trunc(n) || n < 1 || n > 65535 {
+			return nil, fmt.Errorf("%s must be a port between 1 and 65535", key)
+		}
+		return int(n), nil
+	case "cacheMB":
+		n, ok := v.(float64)
+		if !ok || n != math.Trunc(n) || n < 0 {
+			return nil, fmt.Errorf("cacheMB must be a whole number of MB, 0 for no limit")
+		}
+		return int(n), nil
+	case "metrics":
+		b, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("metrics must be true or false")
+		}
+		return b, nil
+	case "scidRoot":
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("scidRoot must be a path, empty to turn developer mode off")
+		}
+		return strings.TrimSpace(s), nil
+	}
+	return nil, fmt.Errorf("unknown setting %q", key)
+}
+
+// runningSetting returns the value the host currently runs with, which is
+// the flag's until settings are loaded.
+func runningSetting(key string) any {
+	runningMu.RLock()
+	v, ok := running[key]
+	runningMu.RUnlock()
+	if ok {
+		return v
+	}
+	return flag.Lookup(settingFlags[key]).Value.(flag.Getter).Get()
+}
+
+// setRunning changes the value the host runs with.
+func setRunning(key string, v any) {
+	runningMu.Lock()
+	defer runningMu.Unlock()
+	if running == nil {
+		running = map[string]any{}
+	}
+	running[key] = v
+}
+
+func settingInt(key string) int {
+	n, _ := runningSetting(key).(int)
+	return n
+}
+
+func settingString(key string) string {
+	s, _ := runningSetting(key).(string)
+	return s
+}
+
+func settingBool(key string) bool {
+	b, _ := runningSetting(key).(bool)
+	return b
+}
+
+// loadSettings reads settings.json and applies it to every option not given
+// on the command line. Called once, after flag.Parse.
+func loadSettings() {
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+
+	cliSettings = map[string]bool{}
+	for key := range settingFlags {
+		setRunning(key, flag.Lookup(settingFlags[key]).Value.(flag.Getter).Get())
+	}
+	flag.Visit(func(f *flag.Flag) {
+		for key, name := range settingFlags {
+			if name == f.Name {
+				cliSettings[key] = true
+			}
+		}
+	})
+
+	savedSettings = map[string]any{}
+	path, err := dataPath("settings.json")
+	if err != nil {
+		return
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	var raw map[string]any
+	if err := json.Unmarshal(b, &raw); err != nil {
+		logNative.Warn("ignoring unreadable settings file", "path", path, "err", err)
+		return
+	}
+	for key, v := range raw {
+		value, err := validateSetting(key, v)
+		if err != nil {
+			logNative.Warn("ignoring setting", "key", key, "err", err)
+			continue
+		}
+		savedSettings[key] = value
+		if !cliSettings[key] {
+			setRunning(key, value)
+		}
+	}
+}
+
+// saveSettings writes the file back. Callers must hold settingsMu.
+func saveSettings() error {
+	path, err := dataPath("settings.json")
+	if err != nil {
+		return err
+	}
+	b, _ := json.MarshalIndent(savedSettings, "", "  ")
+	return os.WriteFile(path, b, 0600)
+}
+
+// getSettings reports each setting as it will be after a restart, along with
+// the ones still waiting for that restart and the ones pinned by flags.
+func getSettings() map[string]any {
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+
+	values := map[string]any{}
+	pending := []string{}
+	fromFlags := []string{}
+	for key := range settingFlags {
+		running := runningSetting(key)
+		values[key] = running
+		if cliSettings[key] {
+			fromFlags = append(fromFlags, key)
+			continue
+		}
+		if v, ok := savedSettings[key]; ok {
+			values[key] = v
+			if restartSettings[key] && v != running {
+				pending = append(pending, key)
+			}
+		}
+	}
+	needRestart := []string{}
+	for key := range restartSettings {
+		needRestart = append(needRestart, key)
+	}
+	sort.Strings(pending)
+	sort.Strings(fromFlags)
+	sort.Strings(needRestart)
+
+	return map[string]any{
+		"settings":        values,
+		"restartRequired": pending,
+		"restartSettings": needRestart,
+		"commandLine":     fromFlags,
+	}
+}
+
+// setSettings validates and saves changes, applying right away the settings
+// that do not need a restart and are not overridden by a flag.
+func setSettings(changes map[string]any) (map[string]any, error) {
+	settingsMu.Lock()
+
+	next := map[string]any{}
+	for key, v := range savedSettings {
+		next[key] = v
+	}
+	for key, v := range changes {
+		value, err := validateSetting(key, v)
+		if err != nil {
+			settingsMu.Unlock()
+			return nil, err
+		}
+		next[key] = value
+	}
+
+	port := func(key string) any {
+		if v, ok := next[key]; ok && !cliSettings[key] {
+			return v
+		}
+		return runningSetting(key)
+	}
+	if port("telaPort") == port("gnomonPort") {
+		settingsMu.Unlock()
+		return nil, fmt.Errorf("telaPort and gnomonPort must differ")
+	}
+
+	prev := savedSettings
+	savedSettings = next
+	if err := saveSettings(); err != nil {
+		savedSettings = prev
+		settingsMu.Unlock()
+		return nil, err
+	}
+
+	var apply []string
+	for key := range changes {
+		if restartSettings[key] || cliSettings[key] || next[key] == runningSetting(key) {
+			continue
+		}
+		setRunning(key, next[key])
+		apply = append(apply, key)
+	}
+	settingsMu.Unlock()
+
+	for _, key := range apply {
+		logNative.Info("setting applied", "key", key, "value", runningSetting(key))
+		switch key {
+		case "cacheMB":
+			cache.setLimit(int64(settingInt("cacheMB")) << 20)
+		case "scidRoot":
+			initDevMode()
+		case "metrics":
+			if settingBool("metrics") {
+				startTELA()
+			}
+		}
+	}
+	return getSettings(), nil
+}
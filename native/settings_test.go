@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// withSettings loads settings from a settings.json holding content.
+func withSettings(t *testing.T, content string) {
+	t.Helper()
+	withDataDirs(t)
+	if err := os.WriteFile(filepath.Join(dataDirs.data, "settings.json"), []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		runningMu.Lock()
+		running = nil
+		runningMu.Unlock()
+	})
+	loadSettings()
+}
+
+func TestValidateSetting(t *testing.T) {
+	tests := []struct {
+		key   string
+		value any
+		want  any
+	}{
+		{"telaPort", float64(4041), 4041},
+		{"telaPort", float64(0), nil},
+		{"gnomonPort", 80.5, nil},
+		{"cacheMB", float64(0), 0},
+		{"cacheMB", float64(-1), nil},
+		{"metrics", true, true},
+		{"metrics", "yes", nil},
+		{"scidRoot", "  dev  ", "dev"},
+		{"scidRoot", float64(1), nil},
+		{"unknown", true, nil},
+	}
+	for _, tt := range tests {
+		got, err := validateSetting(tt.key, tt.value)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("%s=%v: no error", tt.key, tt.value)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s=%v: got %v, %v, want %v", tt.key, tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestSettingsApplyWithoutFlags(t *testing.T) {
+	withTestCache(t)
+	withSettings(t, `{"cacheMB": 64, "telaPort": 4141}`)
+
+	if got := settingInt("cacheMB"); got != 64 {
+		t.Errorf("cacheMB from file = %d", got)
+	}
+	if got := settingInt("telaPort"); got != 4141 {
+		t.Errorf("telaPort from file = %d", got)
+	}
+
+	if _, err := setSettings(map[string]any{"cacheMB": float64(8), "scidRoot": "missing"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := settingInt("cacheMB"); got != 8 {
+		t.Errorf("cacheMB after set = %d", got)
+	}
+	if got := settingString("scidRoot"); got != "missing" {
+		t.Errorf("scidRoot after set = %q", got)
+	}
+	if cache.limit != 8<<20 {
+		t.Errorf("cache limit = %d", cache.limit)
+	}
+	// The flags keep their command line values
+	if got := flag.Lookup("cache-mb").Value.String(); got != "512" {
+		t.Errorf("cache-mb flag changed to %s", got)
+	}
+
+	// Restart settings are saved but not applied
+	if _, err := setSettings(map[string]any{"telaPort": float64(4242)}); err != nil {
+		t.Fatal(err)
+	}
+	if got := settingInt("telaPort"); got != 4141 {
+		t.Errorf("telaPort applied while running: %d", got)
+	}
+}
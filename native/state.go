@@ -1,5 +1,223 @@
 package main
 
-// currentNode holds the active DERO daemon address (e.g. "http://127.0.0.1:10102").
-// Set by the set_node command, read by TELA and native handlers.
-var currentNode string
\ No newline at end of file
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// -------------------- NODE --------------------
+
+// The DERO daemon the host talks to is shared by every client of a daemon:
+// set_node and disconnect_node change it for all of them, and each change is
+// broadcast as a node_changed event. It is read from request handlers and
+// background loads, so it is only accessed through the functions below.
+var (
+	nodeMu           sync.RWMutex
+	nodeAddr         string // e.g. "http://127.0.0.1:10102", "" when none is set
+	nodeDisconnected bool   // set until the node answers the sync
+	indexerRunning   bool
+)
+
+// currentNode returns the active DERO daemon address.
+func currentNode() string {
+	nodeMu.RLock()
+	defer nodeMu.RUnlock()
+	return nodeAddr
+}
+
+// nodeStatus returns the node, whether it has not answered yet and whether
+// the indexer runs, as one snapshot.
+func nodeStatus() (string, bool, bool) {
+	nodeMu.RLock()
+	defer nodeMu.RUnlock()
+	return nodeAddr, nodeDisconnected, indexerRunning
+}
+
+// setNode makes node the active daemon, not yet answering, and reports
+// whether that changed anything. "" clears it.
+func setNode(node string) bool {
+	nodeMu.Lock()
+	defer nodeMu.Unlock()
+	if node == nodeAddr && node != "" {
+		return false
+	}
+	nodeAddr, nodeDisconnected = node, true
+	return true
+}
+
+// nodeAnswered records that node answered, unless another node was set
+// since.
+func nodeAnswered(node string) {
+	nodeMu.Lock()
+	defer nodeMu.Unlock()
+	if nodeAddr == node {
+		nodeDisconnected = false
+	}
+}
+
+func setIndexerRunning(running bool) {
+	nodeMu.Lock()
+	indexerRunning = running
+	nodeMu.Unlock()
+}
+
+// broadcastNode tells every client which node is now active.
+func broadcastNode() {
+	sendMsg(map[string]any{"event": "node_changed", "node": currentNode()})
+}
+
+// -------------------- DATA DIRECTORIES --------------------
+
+// The host keeps its files in three directories. With --data-dir (or
+// PUREWOLF_DATA_DIR) they all live under that root; otherwise they follow
+// the XDG base directories on Linux and the platform's user dirs elsewhere.
+//
+//	data:  Gnomon DB, settings, pins, authors, permissions, storage, clones
+//	state: logs
+//	cache: the TELA content cache
+var dataDirs struct {
+	data, state, cache string
+}
+
+// legacyDir is where releases before the configurable data root kept
+// everything. The installer also puts the binary there, so it stays.
+const legacyDir = ".purewolf"
+
+// legacyEntries are the files a legacy install may hold and the directory
+// each moves to.
+var legacyEntries = map[string]string{
+	"gnomondb":         "data",
+	"settings.json":    "data",
+	"pins.json":        "data",
+	"authors.json":     "data",
+	"permissions.json": "data",
+	"storage":          "data",
+	"imports":          "data",
+	"exports":          "data",
+	"scids":            "data",
+	"cache":            "cache",
+}
+
+// xdgDir returns $env, or home/fallback when it is unset or not absolute as
+// the XDG spec requires.
+func xdgDir(env, home, fallback string) string {
+	if dir := os.Getenv(env); filepath.IsAbs(dir) {
+		return dir
+	}
+	return filepath.Join(home, fallback)
+}
+
+// initDataDirs resolves and creates the data, state and cache directories.
+func initDataDirs(root string) error {
+	if root == "" {
+		root = os.Getenv("PUREWOLF_DATA_DIR")
+	}
+	if root != "" {
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			return err
+		}
+		dataDirs.data = abs
+		dataDirs.state = filepath.Join(abs, "logs")
+		dataDirs.cache = filepath.Join(abs, "cache")
+	} else {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return fmt.Errorf("could not find home dir: %w", err)
+		}
+		switch runtime.GOOS {
+		case "linux", "freebsd", "openbsd", "netbsd":
+			dataDirs.data = filepath.Join(xdgDir("XDG_DATA_HOME", home, ".local/share"), "purewolf")
+			dataDirs.state = filepath.Join(xdgDir("XDG_STATE_HOME", home, ".local/state"), "purewolf")
+			dataDirs.cache = filepath.Join(xdgDir("XDG_CACHE_HOME", home, ".cache"), "purewolf")
+		default:
+			config, err := os.UserConfigDir()
+			if err != nil {
+				return err
+			}
+			cache, err := os.UserCacheDir()
+			if err != nil {
+				return err
+			}
+			dataDirs.data = filepath.Join(config, "PureWolf")
+			dataDirs.state = filepath.Join(config, "PureWolf", "logs")
+			dataDirs.cache = filepath.Join(cache, "PureWolf")
+		}
+	}
+
+	for _, dir := range []string{dataDirs.data, dataDirs.state, dataDirs.cache} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateLegacyDir moves the files of a ~/.purewolf install into the data
+// directories. Entries that already exist at the destination are left where
+// they are, so running it again is harmless.
+func migrateLegacyDir() {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return
+	}
+	legacy := filepath.Join(home, legacyDir)
+	if legacy == dataDirs.data {
+		return
+	}
+
+	moved := []string{}
+	for name, kind := range legacyEntries {
+		src := filepath.Join(legacy, name)
+		if _, err := os.Stat(src); err != nil {
+			continue
+		}
+		dst := filepath.Join(dataDirs.data, name)
+		if kind == "cache" {
+			dst = dataDirs.cache
+			if entries, _ := os.ReadDir(dst); len(entries) > 0 {
+				continue
+			}
+			os.Remove(dst)
+		}
+		if _, err := os.Stat(dst); err == nil {
+			logNative.Warn("not migrating, destination exists", "src", src, "dst", dst)
+			continue
+		}
+		if err := os.Rename(src, dst); err != nil {
+			logNative.Error("could not migrate", "src", src, "err", err)
+			if kind == "cache" {
+				os.MkdirAll(dst, 0700)
+			}
+			continue
+		}
+		moved = append(moved, name)
+	}
+	if len(moved) == 0 {
+		return
+	}
+
+	logNative.Info("migrated legacy data", "entries", strings.Join(moved, ", "), "from", legacy, "to", dataDirs.data)
+	note := fmt.Sprintf("PureWolf data moved to %s (cache: %s, logs: %s)\n", dataDirs.data, dataDirs.cache, dataDirs.state)
+	os.WriteFile(filepath.Join(legacy, "MOVED.txt"), []byte(note), 0600)
+}
+
+func joinDir(dir string, elem []string) (string, error) {
+	if dir == "" {
+		return "", fmt.Errorf("data directories not initialised")
+	}
+	return filepath.Join(append([]string{dir}, elem...)...), nil
+}
+
+// dataPath returns a path inside the host's private data directory.
+func dataPath(elem ...string) (string, error) { return joinDir(dataDirs.data, elem) }
+
+// statePath returns a path inside the state directory, where logs go.
+func statePath(elem ...string) (string, error) { return joinDir(dataDirs.state, elem) }
+
+// cachePath returns a path inside the cache directory.
+func cachePath(elem ...string) (string, error) { return joinDir(dataDirs.cache, elem) }
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestInitDataDirs(t *testing.T) {
+	prev := dataDirs
+	t.Cleanup(func() { dataDirs = prev })
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("PUREWOLF_DATA_DIR", "")
+
+	root := filepath.Join(home, "root")
+	tests := []struct {
+		name               string
+		flag               string
+		env                map[string]string
+		data, state, cache string
+		xdgOnly            bool
+	}{
+		{"flag", root, nil, root, filepath.Join(root, "logs"), filepath.Join(root, "cache"), false},
+		{"environment", "", map[string]string{"PUREWOLF_DATA_DIR": root}, root, filepath.Join(root, "logs"), filepath.Join(root, "cache"), false},
+		{"flag over environment", root, map[string]string{"PUREWOLF_DATA_DIR": filepath.Join(home, "other")}, root, filepath.Join(root, "logs"), filepath.Join(root, "cache"), false},
+		{"XDG", "", map[string]string{"XDG_DATA_HOME": filepath.Join(home, "d"), "XDG_STATE_HOME": filepath.Join(home, "s"), "XDG_CACHE_HOME": filepath.Join(home, "c")},
+			filepath.Join(home, "d", "purewolf"), filepath.Join(home, "s", "purewolf"), filepath.Join(home, "c", "purewolf"), true},
+		{"relative XDG ignored", "", map[string]string{"XDG_DATA_HOME": "rel", "XDG_STATE_HOME": "", "XDG_CACHE_HOME": ""},
+			filepath.Join(home, ".local/share/purewolf"), filepath.Join(home, ".local/state/purewolf"), filepath.Join(home, ".cache/purewolf"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.xdgOnly && runtime.GOOS != "linux" {
+				t.Skip("XDG directories are only used on Linux and the BSDs")
+			}
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			if err := initDataDirs(tt.flag); err != nil {
+				t.Fatal(err)
+			}
+			if dataDirs.data != tt.data || dataDirs.state != tt.state || dataDirs.cache != tt.cache {
+				t.Errorf("got %+v", dataDirs)
+			}
+			for _, dir := range []string{dataDirs.data, dataDirs.state, dataDirs.cache} {
+				if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+					t.Errorf("%s not created", dir)
+				}
+			}
+		})
+	}
+}
+
+func TestMigrateLegacyDir(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	withDataDirs(t)
+
+	legacy := filepath.Join(home, legacyDir)
+	os.MkdirAll(filepath.Join(legacy, "gnomondb"), 0700)
+	os.MkdirAll(filepath.Join(legacy, "cache", "docs"), 0700)
+	os.WriteFile(filepath.Join(legacy, "pins.json"), []byte("old"), 0600)
+	os.WriteFile(filepath.Join(legacy, "settings.json"), []byte("old"), 0600)
+	os.WriteFile(filepath.Join(legacy, "purewolf-native"), []byte("binary"), 0700)
+	os.WriteFile(filepath.Join(dataDirs.data, "settings.json"), []byte("new"), 0600)
+
+	migrateLegacyDir()
+
+	for _, moved := range []string{filepath.Join(dataDirs.data, "gnomondb"), filepath.Join(dataDirs.data, "pins.json"), filepath.Join(dataDirs.cache, "docs")} {
+		if _, err := os.Stat(moved); err != nil {
+			t.Errorf("%s not migrated", moved)
+		}
+	}
+	if b, _ := os.ReadFile(filepath.Join(dataDirs.data, "settings.json")); string(b) != "new" {
+		t.Error("existing settings overwritten")
+	}
+	for _, kept := range []string{"settings.json", "purewolf-native", "MOVED.txt"} {
+		if _, err := os.Stat(filepath.Join(legacy, kept)); err != nil {
+			t.Errorf("%s missing from the legacy folder", kept)
+		}
+	}
+
+	// Running again changes nothing
+	migrateLegacyDir()
+	if b, _ := os.ReadFile(filepath.Join(dataDirs.data, "settings.json")); string(b) != "new" {
+		t.Error("second run overwrote settings")
+	}
+}
+
+func TestNodeState(t *testing.T) {
+	withNode(t, "")
+	const a, b = "http://127.0.0.1:10102", "http://127.0.0.1:20000"
+
+	steps := []struct {
+		name         string
+		do           func() bool
+		changed      bool
+		node         string
+		disconnected bool
+	}{
+		{"set", func() bool { return setNode(a) }, true, a, true},
+		{"answered", func() bool { nodeAnswered(a); return false }, false, a, false},
+		{"same node again", func() bool { return setNode(a) }, false, a, false},
+		{"switch", func() bool { return setNode(b) }, true, b, true},
+		{"late answer from the old node", func() bool { nodeAnswered(a); return false }, false, b, true},
+		{"clear", func() bool { return setNode("") }, true, "", true},
+		{"clear again", func() bool { return setNode("") }, true, "", true},
+	}
+	for _, s := range steps {
+		if changed := s.do(); changed != s.changed {
+			t.Errorf("%s: changed = %v, want %v", s.name, changed, s.changed)
+		}
+		if node, disconnected, _ := nodeStatus(); node != s.node || disconnected != s.disconnected {
+			t.Errorf("%s: node = %q disconnected %v, want %q %v", s.name, node, disconnected, s.node, s.disconnected)
+		}
+	}
+}
+
+func TestNodeStateConcurrent(t *testing.T) {
+	withNode(t, "")
+	// Commands switch the node while background loads and handlers read it;
+	// run with -race
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				node := fmt.Sprintf("http://127.0.0.1:%d", 10000+j)
+				setNode(node)
+				nodeAnswered(node)
+				setIndexerRunning(j%2 == 0)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if node, _, _ := nodeStatus(); node != "" && !strings.HasPrefix(node, "http://") {
+					t.Errorf("torn node %q", node)
+				}
+				currentNode()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestBroadcastNode(t *testing.T) {
+	withNode(t, "127.0.0.1:10102")
+	a, b := withClient(t), withClient(t)
+
+	broadcastNode()
+	for name, c := range map[string]*nativeClient{"a": a, "b": b} {
+		events := received(t, c)
+		if len(events) != 1 || events[0]["event"] != "node_changed" || events[0]["node"] != "http://127.0.0.1:10102" {
+			t.Errorf("client %s got %v", name, events)
+		}
+	}
+}
@@ -1,30 +1,47 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"io/fs"
 	"net"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/civilware/tela"
 )
 
+// telaApp is a loaded SCID, served from /tela/<scid>/ on its own origin out
+// of its app folder.
+type telaApp struct {
+	dir     string
+	entry   string
+	stale   bool
+	dev     bool // served from a --scid-root folder
+	files   map[string]fileMeta
+	details map[string]any // extra load_scid result fields
+
+	// Who published it, re-checked against the author list on every add
+	author     string
+	docAuthors []string
+	verified   bool
+}
+
 var (
 	telaOnce sync.Once
 	mu       sync.RWMutex
-	proxies  = map[string]*httputil.ReverseProxy{}
-	baseURLs = map[string]string{}
-	entries  = map[string]string{}
-	sharded  = map[string]bool{}
+	apps     = map[string]*telaApp{}
 )
 
 // -------------------- UTIL --------------------
@@ -48,33 +65,85 @@ func copyFile(src, dst string) error {
 
 // -------------------- ENTRYPOINT --------------------
 
-func findEntrypoint(folder string) (string, error) {
-	ents, err := os.ReadDir(folder)
-	if err != nil {
-		return "", err
+// findEntrypoint picks the page /tela/<scid>/ opens, as a slash separated
+// path relative to folder. An HTML hint declared by the INDEX wins. Otherwise
+// index.htm[l] is preferred in any case, a lone top-level folder is looked
+// into, and a lone HTML page is used as is. When several pages qualify the
+// first is returned together with every candidate, so the user can choose.
+func findEntrypoint(folder, hint string) (string, []string, error) {
+	if hint != "" && isHTML(hint) {
+		if fi, err := os.Stat(filepath.Join(folder, filepath.FromSlash(hint))); err == nil && !fi.IsDir() {
+			return hint, nil, nil
+		}
 	}
 
-	var htmlFiles []string
-	for _, e := range ents {
-		if e.IsDir() {
+	prefix := ""
+	dir := folder
+	for {
+		ents, err := os.ReadDir(dir)
+		if err != nil {
+			return "", nil, err
+		}
+
+		var htmlFiles, dirs []string
+		for _, e := range ents {
+			switch {
+			case e.IsDir():
+				if !strings.HasPrefix(e.Name(), ".") {
+					dirs = append(dirs, e.Name())
+				}
+			case isHTML(e.Name()):
+				htmlFiles = append(htmlFiles, e.Name())
+			}
+		}
+
+		if len(htmlFiles) == 0 && len(dirs) == 1 {
+			prefix = path.Join(prefix, dirs[0])
+			dir = filepath.Join(dir, dirs[0])
 			continue
 		}
-		if strings.HasSuffix(e.Name(), ".html") {
-			htmlFiles = append(htmlFiles, e.Name())
+
+		sort.Strings(htmlFiles)
+		for _, f := range htmlFiles {
+			if lower := strings.ToLower(f); lower == "index.html" || lower == "index.htm" {
+				return path.Join(prefix, f), nil, nil
+			}
 		}
-	}
 
-	for _, f := range htmlFiles {
-		if f == "index.html" {
-			return f, nil
+		switch len(htmlFiles) {
+		case 0:
+			return "", nil, fmt.Errorf("no HTML entrypoint found")
+		case 1:
+			return path.Join(prefix, htmlFiles[0]), nil, nil
 		}
-	}
 
-	if len(htmlFiles) > 0 {
-		return htmlFiles[0], nil
+		candidates := make([]string, len(htmlFiles))
+		for i, f := range htmlFiles {
+			candidates[i] = path.Join(prefix, f)
+		}
+		return candidates[0], candidates, nil
 	}
+}
+
+func isHTML(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".html" || ext == ".htm"
+}
 
-	return "", fmt.Errorf("no HTML entrypoint found")
+// entryHint returns the file DOC1 ended up in. TELA treats the first DOC of
+// an INDEX as the app's entrypoint.
+func entryHint(index tela.INDEX, files map[string]*appFile) string {
+	if len(index.DOCs) == 0 {
+		return ""
+	}
+	for key, f := range files {
+		for _, d := range f.docs {
+			if d == index.DOCs[0] {
+				return filepath.ToSlash(key)
+			}
+		}
+	}
+	return ""
 }
 
 // -------------------- SHARDS --------------------
@@ -105,39 +174,171 @@ func parseShardRawBytes(doc tela.DOC) ([]byte, error) {
 	return []byte(code[start+3 : end]), nil
 }
 
-func downloadAndReconstructShards(scid string, index tela.INDEX, telaNode string) (string, error) {
-	log.Printf("[SHARDS] Reconstructing SCID: %s", scid)
+// -------------------- FETCH --------------------
+
+const (
+	docFetchWorkers  = 4
+	docFetchAttempts = 4
+	docFetchBackoff  = 500 * time.Millisecond
+)
+
+// withRetries calls fetch until it succeeds, up to docFetchAttempts times
+// with exponential backoff between attempts.
+func withRetries(what, scid string, fetch func() error) error {
+	var err error
+	for attempt := 0; attempt < docFetchAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(docFetchBackoff << (attempt - 1))
+		}
+		if err = fetch(); err == nil {
+			return nil
+		}
+		logTELA.Warn(what+" failed", "scid", scid, "attempt", attempt+1, "err", err)
+	}
+	return err
+}
+
+// forEachParallel calls fn for 0..n-1 on a pool of docFetchWorkers workers
+// and returns once every call has.
+func forEachParallel(n int, fn func(i int)) {
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < docFetchWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// getDOCInfo reads a DOC from the node. Tests replace it to serve DOCs
+// from a fake daemon.
+var getDOCInfo = tela.GetDOCInfo
 
-	baseName := strings.TrimSuffix(index.DURL, tela.TAG_DOC_SHARDS)
-	appDir := filepath.Join(tela.GetClonePath(), baseName)
-	if err := os.MkdirAll(appDir, 0755); err != nil {
-		return "", err
+// fetchDOC fetches a single DOC, retrying with exponential backoff.
+func fetchDOC(docSCID, telaNode string) (tela.DOC, error) {
+	var doc tela.DOC
+	err := withRetries("DOC fetch", docSCID, func() (err error) {
+		doc, err = getDOCInfo(docSCID, telaNode)
+		return err
+	})
+	return doc, err
+}
+
+// fetchDOCs fetches docSCIDs for scid with a bounded worker pool and returns
+// them in INDEX order. Cached DOCs, including those stored by an earlier
+// interrupted attempt, are reused, and a load_progress event is sent as each
+// DOC arrives. A failing DOC does not stop the others; the error lists what
+// is missing so a retry can resume.
+func fetchDOCs(scid string, docSCIDs []string, telaNode string) ([]tela.DOC, error) {
+	defer cache.save()
+
+	docs := make([]tela.DOC, len(docSCIDs))
+	errs := make([]error, len(docSCIDs))
+
+	var (
+		progressMu sync.Mutex
+		fetched    int
+		size       int
+	)
+	progress := func(doc tela.DOC) {
+		progressMu.Lock()
+		defer progressMu.Unlock()
+		fetched++
+		size += len(doc.Code)
+		sendMsg(map[string]any{
+			"event":   "load_progress",
+			"scid":    scid,
+			"fetched": fetched,
+			"total":   len(docSCIDs),
+			"bytes":   size,
+		})
 	}
 
+	forEachParallel(len(docSCIDs), func(i int) {
+		docSCID := docSCIDs[i]
+
+		if doc, ok := cache.getDOC(docSCID); ok {
+			docs[i] = doc
+			progress(doc)
+			return
+		}
+
+		doc, err := fetchDOC(docSCID, telaNode)
+		if err != nil {
+			errs[i] = fmt.Errorf("%s: %w", docSCID, err)
+			return
+		}
+		if doc.SCID == "" {
+			doc.SCID = docSCID
+		}
+		if err := cache.putDOC(doc); err != nil {
+			logTELA.Error("caching DOC", "scid", docSCID, "err", err)
+		}
+		docs[i] = doc
+		progress(doc)
+	})
+
+	var failed []error
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	if len(failed) > 0 {
+		return nil, fmt.Errorf("fetched %d/%d DOCs, load again to resume: %w",
+			len(docSCIDs)-len(failed), len(docSCIDs), errors.Join(failed...))
+	}
+
+	return docs, nil
+}
+
+// appFile is a single file of a TELA app rebuilt from one or more DOCs.
+type appFile struct {
+	data        []byte
+	docs        []string
+	docType     string
+	compression string
+	encoded     []byte // compressed form as stored on chain, if servable
+	brotli      []byte // brotli copy, for text files worth compressing
+}
+
+// assembleFiles groups DOCs into the files they make up and returns them keyed
+// by their path relative to the app root. Shard suffixes are only honoured
+// when shards is set, so a regular file such as "app-2.js" is left alone.
+func assembleFiles(docs []tela.DOC, shards bool) (map[string]*appFile, error) {
 	type shard struct {
 		idx  int
 		data []byte
 	}
 	type group struct {
 		shards      []shard
+		docs        []string
+		docType     string
 		compression string
 		isSharded   bool
 	}
 
 	groups := map[string]*group{}
 
-	for _, docSCID := range index.DOCs {
-		doc, err := tela.GetDOCInfo(docSCID, telaNode)
-		if err != nil {
-			return "", err
-		}
-
+	for _, doc := range docs {
 		raw, err := parseShardRawBytes(doc)
 		if err != nil {
-			return "", err
+			return nil, fmt.Errorf("%s: %w", doc.SCID, err)
 		}
 
-		idx, base := detectShard(doc.Headers.NameHdr, doc.Compression)
+		idx, base := 0, tela.TrimCompressedExt(doc.Headers.NameHdr)
+		if shards {
+			idx, base = detectShard(doc.Headers.NameHdr, doc.Compression)
+		}
 		key := base
 		if doc.SubDir != "" {
 			key = filepath.Join(doc.SubDir, base)
@@ -147,66 +348,210 @@ func downloadAndReconstructShards(scid string, index tela.INDEX, telaNode string
 			groups[key] = &group{}
 		}
 		g := groups[key]
+		g.docType = doc.DocType
 		g.compression = doc.Compression
 		if idx > 0 {
 			g.isSharded = true
 		}
 		g.shards = append(g.shards, shard{idx, raw})
+		g.docs = append(g.docs, doc.SCID)
 	}
 
+	files := make(map[string]*appFile, len(groups))
 	for key, g := range groups {
-		dst := filepath.Join(appDir, key)
-		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
-			return "", err
-		}
-
 		var data []byte
 		if g.isSharded {
 			sort.Slice(g.shards, func(i, j int) bool { return g.shards[i].idx < g.shards[j].idx })
 			buf := []byte{}
-			for _, s := range g.shards {
-				buf = append(buf, s.data...)
-			}
-			if g.compression != "" {
-				var err error
-				data, err = tela.Decompress(buf, g.compression)
-				if err != nil {
-					return "", err
+			for i, s := range g.shards {
+				if s.idx != i+1 {
+					return nil, fmt.Errorf("%w: %s: shards are not numbered 1..%d", errIntegrity, key, len(g.shards))
 				}
-			} else {
-				data = buf
+				buf = append(buf, s.data...)
 			}
+			data = buf
 		} else {
+			if len(g.shards) > 1 {
+				return nil, fmt.Errorf("%w: %s: declared by %d DOCs", errIntegrity, key, len(g.shards))
+			}
 			data = g.shards[0].data
-			if g.compression != "" {
-				var err error
-				data, err = tela.Decompress(data, g.compression)
-				if err != nil {
-					return "", err
+		}
+
+		var encoded []byte
+		if g.compression != "" {
+			encoded = encodedForm(data, g.compression)
+			var err error
+			data, err = tela.Decompress(data, g.compression)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+		}
+
+		files[key] = &appFile{
+			data:        data,
+			docs:        g.docs,
+			docType:     g.docType,
+			compression: g.compression,
+			encoded:     encoded,
+			brotli:      brotliForm(key, g.docType, data),
+		}
+	}
+
+	return files, nil
+}
+
+// reconstructApp rebuilds the files of an INDEX into its cache folder and
+// returns the folder and its entrypoint. An unchanged INDEX reuses the folder
+// from an earlier load without rewriting it.
+func reconstructApp(scid string, index tela.INDEX, telaNode string) (string, string, error) {
+	logTELA.Info("reconstructing", "scid", scid)
+
+	docSCIDs, libs, err := expandLibraries(index.DOCs, telaNode)
+	if err != nil {
+		return "", "", err
+	}
+	cache.pin(docSCIDs...)
+	defer cache.unpin(docSCIDs...)
+
+	docs, err := fetchDOCs(scid, docSCIDs, telaNode)
+	if err != nil {
+		return "", "", err
+	}
+	libs = append(libs, docLibraries(docs)...)
+
+	files, err := assembleFiles(docs, strings.HasSuffix(index.DURL, tela.TAG_DOC_SHARDS))
+	if err != nil {
+		return "", "", err
+	}
+
+	// Refuse to serve anything whose DOCs do not match their declared
+	// signatures or the INDEX. Mismatching DOCs are dropped from the cache
+	// so that a later load fetches them again.
+	checks, failed := verifyDOCs(docSCIDs, docs, files)
+	verified := true
+	for _, fc := range checks {
+		if fc.Status != verifyOK {
+			verified = false
+		}
+	}
+	if failed {
+		var problems []string
+		for _, fc := range checks {
+			if fc.Status == verifyMismatch {
+				problems = append(problems, fc.Errors...)
+				for _, d := range fc.DOCs {
+					cache.dropDOC(d)
 				}
 			}
 		}
+		cache.save()
+		return "", "", fmt.Errorf("%w: %s", errIntegrity, strings.Join(problems, "; "))
+	}
 
-		if err := os.WriteFile(dst, data, 0644); err != nil {
-			return "", err
+	version := appVersion(docs)
+	metas := fileMetas(files)
+	if e, dir, ok := cache.getApp(scid, version); ok {
+		bad := checkAppDir(dir, metas)
+		if len(bad) == 0 {
+			logTELA.Info("unchanged, reusing cached files", "scid", scid, "dir", dir)
+			return dir, e.Entry, nil
 		}
+		logTELA.Warn("cached files changed on disk, rebuilding", "scid", scid, "files", strings.Join(bad, ", "))
 	}
 
-	ln, _ := net.Listen("tcp", "127.0.0.1:0")
-	port := ln.Addr().(*net.TCPAddr).Port
-	ln.Close()
+	// The new version is built next to the others and renamed into place,
+	// so the folder being served and the copy kept for offline use survive
+	// a failed load. Older versions are only removed once the new one is
+	// served; see pruneVersions.
+	appDir := cache.appDir(scid, version)
+	if err := os.MkdirAll(filepath.Dir(appDir), 0755); err != nil {
+		return "", "", err
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(appDir), filepath.Base(appDir)+".tmp-")
+	if err != nil {
+		return "", "", err
+	}
+	defer os.RemoveAll(encodedDir(tmp))
+	defer os.RemoveAll(tmp)
 
-	go http.ListenAndServe(
-		fmt.Sprintf("127.0.0.1:%d", port),
-		http.FileServer(http.Dir(appDir)),
-	)
+	var size int64
+	for key, f := range files {
+		dst := filepath.Join(tmp, key)
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return "", "", err
+		}
+		if err := os.WriteFile(dst, f.data, 0644); err != nil {
+			return "", "", err
+		}
+		size += int64(len(f.data))
 
-	entry, err := findEntrypoint(appDir)
+		if f.encoded != nil {
+			enc := filepath.Join(encodedDir(tmp), key+f.compression)
+			if err := os.MkdirAll(filepath.Dir(enc), 0755); err != nil {
+				return "", "", err
+			}
+			if err := os.WriteFile(enc, f.encoded, 0644); err != nil {
+				return "", "", err
+			}
+			size += int64(len(f.encoded))
+		}
+		if f.brotli != nil {
+			br := filepath.Join(encodedDir(tmp), key+".br")
+			if err := os.MkdirAll(filepath.Dir(br), 0755); err != nil {
+				return "", "", err
+			}
+			if err := os.WriteFile(br, f.brotli, 0644); err != nil {
+				return "", "", err
+			}
+			size += int64(len(f.brotli))
+		}
+	}
+
+	entry, candidates, err := findEntrypoint(tmp, entryHint(index, files))
 	if err != nil {
-		return "", err
+		return "", "", err
+	}
+
+	// A folder of the same version is only rebuilt when its files changed
+	// on disk, so there is nothing worth keeping in it
+	os.RemoveAll(appDir)
+	os.RemoveAll(encodedDir(appDir))
+	if err := os.Rename(tmp, appDir); err != nil {
+		return "", "", err
+	}
+	if _, err := os.Stat(encodedDir(tmp)); err == nil {
+		if err := os.Rename(encodedDir(tmp), encodedDir(appDir)); err != nil {
+			return "", "", err
+		}
 	}
 
-	return fmt.Sprintf("http://127.0.0.1:%d/%s", port, entry), nil
+	cache.putApp(scid, &cacheEntry{
+		Hash:  version,
+		Size:  size,
+		DURL:  index.DURL,
+		DOCs:  docSCIDs,
+		Entry: entry,
+		Files: metas,
+
+		Candidates: candidates,
+		Libraries:  libs,
+
+		Author:     index.Author,
+		DOCAuthors: docAuthors(docs),
+		Verified:   verified,
+	})
+	cache.save()
+
+	return appDir, entry, nil
+}
+
+// docAuthors lists the author of each DOC.
+func docAuthors(docs []tela.DOC) []string {
+	list := make([]string, 0, len(docs))
+	for _, doc := range docs {
+		list = append(list, doc.Author)
+	}
+	return list
 }
 
 // -------------------- ADD SCID --------------------
@@ -215,150 +560,414 @@ func addSCID(w http.ResponseWriter, r *http.Request) {
 	scid := strings.TrimPrefix(r.URL.Path, "/add/")
 	scid = strings.Split(scid, "/")[0]
 
-	log.Printf("[ADD] %s", scid)
-
-	if currentNode == "" {
-		http.Error(w, "node not set", 400)
-		return
-	}
+	logTELA.Info("loading", "scid", scid)
 
 	mu.RLock()
-	if base, ok := baseURLs[scid]; ok {
-		mu.RUnlock()
-		writeJSON(w, scid, base)
+	app, loaded := apps[scid]
+	mu.RUnlock()
+
+	start := time.Now()
+	var (
+		base  string
+		extra map[string]any
+		err   error
+	)
+	if loaded {
+		extra, err = recheckAuthor(scid, app)
+		base = appURL(scid)
+	} else {
+		base, extra, err = loadSCID(scid)
+	}
+	if err != nil {
+		code, reason := 500, "error"
+		switch {
+		case errors.Is(err, errAuthorRefused):
+			code, reason = 403, "refused"
+		case errors.Is(err, errIntegrity):
+			reason = "integrity"
+		case currentNode() == "":
+			code, reason = 400, "no_node"
+		}
+		mLoadFailures.inc(reason)
+		http.Error(w, err.Error(), code)
 		return
 	}
-	mu.RUnlock()
+	if !loaded {
+		mLoadDuration.observe(time.Since(start).Seconds())
+	}
 
-	telaNode := strings.TrimPrefix(currentNode, "http://")
-	var rawURL string
-	isShardedSCID := false
+	writeJSON(w, scid, base, extra)
+}
 
-	index, err := tela.GetINDEXInfo(scid, telaNode)
-	if err == nil && strings.HasSuffix(index.DURL, tela.TAG_DOC_SHARDS) {
-		rawURL, err = downloadAndReconstructShards(scid, index, telaNode)
-		isShardedSCID = true
-	} else {
-		rawURL, err = tela.ServeTELA(scid, telaNode)
+// recheckAuthor applies the author policy to an app that is already loaded,
+// as the author list may have changed since, and returns its details with
+// the author as it stands now. A refused app is unloaded.
+func recheckAuthor(scid string, app *telaApp) (map[string]any, error) {
+	if app.dev {
+		return app.details, nil
+	}
+	author := describeAuthors(app.author, app.docAuthors, app.verified)
+	if err := applyAuthorPolicy(author); err != nil {
+		unloadSCID(scid, err.Error())
+		return nil, err
 	}
 
-	if err != nil {
-		http.Error(w, err.Error(), 500)
+	mu.Lock()
+	defer mu.Unlock()
+	details := make(map[string]any, len(app.details))
+	for k, v := range app.details {
+		details[k] = v
+	}
+	details["author"] = author
+	app.details = details
+	return details, nil
+}
+
+// unloadSCID stops serving a SCID loaded from chain or an archive.
+func unloadSCID(scid, reason string) {
+	mu.Lock()
+	app := apps[scid]
+	if app != nil && !app.dev {
+		delete(apps, scid)
+	}
+	mu.Unlock()
+	if app == nil || app.dev {
 		return
 	}
+	logTELA.Info("unloaded", "scid", scid, "reason", reason)
+	sendMsg(map[string]any{"event": "scid_unloaded", "scid": scid, "reason": reason})
+}
 
-	// For shards: split off the entry filename from the base URL.
-	// For ServeTELA: always treat the whole URL as the base directory so that
-	// sibling pages (algo4.html, Snake Deluxe.html, …) remain reachable via
-	// the proxy without an extra redirect.
-	var base, entry string
-	if isShardedSCID && strings.HasSuffix(rawURL, ".html") {
-		base = rawURL[:strings.LastIndex(rawURL, "/")+1]
-		entry = rawURL[strings.LastIndex(rawURL, "/")+1:]
-	} else {
-		base = strings.TrimSuffix(rawURL, "/index.html")
-		if !strings.HasSuffix(base, "/") {
-			base += "/"
+// loadSCID reconstructs scid from the node and maps it for serving. When no
+// node is set or the node cannot provide the INDEX, the last cached version
+// is served instead and flagged as stale until it can be revalidated.
+// Returns the base URL and the extra details reported with load_scid.
+func loadSCID(scid string) (string, map[string]any, error) {
+	cache.pin(scid)
+	defer cache.unpin(scid)
+
+	var appDir string
+	isStale := false
+
+	err := fmt.Errorf("node not set")
+	if node := currentNode(); node != "" {
+		telaNode := strings.TrimPrefix(node, "http://")
+
+		var index tela.INDEX
+		index, err = tela.GetINDEXInfo(scid, telaNode)
+		if err == nil {
+			appDir, _, err = reconstructApp(scid, index, telaNode)
+		}
+		if err == nil {
+			setPin(scid, pin{
+				Height:   getChainHeightFromDaemon(node),
+				DURL:     index.DURL,
+				DOCs:     index.DOCs,
+				LoadedAt: time.Now(),
+			})
+		}
+	}
+
+	if errors.Is(err, errIntegrity) {
+		return "", nil, err
+	}
+	if err != nil {
+		_, dir, ok := cache.lastApp(scid)
+		if !ok {
+			return "", nil, err
 		}
+		logTELA.Warn("serving cached copy", "scid", scid, "err", err)
+		appDir, isStale = dir, true
 	}
 
-	log.Printf("[MAP] base=%s entry=%s sharded=%v", base, entry, isShardedSCID)
+	// Author details are re-evaluated on every load so that changes to the
+	// trusted/blocked list apply to cached copies too
+	e, _, ok := cache.lastApp(scid)
+	if !ok {
+		return "", nil, fmt.Errorf("%s was evicted from the cache while loading", scid)
+	}
+	author := describeAuthors(e.Author, e.DOCAuthors, e.Verified)
+	if err := applyAuthorPolicy(author); err != nil {
+		return "", nil, err
+	}
+	base := appURL(scid)
+
+	libs := e.Libraries
+	if libs == nil {
+		libs = []library{}
+	}
 
-	target, _ := url.Parse(base)
-	proxy := httputil.NewSingleHostReverseProxy(target)
-	proxy.Director = func(req *http.Request) {
-		req.URL.Scheme = target.Scheme
-		req.URL.Host = target.Host
-		req.Host = target.Host
+	logTELA.Info("mapped", "base", base, "entry", e.Entry, "dir", appDir, "stale", isStale, "author", author.Address)
+
+	extra := map[string]any{
+		"stale":  isStale,
+		"author": author,
+		"entry":  e.Entry,
+
+		"libraries": libs,
 	}
-	proxy.ModifyResponse = func(resp *http.Response) error {
-		resp.Header.Del("Content-Security-Policy")
-		return nil
+	if len(e.Candidates) > 1 {
+		extra["candidates"] = e.Candidates
 	}
 
 	mu.Lock()
-	proxies[scid] = proxy
-	baseURLs[scid] = base
-	entries[scid] = entry
-	sharded[scid] = isShardedSCID
+	apps[scid] = &telaApp{
+		dir:     appDir,
+		entry:   e.Entry,
+		stale:   isStale,
+		files:   e.Files,
+		details: extra,
+
+		author:     e.Author,
+		docAuthors: e.DOCAuthors,
+		verified:   e.Verified,
+	}
 	mu.Unlock()
 
-	writeJSON(w, scid, base)
+	// Only the version now being served is kept
+	cache.pruneVersions(scid)
+
+	return base, extra, nil
+}
+
+// revalidateStale reloads the SCIDs that were served from cache while offline
+// once a node is reachable again.
+func revalidateStale() {
+	for _, scid := range staleSCIDs() {
+		var before string
+		if e, _, ok := cache.lastApp(scid); ok {
+			before = e.Hash
+		}
+
+		_, extra, err := loadSCID(scid)
+		if err != nil || extra["stale"] == true {
+			logTELA.Warn("revalidation failed", "scid", scid, "err", err)
+			continue
+		}
+
+		changed := true
+		if e, _, ok := cache.lastApp(scid); ok {
+			changed = e.Hash != before
+		}
+		logTELA.Info("revalidated", "scid", scid, "changed", changed)
+		sendMsg(map[string]any{
+			"event":   "scid_revalidated",
+			"scid":    scid,
+			"changed": changed,
+		})
+	}
 }
 
 // -------------------- HTTP --------------------
 
-func writeJSON(w http.ResponseWriter, scid, base string) {
+func writeJSON(w http.ResponseWriter, scid, base string, extra map[string]any) {
+	result := map[string]any{
+		"scid": scid,
+		"url":  base,
+	}
+	for k, v := range extra {
+		result[k] = v
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]any{
-		"ok": true,
-		"result": map[string]any{
-			"scid": scid,
-			"url":  base,
-		},
+		"ok":     true,
+		"result": result,
 	})
 }
 
-// -------------------- START TELA --------------------
+// Every app is served from an origin of its own, <label>.localhost on the
+// proxy port, so that browsers keep apps apart: each gets its own storage
+// and cookies, and the bridges can tell from the origin which app is asking.
+// A SCID is longer than a DNS label allows, so the label is a hash of it.
 
-func startTELA() {
-	telaOnce.Do(func() {
-		tela.AllowUpdates(true)
+// appLabel is the host name label of an app's origin.
+func appLabel(scid string) string {
+	sum := sha256.Sum256([]byte(scid))
+	return hex.EncodeToString(sum[:16])
+}
 
-		http.HandleFunc("/add/", addSCID)
+// appHost is the host, with port, a loaded app is served from.
+func appHost(scid string) string {
+	return fmt.Sprintf("%s.localhost:%d", appLabel(scid), telaPortInUse())
+}
 
-		http.HandleFunc("/tela/", func(w http.ResponseWriter, r *http.Request) {
-			parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/tela/"), "/", 2)
-			if len(parts) == 0 || parts[0] == "" {
-				http.NotFound(w, r)
-				return
-			}
+// appOrigin is the origin a loaded app's pages run under.
+func appOrigin(scid string) string {
+	return "http://" + appHost(scid)
+}
 
-			scid := parts[0]
+// servesApp reports whether a request's Host is the origin of scid.
+func servesApp(host, scid string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.EqualFold(host, appLabel(scid)+".localhost")
+}
+
+// appURL is the address a loaded SCID is served at.
+func appURL(scid string) string {
+	return fmt.Sprintf("%s/tela/%s/", appOrigin(scid), scid)
+}
+
+// -------------------- SERVE --------------------
+
+// telaFS is the virtual filesystem behind /tela/. Its top level holds one
+// directory per loaded SCID, each backed by that SCID's app folder, so every
+// app is served in-process from a single file server.
+type telaFS struct{}
+
+func (telaFS) Open(name string) (fs.File, error) {
+	scid, rest, _ := strings.Cut(name, "/")
+
+	mu.RLock()
+	app := apps[scid]
+	mu.RUnlock()
+
+	if app == nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if rest == "" {
+		rest = "."
+	}
+	return os.DirFS(app.dir).Open(rest)
+}
 
-			mu.RLock()
-			proxy := proxies[scid]
-			entry := entries[scid]
-			isSharded := sharded[scid]
-			mu.RUnlock()
+// serveTELA serves /tela/<scid>/... for every loaded SCID.
+func serveTELA() http.Handler {
+	files := http.StripPrefix("/tela/", http.FileServer(http.FS(telaFS{})))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scid, subPath, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/tela/"), "/")
+		if scid == "" {
+			http.NotFound(w, r)
+			return
+		}
 
-			if proxy == nil {
-				http.Error(w, "SCID not loaded", 404)
+		mu.RLock()
+		app := apps[scid]
+		mu.RUnlock()
+
+		if app == nil {
+			http.Error(w, "SCID not loaded", 404)
+			return
+		}
+
+		// A SCID is only served from its own origin. Plain page loads from
+		// elsewhere, such as links to the shared address older versions
+		// used, are sent there; anything else is refused so that one app
+		// cannot act as another through its paths.
+		if !servesApp(r.Host, scid) {
+			if (r.Method == http.MethodGet || r.Method == http.MethodHead) && !strings.HasPrefix(subPath, bridgePrefix) {
+				http.Redirect(w, r, appOrigin(scid)+r.URL.RequestURI(), http.StatusFound)
 				return
 			}
+			http.Error(w, "SCID is not served from this origin", http.StatusForbidden)
+			return
+		}
 
-			subPath := ""
-			if len(parts) == 2 {
-				subPath = parts[1]
-			}
+		w.Header().Set("Content-Security-Policy", contentSecurityPolicy(scid))
+
+		// Reserved paths of the wallet bridge
+		if strings.HasPrefix(subPath, bridgePrefix) {
+			serveBridge(w, r, scid, subPath)
+			return
+		}
 
-			// Shards have no index.html so we redirect to the discovered entry
-			// file. ServeTELA always serves a full directory, so we let / fall
-			// through naturally — redirecting it would break sibling navigation.
-			if isSharded && subPath == "" && entry != "" {
-				http.Redirect(w, r, "/tela/"+scid+"/"+url.PathEscape(entry), http.StatusFound)
+		// Apps without a top-level index.html are redirected to the
+		// discovered entry file. index.html itself is left to the file
+		// server, which would otherwise redirect it straight back to /.
+		if subPath == "" && app.entry != "" && app.entry != "index.html" {
+			http.Redirect(w, r, "/tela/"+scid+"/"+(&url.URL{Path: app.entry}).EscapedPath(), http.StatusFound)
+			return
+		}
+
+		// Marks SCIDs served from an offline cache copy that has not been
+		// revalidated against a node yet
+		if app.stale {
+			w.Header().Set("X-PureWolf-Stale", "1")
+		}
+
+		logProxy.Debug("serve", "scid", scid, "path", "/"+subPath)
+
+		name := subPath
+		if name == "" || strings.HasSuffix(name, "/") {
+			name += "index.html"
+		}
+		name = path.Clean(name)
+		if meta, ok := app.files[name]; ok {
+			setAssetHeaders(w, name, meta)
+			if isHTML(name) && serveWithBridge(w, r, app, scid, name, meta) {
 				return
 			}
-
-			if subPath == "" {
-				r.URL.Path = "/"
-			} else {
-				r.URL.Path = "/" + subPath
+			if serveEncoded(w, r, app, name, meta) {
+				return
 			}
+		}
+		files.ServeHTTP(w, r)
+	})
+}
 
-			log.Printf("[PROXY] %s -> %s", scid, r.URL.Path)
-			proxy.ServeHTTP(w, r)
-		})
+// -------------------- START TELA --------------------
 
+func startTELA() {
+	telaOnce.Do(func() {
+		tela.AllowUpdates(true)
+
+		http.HandleFunc("/add/", addSCID)
+		http.Handle("/tela/", countRequests(serveTELA()))
+		http.HandleFunc("/metrics", serveMetrics)
+
+		port := settingInt("telaPort")
+		ln, err := listenLocal("tela", port)
+		recordBound(&telaBound, port, ln, "", err)
+		if err != nil {
+			logProxy.Error("TELA proxy not started", "err", err)
+			return
+		}
+		logProxy.Info("TELA proxy listening", "addr", ln.Addr().String())
 		go func() {
-			log.Printf("TELA proxy listening on :%d", *telaPort)
-			http.ListenAndServe(fmt.Sprintf("127.0.0.1:%d", *telaPort), nil)
+			if err := http.Serve(ln, nil); err != nil {
+				logProxy.Error("TELA proxy stopped", "err", err)
+			}
 		}()
 	})
 }
 
 // -------------------- CLEANUP --------------------
 
+// isLoaded reports whether scid is currently being served.
+func isLoaded(scid string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	_, ok := apps[scid]
+	return ok
+}
+
+// loadedSCIDs lists the SCIDs currently being served.
+func loadedSCIDs() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	list := make([]string, 0, len(apps))
+	for scid := range apps {
+		list = append(list, scid)
+	}
+	return list
+}
+
+// staleSCIDs lists the loaded SCIDs served from an offline cache copy.
+func staleSCIDs() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	list := []string{}
+	for scid, app := range apps {
+		if app.stale {
+			list = append(list, scid)
+		}
+	}
+	return list
+}
+
 func cleanupTelaCloneFromError(err error) bool {
 	if err == nil || !strings.Contains(err.Error(), "already exists") {
 		return false
@@ -366,11 +975,14 @@ func cleanupTelaCloneFromError(err error) bool {
 	return true
 }
 
-func resetProxies() {
+// resetApps stops serving every SCID loaded from chain or an archive.
+// Developer folders stay mounted.
+func resetApps() {
 	mu.Lock()
-	proxies = map[string]*httputil.ReverseProxy{}
-	baseURLs = map[string]string{}
-	entries = map[string]string{}
-	sharded = map[string]bool{}
+	for scid, app := range apps {
+		if !app.dev {
+			delete(apps, scid)
+		}
+	}
 	mu.Unlock()
-}
\ No newline at end of file
+}
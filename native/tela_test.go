@@ -0,0 +1,412 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/civilware/tela"
+)
+
+// writeTree creates files (slash separated paths) under a temp folder.
+func writeTree(t *testing.T, files ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, f := range files {
+		p := filepath.Join(dir, filepath.FromSlash(f))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestFindEntrypoint(t *testing.T) {
+	tests := []struct {
+		name       string
+		files      []string
+		hint       string
+		want       string
+		candidates []string
+		wantErr    bool
+	}{
+		{"index.html", []string{"index.html", "about.html"}, "", "index.html", nil, false},
+		{"index in any case", []string{"Index.HTM", "a.html"}, "", "Index.HTM", nil, false},
+		{"hint wins", []string{"index.html", "app.html"}, "app.html", "app.html", nil, false},
+		{"missing hint ignored", []string{"index.html"}, "gone.html", "index.html", nil, false},
+		{"non-HTML hint ignored", []string{"index.html", "main.js"}, "main.js", "index.html", nil, false},
+		{"lone page", []string{"app.html", "style.css"}, "", "app.html", nil, false},
+		{"lone folder", []string{"site/index.html", ".git/config"}, "", "site/index.html", nil, false},
+		{"nested lone folders", []string{"a/b/page.html"}, "", "a/b/page.html", nil, false},
+		{"several pages", []string{"b.html", "a.html"}, "", "a.html", []string{"a.html", "b.html"}, false},
+		{"no HTML", []string{"main.js"}, "", "", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, candidates, err := findEntrypoint(writeTree(t, tt.files...), tt.hint)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want || !reflect.DeepEqual(candidates, tt.candidates) {
+				t.Errorf("got %q %v, want %q %v", got, candidates, tt.want, tt.candidates)
+			}
+		})
+	}
+}
+
+func TestDetectShard(t *testing.T) {
+	tests := []struct {
+		nameHdr string
+		idx     int
+		base    string
+	}{
+		{"app.js-1", 1, "app.js"},
+		{"app.js-12", 12, "app.js"},
+		{"my-app.js-2", 2, "my-app.js"},
+		{"app.js", 0, "app.js"},
+		{"app.js-0", 0, "app.js-0"},
+		{"app-js", 0, "app-js"},
+	}
+	for _, tt := range tests {
+		idx, base := detectShard(tt.nameHdr, "")
+		if idx != tt.idx || base != tt.base {
+			t.Errorf("detectShard(%q) = %d %q, want %d %q", tt.nameHdr, idx, base, tt.idx, tt.base)
+		}
+	}
+}
+
+// shardDOC is a DOC whose code carries data the way TELA stores it.
+func shardDOC(scid, name, subDir, data string) tela.DOC {
+	return tela.DOC{
+		SCID:    scid,
+		SubDir:  subDir,
+		Code:    "/*\n" + data + "*/",
+		Headers: tela.Headers{NameHdr: name},
+	}
+}
+
+func TestAssembleFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		docs    []tela.DOC
+		shards  bool
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name: "plain files",
+			docs: []tela.DOC{shardDOC("d1", "index.html", "", "<p>"), shardDOC("d2", "app.js", "lib", "js")},
+			want: map[string]string{"index.html": "<p>", filepath.Join("lib", "app.js"): "js"},
+		},
+		{
+			name:   "shards joined in order",
+			docs:   []tela.DOC{shardDOC("d2", "big.js-2", "", "two"), shardDOC("d1", "big.js-1", "", "one")},
+			shards: true,
+			want:   map[string]string{"big.js": "onetwo"},
+		},
+		{
+			name: "shard names left alone without shards",
+			docs: []tela.DOC{shardDOC("d1", "app-1", "", "a"), shardDOC("d2", "app-2", "", "b")},
+			want: map[string]string{"app-1": "a", "app-2": "b"},
+		},
+		{
+			name:    "missing shard",
+			docs:    []tela.DOC{shardDOC("d1", "big.js-1", "", "one"), shardDOC("d3", "big.js-3", "", "three")},
+			shards:  true,
+			wantErr: true,
+		},
+		{
+			name:    "file declared twice",
+			docs:    []tela.DOC{shardDOC("d1", "index.html", "", "a"), shardDOC("d2", "index.html", "", "b")},
+			wantErr: true,
+		},
+		{
+			name:    "no data block",
+			docs:    []tela.DOC{{SCID: "d1", Code: "Function Initialize()", Headers: tela.Headers{NameHdr: "x.js"}}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, err := assembleFiles(tt.docs, tt.shards)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if tt.name != "no data block" && !errors.Is(err, errIntegrity) {
+					t.Errorf("err = %v, want errIntegrity", err)
+				}
+				return
+			}
+			got := map[string]string{}
+			for key, f := range files {
+				got[key] = string(f.data)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForEachParallel(t *testing.T) {
+	const n = 50
+	var seen [n]atomic.Int32
+	forEachParallel(n, func(i int) { seen[i].Add(1) })
+	for i := range seen {
+		if c := seen[i].Load(); c != 1 {
+			t.Errorf("index %d ran %d times", i, c)
+		}
+	}
+}
+
+// withDOCsFrom serves DOCs from a fakeDaemon for one test, reading the
+// headers TELA keeps in a DOC's variables, and counts the lookups of each.
+func withDOCsFrom(t *testing.T) map[string]*atomic.Int32 {
+	t.Helper()
+	var mu sync.Mutex
+	lookups := map[string]*atomic.Int32{}
+	prev := getDOCInfo
+	getDOCInfo = func(scid, node string) (tela.DOC, error) {
+		mu.Lock()
+		if lookups[scid] == nil {
+			lookups[scid] = &atomic.Int32{}
+		}
+		lookups[scid].Add(1)
+		mu.Unlock()
+		vars, code, err := getSC(node, scid, 0, true)
+		if err != nil {
+			return tela.DOC{}, err
+		}
+		if vars["nameHdr"] == "" {
+			return tela.DOC{}, errors.New("not a DOC")
+		}
+		return tela.DOC{
+			SCID:    scid,
+			DocType: vars["docType"],
+			SubDir:  vars["subDir"],
+			Code:    code,
+			Headers: tela.Headers{NameHdr: vars["nameHdr"]},
+		}, nil
+	}
+	t.Cleanup(func() { getDOCInfo = prev })
+	return lookups
+}
+
+func TestFetchDOCs(t *testing.T) {
+	withTestCache(t)
+	lookups := withDOCsFrom(t)
+	client := withClient(t)
+
+	contract := func(name, data string) map[string]string {
+		return map[string]string{"docType": tela.ParseDocType(name), "nameHdr": name, fakeCode: "/*\n" + data + "*/"}
+	}
+	node := fakeDaemon(t, map[string]map[string]string{
+		"page":   contract("index.html", "<p>"),
+		"shard1": contract("app.js-1", "one "),
+		"shard2": contract("app.js-2", "two "),
+		"shard3": contract("app.js-3", "three"),
+	}, "shard2")
+	cached := shardDOC("style", "style.css", "", "p{}")
+	if err := cache.putDOC(cached); err != nil {
+		t.Fatal(err)
+	}
+
+	order := []string{"page", "shard3", "style", "shard2", "shard1"}
+	docs, err := fetchDOCs("app", order, node)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, doc := range docs {
+		got = append(got, doc.SCID)
+	}
+	if !reflect.DeepEqual(got, order) {
+		t.Errorf("DOCs in order %v, want %v", got, order)
+	}
+	for _, scid := range order {
+		want := int32(1)
+		switch scid {
+		case "shard2":
+			want = 2 // failed once, then retried
+		case "style":
+			want = 0
+		}
+		var n int32
+		if lookups[scid] != nil {
+			n = lookups[scid].Load()
+		}
+		if n != want {
+			t.Errorf("%s looked up %d times, want %d", scid, n, want)
+		}
+	}
+
+	// One event per DOC, cached ones included, counting up to the total
+	var size int
+	for _, doc := range docs {
+		size += len(doc.Code)
+	}
+	var events []map[string]any
+	for _, ev := range received(t, client) {
+		if ev["event"] == "load_progress" {
+			events = append(events, ev)
+		}
+	}
+	if len(events) != len(order) {
+		t.Fatalf("got %d load_progress events, want %d", len(events), len(order))
+	}
+	for i, ev := range events {
+		if ev["scid"] != "app" || ev["fetched"] != float64(i+1) || ev["total"] != float64(len(order)) {
+			t.Errorf("event %d: %v", i, ev)
+		}
+	}
+	if last := events[len(events)-1]; last["bytes"] != float64(size) {
+		t.Errorf("reported %v bytes, want %d", last["bytes"], size)
+	}
+
+	files, err := assembleFiles(docs, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"index.html": "<p>", "app.js": "one two three", "style.css": "p{}"}
+	for name, data := range want {
+		if f := files[name]; f == nil || string(f.data) != data {
+			t.Errorf("%s = %v, want %q", name, f, data)
+		}
+	}
+	if len(files) != len(want) {
+		t.Errorf("assembled %d files, want %d", len(files), len(want))
+	}
+	if d := files["app.js"].docs; !reflect.DeepEqual(d, []string{"shard3", "shard2", "shard1"}) {
+		t.Errorf("app.js from %v", d)
+	}
+
+	// Fetched DOCs are cached, so a second load does not go to the node
+	if _, ok := cache.getDOC("shard2"); !ok {
+		t.Error("fetched DOC not cached")
+	}
+}
+
+func TestFetchDOCsMissing(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits out every retry")
+	}
+	withTestCache(t)
+	lookups := withDOCsFrom(t)
+	node := fakeDaemon(t, map[string]map[string]string{
+		"page": {"nameHdr": "index.html", fakeCode: "/*\n<p>*/"},
+	})
+
+	_, err := fetchDOCs("app", []string{"page", "gone"}, node)
+	if err == nil || !strings.Contains(err.Error(), "fetched 1/2") || !strings.Contains(err.Error(), "gone") {
+		t.Errorf("got %v", err)
+	}
+	if n := lookups["gone"].Load(); n != docFetchAttempts {
+		t.Errorf("missing DOC looked up %d times, want %d", n, docFetchAttempts)
+	}
+	// What did arrive is kept for the next attempt
+	if _, ok := cache.getDOC("page"); !ok {
+		t.Error("fetched DOC not cached")
+	}
+}
+
+func TestReconstructAppKeepsServedVersion(t *testing.T) {
+	withDataDirs(t)
+	withTestCache(t)
+	signer := newTestSigner()
+	scid := strings.Repeat("a1", 32)
+
+	dirA := signer.doc(strings.Repeat("c1", 32), "a", "file\n")
+	inA := signer.doc(strings.Repeat("c2", 32), "b", "nested\n")
+	inA.SubDir = "a"
+	docs := map[string]tela.DOC{
+		"page":  signer.doc(strings.Repeat("d1", 32), "index.html", "<html>v1</html>\n"),
+		"v1":    signer.doc(strings.Repeat("d2", 32), "app.js", "console.log(1)\n"),
+		"v2":    signer.doc(strings.Repeat("d3", 32), "app.js", "console.log(2)\n"),
+		"only":  signer.doc(strings.Repeat("d4", 32), "main.js", "console.log(3)\n"),
+		"dirA":  dirA,
+		"fileA": inA,
+	}
+	for _, doc := range docs {
+		if err := cache.putDOC(doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	build := func(names ...string) (string, error) {
+		index := tela.INDEX{SCID: scid}
+		for _, n := range names {
+			index.DOCs = append(index.DOCs, docs[n].SCID)
+		}
+		dir, _, err := reconstructApp(scid, index, "")
+		return dir, err
+	}
+	versions := func() []string {
+		ents, _ := os.ReadDir(filepath.Join(cache.dir, "apps", scid))
+		var names []string
+		for _, e := range ents {
+			names = append(names, e.Name())
+		}
+		return names
+	}
+
+	served, err := build("page", "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := versions()
+
+	failing := []struct {
+		name string
+		docs []string
+	}{
+		{"write fails", []string{"page", "dirA", "fileA"}},
+		{"no entrypoint", []string{"only"}},
+	}
+	for _, tt := range failing {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := build(tt.docs...); err == nil {
+				t.Fatal("build succeeded")
+			}
+			if _, dir, ok := cache.lastApp(scid); !ok || dir != served {
+				t.Errorf("lastApp = %q %v, want %q", dir, ok, served)
+			}
+			if b, err := os.ReadFile(filepath.Join(served, "index.html")); err != nil || string(b) != "<html>v1</html>\n" {
+				t.Errorf("served copy = %q, %v", b, err)
+			}
+			if got := versions(); !reflect.DeepEqual(got, before) {
+				t.Errorf("app folders = %v, want %v", got, before)
+			}
+		})
+	}
+
+	// A new version leaves the served one alone until it is pruned
+	next, err := build("page", "v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next == served {
+		t.Fatal("new version built into the served folder")
+	}
+	if _, err := os.Stat(served); err != nil {
+		t.Errorf("served version removed before the new one is served: %v", err)
+	}
+	if _, dir, _ := cache.lastApp(scid); dir != next {
+		t.Errorf("lastApp = %q, want %q", dir, next)
+	}
+	cache.pruneVersions(scid)
+	if _, err := os.Stat(served); !os.IsNotExist(err) {
+		t.Errorf("old version kept after pruning: %v", err)
+	}
+	if b, err := os.ReadFile(filepath.Join(next, "app.js")); err != nil || string(b) != "console.log(2)\n" {
+		t.Errorf("new version = %q, %v", b, err)
+	}
+}
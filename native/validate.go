@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/civilware/tela"
+	"github.com/deroproject/derohe/config"
+)
+
+// docOverheadKB is the part of an installed DOC contract that is not the
+// file itself: the DVM functions and headers around the code comment.
+const docOverheadKB = tela.MAX_DOC_INSTALL_SIZE - tela.MAX_DOC_CODE_SIZE
+
+// validatedFile reports how one file of a folder would be published.
+type validatedFile struct {
+	Path           string   `json:"path"`
+	DocType        string   `json:"docType"`
+	Size           int      `json:"size"`
+	CompressedSize int      `json:"compressedSize,omitempty"`
+	Compression    string   `json:"compression,omitempty"`
+	DOCs           int      `json:"docs"`
+	InstallKB      float64  `json:"installKB"`
+	Fee            uint64   `json:"fee"` // estimated, atomic units
+	Warnings       []string `json:"warnings,omitempty"`
+	Errors         []string `json:"errors,omitempty"`
+}
+
+// validateFolder simulates publishing a folder as a TELA app: how each file
+// would be split into DOCs, whether it needs compressing or sharding, which
+// names the shard parser would misread and which page the INDEX would open.
+// Relative folders are resolved inside the developer mode root.
+func validateFolder(folder string) (map[string]any, error) {
+	if root := currentDevRoot(); !filepath.IsAbs(folder) && root != "" {
+		folder = filepath.Join(root, folder)
+	}
+	if fi, err := os.Stat(folder); err != nil || !fi.IsDir() {
+		return nil, fmt.Errorf("%s is not a folder", folder)
+	}
+
+	files := []validatedFile{}
+	needsShards := false
+	err := filepath.WalkDir(folder, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".") && p != folder {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(folder, p)
+		vf := validateFile(filepath.ToSlash(rel), data)
+		if vf.DOCs > 1 {
+			needsShards = true
+		}
+		files = append(files, vf)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%s has no files", folder)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+
+	warnings, problems := []string{}, []string{}
+	var docs int
+	var fee uint64
+	for i := range files {
+		f := &files[i]
+		// Names like "app-2.js" parse as shard 2 of "app" once the
+		// INDEX is published with a .shards dURL
+		if idx, base := detectShard(filepath.Base(f.Path), f.Compression); idx > 0 {
+			msg := fmt.Sprintf("name reads as shard %d of %q in a sharded INDEX", idx, base)
+			if needsShards {
+				f.Errors = append(f.Errors, msg)
+			} else {
+				f.Warnings = append(f.Warnings, msg)
+			}
+		}
+		docs += f.DOCs
+		fee += f.Fee
+		problems = append(problems, f.Errors...)
+	}
+
+	entry, candidates, err := findEntrypoint(folder, "")
+	switch {
+	case err != nil:
+		problems = append(problems, "no entrypoint: "+err.Error())
+	case len(candidates) > 1:
+		warnings = append(warnings, "entrypoint is ambiguous, publish the intended page as DOC1")
+	case entry != "index.html":
+		warnings = append(warnings, fmt.Sprintf("no index.html at the root, publish %s as DOC1", entry))
+	}
+
+	// The INDEX is one more contract on top of the DOCs
+	indexFee := uint64(math.Ceil(docOverheadKB)) * config.FEE_PER_KB
+
+	result := map[string]any{
+		"folder":      folder,
+		"ok":          len(problems) == 0,
+		"files":       files,
+		"entry":       entry,
+		"needsShards": needsShards,
+		"totals": map[string]any{
+			"files": len(files),
+			"docs":  docs,
+			"fee":   fee + indexFee,
+		},
+		"warnings": warnings,
+		"errors":   problems,
+	}
+	if len(candidates) > 1 {
+		result["candidates"] = candidates
+	}
+	return result, nil
+}
+
+// validateFile works out how a single file would be published. Files that
+// do not fit a DOC are compressed, and sharded if they still do not fit.
+// Fees are the per-KB transaction fee only and do not include gas.
+func validateFile(path string, data []byte) validatedFile {
+	vf := validatedFile{
+		Path:    path,
+		DocType: tela.ParseDocType(filepath.Base(path)),
+		Size:    len(data),
+	}
+	if vf.DocType == "" {
+		vf.Errors = append(vf.Errors, "file type is not supported by TELA")
+	}
+
+	code := string(data)
+	if tela.GetCodeSizeInKB(code) > tela.MAX_DOC_CODE_SIZE {
+		if compressed, err := tela.Compress(data, tela.COMPRESSION_GZIP); err == nil && len(compressed) < len(code) {
+			code = compressed
+			vf.Compression = tela.COMPRESSION_GZIP
+			vf.CompressedSize = len(compressed)
+		}
+	}
+
+	vf.DOCs = 1
+	if tela.GetCodeSizeInKB(code) > tela.MAX_DOC_CODE_SIZE {
+		vf.DOCs = int(math.Ceil(float64(len(code)) / float64(tela.SHARD_SIZE)))
+		vf.Warnings = append(vf.Warnings, fmt.Sprintf("too large for one DOC, split into %d shards", vf.DOCs))
+	}
+
+	vf.InstallKB = math.Round((float64(len(code))/1024+float64(vf.DOCs)*docOverheadKB)*100) / 100
+	for i := 0; i < vf.DOCs; i++ {
+		shardKB := math.Min(float64(len(code)-i*int(tela.SHARD_SIZE)), float64(tela.SHARD_SIZE)) / 1024
+		vf.Fee += uint64(math.Ceil(shardKB+docOverheadKB)) * config.FEE_PER_KB
+	}
+	return vf
+}
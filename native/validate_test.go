@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/civilware/tela"
+	"github.com/deroproject/derohe/config"
+)
+
+// docLimit is the most code one DOC holds before it is compressed or sharded.
+var docLimit = int(tela.MAX_DOC_CODE_SIZE * 1024)
+
+// noise returns n bytes that gzip cannot shrink.
+func noise(n int) []byte {
+	b := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(b)
+	return b
+}
+
+// docFee is the fee of installing DOCs holding the given bytes of code.
+func docFee(sizes ...int) uint64 {
+	var fee uint64
+	for _, n := range sizes {
+		fee += uint64(math.Ceil(float64(n)/1024+docOverheadKB)) * config.FEE_PER_KB
+	}
+	return fee
+}
+
+// shards splits size bytes of code the way a sharded DOC is installed.
+func shards(size int) []int {
+	var sizes []int
+	for ; size > 0; size -= int(tela.SHARD_SIZE) {
+		sizes = append(sizes, min(size, int(tela.SHARD_SIZE)))
+	}
+	return sizes
+}
+
+func TestValidateFile(t *testing.T) {
+	over, three := docLimit+1, 2*int(tela.SHARD_SIZE)+1
+	tests := []struct {
+		name       string
+		path       string
+		data       []byte
+		docType    bool
+		compressed bool
+		docs       int
+		fee        uint64 // 0 to skip
+		warning    string
+	}{
+		{"small page", "index.html", []byte("<p>hi</p>"), true, false, 1, docFee(9), ""},
+		{"in a subfolder", "css/site.css", []byte("p{}"), true, false, 1, docFee(3), ""},
+		{"at the limit", "app.js", noise(docLimit), true, false, 1, docFee(docLimit), ""},
+		{"oversize compresses into one DOC", "app.js", bytes.Repeat([]byte("let x = 1;\n"), docLimit/5), true, true, 1, 0, ""},
+		{"oversize shards", "app.js", noise(over), true, false, len(shards(over)), docFee(shards(over)...), "shards"},
+		{"three shards", "app.js", noise(three), true, false, 3, docFee(shards(three)...), "split into 3 shards"},
+		{"unsupported type", "Makefile", []byte("all:"), false, false, 1, docFee(4), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vf := validateFile(tt.path, tt.data)
+			if vf.Path != tt.path || vf.Size != len(tt.data) {
+				t.Errorf("path %q size %d, want %q %d", vf.Path, vf.Size, tt.path, len(tt.data))
+			}
+			if (vf.DocType != "") != tt.docType {
+				t.Errorf("docType %q, want detected %v", vf.DocType, tt.docType)
+			}
+			if (len(vf.Errors) > 0) == tt.docType {
+				t.Errorf("errors %v with docType %q", vf.Errors, vf.DocType)
+			}
+			if (vf.Compression != "") != tt.compressed {
+				t.Errorf("compression %q, want compressed %v", vf.Compression, tt.compressed)
+			}
+			if tt.compressed && (vf.CompressedSize == 0 || vf.CompressedSize > docLimit) {
+				t.Errorf("compressed to %d bytes, want 1..%d", vf.CompressedSize, docLimit)
+			}
+			if vf.DOCs != tt.docs {
+				t.Errorf("%d DOCs, want %d", vf.DOCs, tt.docs)
+			}
+			if tt.fee != 0 && vf.Fee != tt.fee {
+				t.Errorf("fee %d, want %d", vf.Fee, tt.fee)
+			}
+			if floor := uint64(vf.DOCs) * uint64(math.Ceil(docOverheadKB)) * config.FEE_PER_KB; vf.Fee < floor {
+				t.Errorf("fee %d is less than the DOC overhead %d", vf.Fee, floor)
+			}
+			if got := strings.Join(vf.Warnings, "; "); (tt.warning == "") != (got == "") || !strings.Contains(got, tt.warning) {
+				t.Errorf("warnings %q, want %q", got, tt.warning)
+			}
+		})
+	}
+}
+
+func TestValidateFolder(t *testing.T) {
+	big := noise(docLimit + 1)
+	tests := []struct {
+		name        string
+		files       map[string][]byte
+		ok          bool
+		needsShards bool
+		entry       string
+		warning     string
+		problem     string
+	}{
+		{"plain app", map[string][]byte{"index.html": []byte("<p>"), "app.js": []byte("1")}, true, false, "index.html", "", ""},
+		{"dotfiles are skipped", map[string][]byte{"index.html": []byte("<p>"), ".git/HEAD": []byte("ref"), ".env": []byte("x")}, true, false, "index.html", "", ""},
+		{"no index.html", map[string][]byte{"home.html": []byte("<p>")}, true, false, "home.html", "publish home.html as DOC1", ""},
+		{"unsupported file", map[string][]byte{"index.html": []byte("<p>"), "Makefile": []byte("all:")}, false, false, "index.html", "", "not supported"},
+		{"shard-like name warns", map[string][]byte{"index.html": []byte("<p>"), "app-2.js": []byte("1")}, true, false, "index.html", "", ""},
+		{"shard-like name fails when sharding", map[string][]byte{"index.html": []byte("<p>"), "big.js": big, "app-2.js": []byte("1")}, false, true, "index.html", "", "shard 2 of \"app\""},
+		{"no page", map[string][]byte{"app.js": []byte("1")}, false, false, "", "", "no entrypoint"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, data := range tt.files {
+				p := filepath.Join(dir, filepath.FromSlash(name))
+				os.MkdirAll(filepath.Dir(p), 0755)
+				if err := os.WriteFile(p, data, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			res, err := validateFolder(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res["ok"] != tt.ok || res["needsShards"] != tt.needsShards || res["entry"] != tt.entry {
+				t.Errorf("ok %v needsShards %v entry %q, want %v %v %q (errors %v)", res["ok"], res["needsShards"], res["entry"], tt.ok, tt.needsShards, tt.entry, res["errors"])
+			}
+			warnings, problems := strings.Join(res["warnings"].([]string), "; "), strings.Join(res["errors"].([]string), "; ")
+			if !strings.Contains(warnings, tt.warning) || (tt.warning == "") != (warnings == "") {
+				t.Errorf("warnings %q, want %q", warnings, tt.warning)
+			}
+			if !strings.Contains(problems, tt.problem) || (tt.problem == "") != (problems == "") {
+				t.Errorf("errors %q, want %q", problems, tt.problem)
+			}
+
+			files := res["files"].([]validatedFile)
+			var docs int
+			var fee uint64
+			for i, f := range files {
+				if strings.HasPrefix(f.Path, ".") {
+					t.Errorf("validated dotfile %s", f.Path)
+				}
+				if i > 0 && files[i-1].Path >= f.Path {
+					t.Errorf("files not sorted: %s before %s", files[i-1].Path, f.Path)
+				}
+				if f.Path == "app-2.js" && !tt.needsShards && len(f.Warnings) == 0 {
+					t.Errorf("no warning for %s", f.Path)
+				}
+				docs += f.DOCs
+				fee += f.Fee
+			}
+			totals := res["totals"].(map[string]any)
+			indexFee := uint64(math.Ceil(docOverheadKB)) * config.FEE_PER_KB
+			if totals["files"] != len(files) || totals["docs"] != docs || totals["fee"] != fee+indexFee {
+				t.Errorf("totals %v, want %d files %d docs fee %d", totals, len(files), docs, fee+indexFee)
+			}
+		})
+	}
+}
+
+func TestValidateFolderErrors(t *testing.T) {
+	if _, err := validateFolder(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("validated a missing folder")
+	}
+	if _, err := validateFolder(t.TempDir()); err == nil {
+		t.Error("validated an empty folder")
+	}
+	dir := writeTree(t, ".hidden")
+	if _, err := validateFolder(dir); err == nil {
+		t.Error("validated a folder of dotfiles")
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/civilware/tela"
+	"github.com/deroproject/derohe/cryptography/bn256"
+	"github.com/deroproject/derohe/cryptography/crypto"
+	"github.com/deroproject/derohe/rpc"
+)
+
+// errIntegrity marks loads refused because a DOC or reconstructed file did
+// not match what the chain declares. Such loads never fall back to a cached copy.
+var errIntegrity = errors.New("integrity check failed")
+
+// Verification states, per DOC and per file. A file takes the worst state of
+// its DOCs.
+const (
+	verifyOK       = "verified"
+	verifyUnsigned = "unsigned"
+	verifyMismatch = "mismatch"
+)
+
+type fileCheck struct {
+	Path   string   `json:"path"`
+	DOCs   []string `json:"docs"`
+	Status string   `json:"status"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// verifyDOCSignature checks the checkC/checkS signature a DOC declares over
+// its code against the DOC author's key. This is the Schnorr scheme DERO
+// wallets use for signed files. Unsigned and anonymous DOCs return false
+// with no error.
+func verifyDOCSignature(doc tela.DOC) (bool, error) {
+	if doc.CheckC == "" || doc.CheckS == "" {
+		return false, nil
+	}
+	addr, err := rpc.NewAddress(doc.Author)
+	if err != nil {
+		// Anonymous DOCs carry no address to check the signature against
+		return false, nil
+	}
+	c, ok := new(big.Int).SetString(doc.CheckC, 16)
+	if !ok {
+		return false, fmt.Errorf("malformed checkC")
+	}
+	s, ok := new(big.Int).SetString(doc.CheckS, 16)
+	if !ok {
+		return false, fmt.Errorf("malformed checkS")
+	}
+	raw, err := parseShardRawBytes(doc)
+	if err != nil {
+		return false, err
+	}
+
+	pub := addr.PublicKey.G1()
+	point := new(bn256.G1).Add(new(bn256.G1).ScalarMult(crypto.G, s), new(bn256.G1).ScalarMult(pub, new(big.Int).Neg(c)))
+
+	// The signed file may or may not have carried the newline that closes
+	// the DOC comment block
+	for _, msg := range [][]byte{raw, bytes.TrimSuffix(raw, []byte("\n"))} {
+		serialize := []byte(fmt.Sprintf("%s%s%x", pub.String(), point.String(), msg))
+		if crypto.ReducedHash(serialize).String() == c.String() {
+			return true, nil
+		}
+	}
+	return false, fmt.Errorf("signature does not match author %s", doc.Author)
+}
+
+// verifyDOCs checks every DOC of an INDEX and the files they assemble into.
+// docSCIDs is the INDEX DOC list, docs the fetched contracts in the same
+// order. Returns the per-file report and whether anything mismatched.
+func verifyDOCs(docSCIDs []string, docs []tela.DOC, files map[string]*appFile) ([]fileCheck, bool) {
+	docStatus := make(map[string]string, len(docs))
+	docErrors := map[string][]string{}
+	seen := map[string]bool{}
+
+	for i, doc := range docs {
+		want := docSCIDs[i]
+		status := verifyOK
+
+		switch signed, err := verifyDOCSignature(doc); {
+		case err != nil:
+			status = verifyMismatch
+			docErrors[want] = append(docErrors[want], fmt.Sprintf("%s: %v", want, err))
+		case !signed:
+			status = verifyUnsigned
+		}
+		if doc.SCID != want {
+			status = verifyMismatch
+			docErrors[want] = append(docErrors[want], fmt.Sprintf("%s: daemon returned DOC %s", want, doc.SCID))
+		}
+		if seen[want] {
+			status = verifyMismatch
+			docErrors[want] = append(docErrors[want], fmt.Sprintf("%s: listed more than once in INDEX", want))
+		}
+		seen[want] = true
+		docStatus[want] = status
+	}
+
+	failed := false
+	checks := make([]fileCheck, 0, len(files))
+	used := map[string]bool{}
+	for path, f := range files {
+		fc := fileCheck{Path: path, DOCs: f.docs, Status: verifyOK}
+		for _, d := range f.docs {
+			used[d] = true
+			fc.Errors = append(fc.Errors, docErrors[d]...)
+			status, listed := docStatus[d]
+			if !listed {
+				status = verifyMismatch
+				fc.Errors = append(fc.Errors, d+": not listed in INDEX")
+			}
+			switch status {
+			case verifyMismatch:
+				fc.Status = verifyMismatch
+			case verifyUnsigned:
+				if fc.Status == verifyOK {
+					fc.Status = verifyUnsigned
+				}
+			}
+		}
+		if fc.Status == verifyMismatch {
+			failed = true
+		}
+		checks = append(checks, fc)
+	}
+
+	// Every DOC the INDEX lists must end up in exactly one served file
+	for _, d := range docSCIDs {
+		if !used[d] {
+			failed = true
+			checks = append(checks, fileCheck{
+				DOCs:   []string{d},
+				Status: verifyMismatch,
+				Errors: []string{d + ": not part of any reconstructed file"},
+			})
+		}
+	}
+
+	sort.Slice(checks, func(i, j int) bool { return checks[i].Path < checks[j].Path })
+	return checks, failed
+}
+
+// fileMetas returns the hash and declared type of each reconstructed file,
+// keyed by slash-separated path.
+func fileMetas(files map[string]*appFile) map[string]fileMeta {
+	metas := make(map[string]fileMeta, len(files))
+	for path, f := range files {
+		sum := sha256.Sum256(f.data)
+		metas[filepath.ToSlash(path)] = fileMeta{
+			Hash:        hex.EncodeToString(sum[:]),
+			DocType:     f.docType,
+			Compression: f.compression,
+			Encoded:     f.encoded != nil,
+			Brotli:      f.brotli != nil,
+			DOCs:        f.docs,
+		}
+	}
+	return metas
+}
+
+// checkAppDir compares the files on disk against the hashes recorded when
+// the app was reconstructed and returns the paths that differ.
+func checkAppDir(dir string, metas map[string]fileMeta) []string {
+	var bad []string
+	for path, meta := range metas {
+		b, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(path)))
+		if err != nil {
+			bad = append(bad, path)
+			continue
+		}
+		sum := sha256.Sum256(b)
+		if hex.EncodeToString(sum[:]) != meta.Hash {
+			bad = append(bad, path)
+		}
+	}
+	sort.Strings(bad)
+	return bad
+}
+
+// verifySCID re-verifies a SCID from its cached DOCs, fetching any that are
+// missing, and reports per-file status including the copy on disk.
+func verifySCID(scid string) (map[string]any, error) {
+	var docSCIDs []string
+	var durl string
+	node := currentNode()
+
+	if e, _, ok := cache.lastApp(scid); ok {
+		docSCIDs, durl = e.DOCs, e.DURL
+	} else if node != "" {
+		index, err := tela.GetINDEXInfo(scid, strings.TrimPrefix(node, "http://"))
+		if err != nil {
+			return nil, err
+		}
+		docSCIDs, durl = index.DOCs, index.DURL
+	} else {
+		return nil, fmt.Errorf("SCID not cached and node not set")
+	}
+
+	docs := make([]tela.DOC, len(docSCIDs))
+	for i, docSCID := range docSCIDs {
+		doc, ok := cache.getDOC(docSCID)
+		if !ok {
+			if node == "" {
+				return nil, fmt.Errorf("%s: not cached and node not set", docSCID)
+			}
+			var err error
+			if doc, err = fetchDOC(docSCID, strings.TrimPrefix(node, "http://")); err != nil {
+				return nil, err
+			}
+		}
+		docs[i] = doc
+	}
+
+	files, err := assembleFiles(docs, strings.HasSuffix(durl, tela.TAG_DOC_SHARDS))
+	if err != nil {
+		return map[string]any{"scid": scid, "ok": false, "error": err.Error()}, nil
+	}
+	checks, failed := verifyDOCs(docSCIDs, docs, files)
+
+	// Compare the copy on disk with the freshly assembled files
+	if e, dir, ok := cache.lastApp(scid); ok && e.Hash == appVersion(docs) {
+		bad := map[string]bool{}
+		for _, p := range checkAppDir(dir, fileMetas(files)) {
+			bad[p] = true
+		}
+		for i := range checks {
+			if bad[filepath.ToSlash(checks[i].Path)] {
+				checks[i].Status = verifyMismatch
+				checks[i].Errors = append(checks[i].Errors, "file on disk differs from reconstruction")
+				failed = true
+			}
+		}
+	}
+
+	return map[string]any{"scid": scid, "ok": !failed, "files": checks}, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/civilware/tela"
+	"github.com/deroproject/derohe/cryptography/bn256"
+	"github.com/deroproject/derohe/cryptography/crypto"
+	"github.com/deroproject/derohe/rpc"
+)
+
+// testSigner signs DOC code the way a DERO wallet signs files.
+type testSigner struct {
+	secret  *big.Int
+	pub     *bn256.G1
+	address string
+}
+
+func newTestSigner() *testSigner {
+	secret := crypto.RandomScalar()
+	pub := new(bn256.G1).ScalarMult(crypto.G, secret)
+	return &testSigner{secret, pub, rpc.NewAddressFromKeys((*crypto.Point)(pub)).String()}
+}
+
+// doc returns a DOC holding content, signed by s.
+func (s *testSigner) doc(scid, name, content string) tela.DOC {
+	doc := tela.DOC{
+		SCID:    scid,
+		DocType: "TELA-HTML-1",
+		Code:    "/*\n" + content + "*/",
+		Author:  s.address,
+		Headers: tela.Headers{NameHdr: name},
+	}
+	raw, _ := parseShardRawBytes(doc)
+	k := crypto.RandomScalar()
+	point := new(bn256.G1).ScalarMult(crypto.G, k)
+	c := crypto.ReducedHash([]byte(fmt.Sprintf("%s%s%x", s.pub.String(), point.String(), raw)))
+	sig := new(big.Int).Mul(c, s.secret)
+	sig.Mod(sig, bn256.Order)
+	sig.Add(sig, k)
+	sig.Mod(sig, bn256.Order)
+	doc.CheckC, doc.CheckS = fmt.Sprintf("%x", c), fmt.Sprintf("%x", sig)
+	return doc
+}
+
+func TestVerifyDOCSignature(t *testing.T) {
+	alice, bob := newTestSigner(), newTestSigner()
+
+	tampered := alice.doc("d1", "index.html", "<p>hi</p>\n")
+	tampered.Code = "/*\n<p>bye</p>\n*/"
+	stolen := alice.doc("d1", "index.html", "<p>hi</p>\n")
+	stolen.Author = bob.address
+	anonymous := alice.doc("d1", "index.html", "<p>hi</p>\n")
+	anonymous.Author = "anon"
+	malformed := alice.doc("d1", "index.html", "<p>hi</p>\n")
+	malformed.CheckS = "zz"
+
+	tests := []struct {
+		name    string
+		doc     tela.DOC
+		signed  bool
+		wantErr bool
+	}{
+		{"signed", alice.doc("d1", "index.html", "<p>hi</p>\n"), true, false},
+		{"unsigned", tela.DOC{Code: "/*\nx*/", Author: alice.address}, false, false},
+		{"anonymous", anonymous, false, false},
+		{"tampered code", tampered, false, true},
+		{"claimed by another author", stolen, false, true},
+		{"malformed signature", malformed, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signed, err := verifyDOCSignature(tt.doc)
+			if signed != tt.signed || (err != nil) != tt.wantErr {
+				t.Errorf("got %v, %v, want %v, error %v", signed, err, tt.signed, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyDOCs(t *testing.T) {
+	s := newTestSigner()
+	page := s.doc("d1", "index.html", "<p>hi</p>\n")
+	script := s.doc("d2", "app.js", "go()\n")
+	unsigned := tela.DOC{SCID: "d3", Code: "/*\nbody{}\n*/", Headers: tela.Headers{NameHdr: "style.css"}}
+	forged := s.doc("d2", "app.js", "go()\n")
+	forged.Code = "/*\nsteal()\n*/"
+
+	tests := []struct {
+		name     string
+		docSCIDs []string
+		docs     []tela.DOC
+		want     map[string]string // path -> status
+		failed   bool
+	}{
+		{"all signed", []string{"d1", "d2"}, []tela.DOC{page, script},
+			map[string]string{"index.html": verifyOK, "app.js": verifyOK}, false},
+		{"unsigned DOC", []string{"d1", "d3"}, []tela.DOC{page, unsigned},
+			map[string]string{"index.html": verifyOK, "style.css": verifyUnsigned}, false},
+		{"forged DOC", []string{"d1", "d2"}, []tela.DOC{page, forged},
+			map[string]string{"index.html": verifyOK, "app.js": verifyMismatch}, true},
+		{"daemon returned another DOC", []string{"d1", "d9"}, []tela.DOC{page, script},
+			map[string]string{"index.html": verifyOK, "app.js": verifyMismatch, "": verifyMismatch}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, err := assembleFiles(tt.docs, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checks, failed := verifyDOCs(tt.docSCIDs, tt.docs, files)
+			got := map[string]string{}
+			for _, c := range checks {
+				got[c.Path] = c.Status
+			}
+			if failed != tt.failed || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v failed=%v, want %v failed=%v", got, failed, tt.want, tt.failed)
+			}
+		})
+	}
+}
+
+func TestCheckAppDir(t *testing.T) {
+	s := newTestSigner()
+	files, err := assembleFiles([]tela.DOC{s.doc("d1", "index.html", "<p>hi</p>\n"), s.doc("d2", "app.js", "go()\n")}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for key, f := range files {
+		os.WriteFile(filepath.Join(dir, key), f.data, 0644)
+	}
+	metas := fileMetas(files)
+	if bad := checkAppDir(dir, metas); len(bad) != 0 {
+		t.Fatalf("intact folder reported %v", bad)
+	}
+
+	os.WriteFile(filepath.Join(dir, "app.js"), []byte("changed"), 0644)
+	os.Remove(filepath.Join(dir, "index.html"))
+	bad := checkAppDir(dir, metas)
+	if len(bad) != 2 {
+		t.Errorf("changed and missing files reported as %v", bad)
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/deroproject/derohe/rpc"
+	"github.com/gorilla/websocket"
+)
+
+// The host talks to the user's wallet either through the wallet's RPC server
+// (simulator wallets expose one on 127.0.0.1:30000) or through XSWD, where
+// the wallet asks the user to approve the connection and each request.
+
+const (
+	walletModeRPC  = "rpc"
+	walletModeXSWD = "xswd"
+
+	defaultWalletRPC  = "http://127.0.0.1:10103/json_rpc"
+	defaultXSWD       = "ws://127.0.0.1:44326/xswd"
+	walletCallTimeout = 5 * time.Minute // long enough for the user to approve in the wallet
+)
+
+// errSigningUnsupported is returned by wallets that cannot sign data. The
+// wallet RPC server has no SignData method; XSWD does.
+var errSigningUnsupported = errors.New("wallet connection cannot sign data, connect through XSWD")
+
+// xswdAppID identifies PureWolf to XSWD wallets.
+var xswdAppID = func() string {
+	sum := sha256.Sum256([]byte("PureWolf"))
+	return hex.EncodeToString(sum[:])
+}()
+
+type walletConn interface {
+	call(method string, params, out any) error
+	mode() string
+	close()
+}
+
+var (
+	walletMu      sync.Mutex
+	wallet        walletConn
+	walletAddress string
+)
+
+// -------------------- WALLET RPC --------------------
+
+type rpcWallet struct {
+	endpoint   string
+	user, pass string
+}
+
+func (w *rpcWallet) call(method string, params, out any) error {
+	return callRPC(w.endpoint, w.user, w.pass, walletCallTimeout, method, params, out)
+}
+
+func (w *rpcWallet) mode() string { return walletModeRPC }
+func (w *rpcWallet) close()       {}
+
+// -------------------- XSWD --------------------
+
+type xswdWallet struct {
+	mu     sync.Mutex
+	conn   *websocket.Conn
+	nextID int
+}
+
+// dialXSWD connects to an XSWD server and registers PureWolf as an
+// application, which the wallet asks the user to accept.
+func dialXSWD(endpoint string) (*xswdWallet, error) {
+	conn, _, err := websocket.DefaultDialer.Dial(endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	app := map[string]any{
+		"id":          xswdAppID,
+		"name":        "PureWolf",
+		"description": "PureWolf TELA browser host",
+		"url":         telaOrigin(),
+	}
+	if err := conn.WriteJSON(app); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	var auth struct {
+		Message  string `json:"message"`
+		Accepted bool   `json:"accepted"`
+	}
+	conn.SetReadDeadline(time.Now().Add(walletCallTimeout))
+	if err := conn.ReadJSON(&auth); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !auth.Accepted {
+		conn.Close()
+		return nil, fmt.Errorf("XSWD connection refused: %s", auth.Message)
+	}
+	return &xswdWallet{conn: conn}, nil
+}
+
+func (w *xswdWallet) call(method string, params, out any) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.nextID++
+	id := strconv.Itoa(w.nextID)
+	req := map[string]any{"jsonrpc": "2.0", "id": id, "method": method}
+	if params != nil {
+		req["params"] = params
+	}
+	if err := w.conn.WriteJSON(req); err != nil {
+		return err
+	}
+
+	// Event notifications may arrive before the response
+	w.conn.SetReadDeadline(time.Now().Add(walletCallTimeout))
+	for {
+		var res struct {
+			ID     string          `json:"id"`
+			Result json.RawMessage `json:"result"`
+			Error  *struct {
+				Code    int    `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := w.conn.ReadJSON(&res); err != nil {
+			return err
+		}
+		if res.ID != id {
+			continue
+		}
+		if res.Error != nil {
+			return fmt.Errorf("%s: %s", method, res.Error.Message)
+		}
+		if out == nil {
+			return nil
+		}
+		return json.Unmarshal(res.Result, out)
+	}
+}
+
+func (w *xswdWallet) mode() string { return walletModeXSWD }
+func (w *xswdWallet) close()       { w.conn.Close() }
+
+// -------------------- CONNECTION --------------------
+
+// connectWallet replaces the current wallet connection and checks it by
+// asking for the wallet address.
+func connectWallet(mode, endpoint, user, pass string) (map[string]any, error) {
+	var conn walletConn
+	switch mode {
+	case walletModeRPC, "":
+		if endpoint == "" {
+			endpoint = defaultWalletRPC
+		}
+		if !strings.HasPrefix(endpoint, "http") {
+			endpoint = "http://" + endpoint
+		}
+		if !strings.HasSuffix(endpoint, "/json_rpc") {
+			endpoint = strings.TrimSuffix(endpoint, "/") + "/json_rpc"
+		}
+		conn = &rpcWallet{endpoint: endpoint, user: user, pass: pass}
+	case walletModeXSWD:
+		if endpoint == "" {
+			endpoint = defaultXSWD
+		}
+		x, err := dialXSWD(endpoint)
+		if err != nil {
+			return nil, err
+		}
+		conn = x
+	default:
+		return nil, fmt.Errorf("wallet mode must be rpc or xswd")
+	}
+
+	var addr rpc.GetAddress_Result
+	if err := conn.call("GetAddress", nil, &addr); err != nil {
+		conn.close()
+		return nil, err
+	}
+
+	walletMu.Lock()
+	if wallet != nil {
+		wallet.close()
+	}
+	wallet, walletAddress = conn, addr.Address
+	walletMu.Unlock()
+
+	logNative.Info("wallet connected", "mode", conn.mode(), "address", shortAddr(addr.Address))
+	return walletStatus(), nil
+}
+
+func disconnectWallet() {
+	walletMu.Lock()
+	defer walletMu.Unlock()
+	if wallet != nil {
+		wallet.close()
+	}
+	wallet, walletAddress = nil, ""
+}
+
+func walletStatus() map[string]any {
+	walletMu.Lock()
+	defer walletMu.Unlock()
+	if wallet == nil {
+		return map[string]any{"connected": false}
+	}
+	return map[string]any{
+		"connected": true,
+		"mode":      wallet.mode(),
+		"address":   walletAddress,
+	}
+}
+
+func currentWallet() (walletConn, error) {
+	walletMu.Lock()
+	defer walletMu.Unlock()
+	if wallet == nil {
+		return nil, fmt.Errorf("wallet not connected")
+	}
+	return wallet, nil
+}
+
+// -------------------- TRANSACTIONS --------------------
+
+// walletSign signs data with the wallet key and returns the checkC/checkS
+// pair TELA DOCs carry.
+func walletSign(data []byte) (c, s string, err error) {
+	w, err := currentWallet()
+	if err != nil {
+		return "", "", err
+	}
+	if w.mode() != walletModeXSWD {
+		return "", "", errSigningUnsupported
+	}
+
+	var res struct {
+		Signature []byte `json:"signature"`
+	}
+	if err := w.call("SignData", data, &res); err != nil {
+		return "", "", err
+	}
+	block, _ := pem.Decode(res.Signature)
+	if block == nil || block.Headers["C"] == "" || block.Headers["S"] == "" {
+		return "", "", fmt.Errorf("wallet returned a malformed signature")
+	}
+	return block.Headers["C"], block.Headers["S"], nil
+}
+
+// walletTransfer submits a transaction through the wallet and returns its txid.
+func walletTransfer(params rpc.Transfer_Params) (string, error) {
+	w, err := currentWallet()
+	if err != nil {
+		return "", err
+	}
+	var res rpc.Transfer_Result
+	if err := w.call("transfer", params, &res); err != nil {
+		return "", err
+	}
+	if res.TXID == "" {
+		return "", fmt.Errorf("wallet returned no txid")
+	}
+	return res.TXID, nil
+}
+
+const (
+	txPollInterval = 2 * time.Second
+	txWaitTimeout  = 10 * time.Minute
+)
+
+// waitForTx polls the daemon until txid is mined in a block. Returns the
+// block height it was included at.
+func waitForTx(txid string) (int64, error) {
+	node := currentNode()
+	if node == "" {
+		return 0, fmt.Errorf("node not set")
+	}
+
+	deadline := time.Now().Add(txWaitTimeout)
+	for time.Now().Before(deadline) {
+		var res rpc.GetTransaction_Result
+		err := callDaemon(node, "DERO.GetTransaction", rpc.GetTransaction_Params{Tx_Hashes: []string{txid}}, &res)
+		if err == nil && len(res.Txs) > 0 {
+			tx := res.Txs[0]
+			if !tx.In_pool && tx.ValidBlock != "" {
+				return tx.Block_Height, nil
+			}
+			if !tx.In_pool && len(tx.InvalidBlock) > 0 && tx.ValidBlock == "" {
+				return 0, fmt.Errorf("transaction %s was rejected", txid)
+			}
+		}
+		time.Sleep(txPollInterval)
+	}
+	return 0, fmt.Errorf("transaction %s not confirmed after %s", txid, txWaitTimeout)
+}